@@ -0,0 +1,101 @@
+// Package afsk implements a Bell 202 AFSK 1200 baud modem with HDLC framing, as used by APRS and packet radio.
+//
+// The Modulator converts frames to audio samples, the Demodulator extracts the frames from the received audio.
+// Frames are passed without the HDLC flags and FCS (i.e. AX.25 frames, see package ax25).
+// FSK and BitDemodulator can also be used for other FSK modes, with different baud rates and tones.
+package afsk
+
+const (
+	Baud      = 1200
+	MarkFreq  = 1200 // Hz, binary 1
+	SpaceFreq = 2200 // Hz, binary 0
+
+	DefaultAmplitude = 0.5
+)
+
+// Modulator generates the audio for HDLC frames
+type Modulator struct {
+	SampleRate int
+	Amplitude  float64 // 0-1 (default: DefaultAmplitude)
+
+	// Preamble and Postamble are the number of flags sent before and after the frames (TXDELAY and TXTAIL)
+	Preamble  int
+	Postamble int
+}
+
+// NewModulator returns a Modulator for the specified sample rate
+func NewModulator(sampleRate int) *Modulator {
+	return &Modulator{SampleRate: sampleRate, Amplitude: DefaultAmplitude, Preamble: 30, Postamble: 3}
+}
+
+// Modulate returns the audio samples for the frames, sent in a single transmission
+func (m *Modulator) Modulate(frames ...[]byte) []int16 {
+	var bits []byte
+	for i, f := range frames {
+		preamble := m.Preamble
+		if i > 0 {
+			preamble = 1 // frames are separated by a single flag
+		}
+		postamble := 0
+		if i == len(frames)-1 {
+			postamble = max(1, m.Postamble)
+		}
+
+		bits = append(bits, hdlcBits(f, max(1, preamble), postamble)...)
+	}
+
+	amplitude := m.Amplitude
+	if amplitude == 0 {
+		amplitude = DefaultAmplitude
+	}
+
+	// NRZI: 0 is a change of tone, 1 no change
+	marks := make([]bool, len(bits))
+	mark := true
+	for i, bit := range bits {
+		if bit == 0 {
+			mark = !mark
+		}
+		marks[i] = mark
+	}
+
+	return FSK(m.SampleRate, Baud, MarkFreq, SpaceFreq, amplitude, marks)
+}
+
+// Demodulator decodes the HDLC frames from the received audio.
+// It implements kv4pht.AudioSink, so it can be added to a CommandProcessor.
+type Demodulator struct {
+	bits *BitDemodulator
+
+	// only accessed by the bits callback, with the BitDemodulator lock held
+	lastTone bool // tone of the previous bit, for NRZI decoding
+	deframer deframer
+}
+
+// NewDemodulator returns a Demodulator for audio at sampleRate, that calls onFrame with each frame
+// with a valid FCS. onFrame is called from WriteAudio, so it should not block.
+func NewDemodulator(sampleRate int, onFrame func(frame []byte)) *Demodulator {
+	d := &Demodulator{}
+	d.deframer.onFrame = onFrame
+	d.bits = NewBitDemodulator(sampleRate, Baud, MarkFreq, SpaceFreq, func(mark bool) {
+		bit := byte(0)
+		if mark == d.lastTone {
+			bit = 1
+		}
+		d.lastTone = mark
+
+		d.deframer.bit(bit)
+	})
+
+	return d
+}
+
+// WriteAudio demodulates the samples
+func (d *Demodulator) WriteAudio(samples []int16) error {
+	return d.bits.WriteAudio(samples)
+}
+
+// DCD returns true when a signal is being demodulated (data carrier detect)
+func (d *Demodulator) DCD() bool {
+	return d.bits.DCD()
+}
@@ -0,0 +1,81 @@
+package afsk
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFCS(t *testing.T) {
+	// check value of CRC-16/X-25
+	if fcs := FCS([]byte("123456789")); fcs != 0x906E {
+		t.Errorf("unexpected FCS %04x", fcs)
+	}
+}
+
+func testFrame(n int, fill byte) []byte {
+	frame := make([]byte, n)
+	for i := range frame {
+		frame[i] = fill + byte(i)
+	}
+	return frame
+}
+
+func TestLoopback(t *testing.T) {
+	frames := [][]byte{
+		testFrame(20, 0x40),
+		bytes.Repeat([]byte{0xFF}, 40), // bit stuffing
+		bytes.Repeat([]byte{0x7E}, 30), // flags in the data
+		testFrame(300, 0),
+	}
+
+	for _, rate := range []int{48000, 44100, 22050} {
+		var received [][]byte
+		d := NewDemodulator(rate, func(frame []byte) { received = append(received, frame) })
+
+		m := NewModulator(rate)
+		m.Amplitude = 0.3
+
+		// silence, the frames in two transmissions, with noise
+		rnd := rand.New(rand.NewSource(1))
+		noise := func(samples []int16) []int16 {
+			for i := range samples {
+				samples[i] += int16(rnd.NormFloat64() * 0.05 * math.MaxInt16)
+			}
+			return samples
+		}
+
+		d.WriteAudio(noise(make([]int16, rate/10)))
+		d.WriteAudio(noise(m.Modulate(frames[:2]...)))
+		d.WriteAudio(noise(make([]int16, rate/10)))
+		if d.DCD() {
+			t.Errorf("%d: DCD on noise", rate)
+		}
+		d.WriteAudio(noise(m.Modulate(frames[2:]...)))
+		d.WriteAudio(make([]int16, rate/10))
+		if d.DCD() {
+			t.Errorf("%d: DCD on silence", rate)
+		}
+
+		if len(received) != len(frames) {
+			t.Fatalf("%d: received %d frames, expected %d", rate, len(received), len(frames))
+		}
+		for i := range frames {
+			if !bytes.Equal(received[i], frames[i]) {
+				t.Errorf("%d: frame %d: received % x", rate, i, received[i])
+			}
+		}
+	}
+}
+
+func TestNoise(t *testing.T) {
+	d := NewDemodulator(48000, func(frame []byte) { t.Errorf("frame decoded from noise: % x", frame) })
+
+	rnd := rand.New(rand.NewSource(2))
+	samples := make([]int16, 48000*5)
+	for i := range samples {
+		samples[i] = int16(rnd.NormFloat64() * 0.2 * math.MaxInt16)
+	}
+	d.WriteAudio(samples)
+}
@@ -0,0 +1,191 @@
+package afsk
+
+import (
+	"math"
+	"sync"
+)
+
+// FSK returns the phase continuous audio for a sequence of tones (true for mark, false for space),
+// each lasting one bit at the specified baud rate. The amplitude is 0-1.
+func FSK(sampleRate int, baud, markFreq, spaceFreq, amplitude float64, marks []bool) []int16 {
+	samplesPerBit := float64(sampleRate) / baud
+	out := make([]int16, 0, int(float64(len(marks))*samplesPerBit)+1)
+
+	var phase, t float64
+	for _, mark := range marks {
+		freq := spaceFreq
+		if mark {
+			freq = markFreq
+		}
+		step := 2 * math.Pi * freq / float64(sampleRate)
+
+		for t += samplesPerBit; t >= 1; t-- {
+			out = append(out, int16(amplitude*math.MaxInt16*math.Sin(phase)))
+			if phase += step; phase > 2*math.Pi {
+				phase -= 2 * math.Pi
+			}
+		}
+	}
+
+	return out
+}
+
+// BitDemodulator recovers the tones of an FSK signal (i.e. the bits, before any line decoding)
+// at the specified baud rate, with clock recovery.
+type BitDemodulator struct {
+	mu sync.Mutex
+
+	window int // correlation window (one bit)
+
+	// local oscillators, and the products of the samples with them for the last window
+	markPhase, spacePhase float64
+	markStep, spaceStep   float64
+	products              [4][]float64 // mark I/Q, space I/Q
+	sums                  [4]float64
+	pos                   int
+
+	// input band-pass filter
+	filter    []float64
+	history   []float64
+	hpos      int
+	agcLevel  float64
+	lastDelta float64
+
+	// clock recovery
+	pll     float64 // bit phase (-0.5 - 0.5), bits are sampled when it wraps
+	pllStep float64
+	dcd     int // increases with good transitions, decreases with bad ones
+	quiet   int // bits since the last transition
+
+	onBit func(mark bool)
+}
+
+// pll inertia: how much the clock follows the transitions (lower is faster)
+const (
+	pllLocked    = 0.75
+	pllSearching = 0.5
+	dcdThreshold = 32
+)
+
+// NewBitDemodulator returns a BitDemodulator for audio at sampleRate, that calls onBit with the tone
+// of each bit (true for mark). onBit is called from WriteAudio, so it should not block.
+func NewBitDemodulator(sampleRate int, baud, markFreq, spaceFreq float64, onBit func(mark bool)) *BitDemodulator {
+	window := int(math.Round(float64(sampleRate) / baud))
+	low, high := min(markFreq, spaceFreq)-baud/4, max(markFreq, spaceFreq)+baud/4
+
+	d := &BitDemodulator{
+		window:    window,
+		markStep:  2 * math.Pi * markFreq / float64(sampleRate),
+		spaceStep: 2 * math.Pi * spaceFreq / float64(sampleRate),
+		pllStep:   baud / float64(sampleRate),
+		filter:    bandPass(sampleRate, low, high, 2*window+1),
+		agcLevel:  0.01,
+		onBit:     onBit,
+	}
+
+	for i := range d.products {
+		d.products[i] = make([]float64, d.window)
+	}
+	d.history = make([]float64, len(d.filter))
+
+	return d
+}
+
+// WriteAudio demodulates the samples
+func (d *BitDemodulator) WriteAudio(samples []int16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range samples {
+		d.sample(float64(s) / math.MaxInt16)
+	}
+	return nil
+}
+
+// DCD returns true when a signal is being demodulated (data carrier detect)
+func (d *BitDemodulator) DCD() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dcd >= dcdThreshold
+}
+
+func (d *BitDemodulator) sample(s float64) {
+	// band-pass filter
+	d.history[d.hpos] = s
+	d.hpos = (d.hpos + 1) % len(d.history)
+
+	var x float64
+	for i, c := range d.filter {
+		x += c * d.history[(d.hpos+i)%len(d.history)]
+	}
+
+	// correlate with the mark and space tones over one bit
+	mc, ms := math.Cos(d.markPhase), math.Sin(d.markPhase)
+	sc, ss := math.Cos(d.spacePhase), math.Sin(d.spacePhase)
+	d.markPhase = math.Mod(d.markPhase+d.markStep, 2*math.Pi)
+	d.spacePhase = math.Mod(d.spacePhase+d.spaceStep, 2*math.Pi)
+
+	for i, v := range [4]float64{x * mc, x * ms, x * sc, x * ss} {
+		d.sums[i] += v - d.products[i][d.pos]
+		d.products[i][d.pos] = v
+	}
+	d.pos = (d.pos + 1) % d.window
+
+	mark := d.sums[0]*d.sums[0] + d.sums[1]*d.sums[1]
+	space := d.sums[2]*d.sums[2] + d.sums[3]*d.sums[3]
+
+	// normalize the difference, so that the decision doesn't depend on the signal level
+	delta := mark - space
+	d.agcLevel = max(1e-9, d.agcLevel*0.999+math.Abs(delta)*0.001)
+	delta /= d.agcLevel
+
+	// clock recovery: move the sampling point half a bit away from the transitions
+	if (delta > 0) != (d.lastDelta > 0) {
+		inertia := pllSearching
+		if d.dcd >= dcdThreshold {
+			inertia = pllLocked
+		}
+
+		if math.Abs(d.pll) < 0.25 {
+			d.dcd = min(dcdThreshold*2, d.dcd+1)
+		} else {
+			d.dcd = max(0, d.dcd-2)
+		}
+		d.pll *= inertia
+		d.quiet = 0
+	}
+	d.lastDelta = delta
+
+	if d.pll += d.pllStep; d.pll >= 0.5 {
+		d.pll -= 1
+
+		// HDLC bit stuffing and the SAME preamble guarantee frequent transitions: the signal is gone
+		if d.quiet++; d.quiet > 8 {
+			d.dcd = 0
+		}
+
+		d.onBit(delta > 0)
+	}
+}
+
+// bandPass returns the coefficients of a windowed sinc band-pass FIR filter
+func bandPass(sampleRate int, low, high float64, taps int) []float64 {
+	coeffs := make([]float64, taps)
+	center := float64(taps-1) / 2
+	fl, fh := low/float64(sampleRate), high/float64(sampleRate)
+
+	for i := range coeffs {
+		x := float64(i) - center
+		var h float64
+		if x == 0 {
+			h = 2 * (fh - fl)
+		} else {
+			h = (math.Sin(2*math.Pi*fh*x) - math.Sin(2*math.Pi*fl*x)) / (math.Pi * x)
+		}
+
+		// Hamming window
+		coeffs[i] = h * (0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(taps-1)))
+	}
+
+	return coeffs
+}
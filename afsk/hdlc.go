@@ -0,0 +1,131 @@
+package afsk
+
+const (
+	hdlcFlag   = 0x7E
+	maxFrame   = 1024 // bytes, FCS included
+	minFrame   = 17   // 2 addresses, control and FCS
+	fcsInit    = 0xFFFF
+	fcsPoly    = 0x8408 // CRC-16-CCITT, reversed
+	fcsResidue = 0xF0B8 // CRC of a frame including its FCS
+)
+
+// FCS returns the frame check sequence (CRC-16-CCITT, as used by HDLC and AX.25) of data
+func FCS(data []byte) uint16 {
+	return ^crc(fcsInit, data)
+}
+
+func crc(c uint16, data []byte) uint16 {
+	for _, b := range data {
+		c ^= uint16(b)
+		for range 8 {
+			if c&1 != 0 {
+				c = c>>1 ^ fcsPoly
+			} else {
+				c >>= 1
+			}
+		}
+	}
+	return c
+}
+
+// hdlcBits returns the bits (before NRZI encoding) of an HDLC frame: the flags, and the data
+// followed by its FCS, bit stuffed
+func hdlcBits(data []byte, preamble, postamble int) []byte {
+	bits := make([]byte, 0, (preamble+postamble+len(data)+2)*10)
+
+	flag := func() {
+		for i := range 8 {
+			bits = append(bits, hdlcFlag>>i&1)
+		}
+	}
+
+	for range preamble {
+		flag()
+	}
+
+	fcs := FCS(data)
+	ones := 0
+	for _, b := range append(data[:len(data):len(data)], byte(fcs), byte(fcs>>8)) {
+		for i := range 8 { // LSB first
+			bit := b >> i & 1
+			bits = append(bits, bit)
+
+			if bit == 0 {
+				ones = 0
+			} else if ones++; ones == 5 {
+				bits = append(bits, 0)
+				ones = 0
+			}
+		}
+	}
+
+	for range postamble {
+		flag()
+	}
+
+	return bits
+}
+
+// deframer extracts the HDLC frames from a stream of (NRZI decoded) bits
+type deframer struct {
+	last    byte // last 8 bits received, the most recent in the MSB
+	ones    int  // consecutive ones
+	inFrame bool
+	bits    int // bits in the current byte
+	cur     byte
+	frame   []byte
+
+	onFrame func(frame []byte)
+}
+
+func (d *deframer) reset() {
+	d.bits, d.cur = 0, 0
+	d.frame = d.frame[:0]
+}
+
+func (d *deframer) bit(bit byte) {
+	d.last = d.last>>1 | bit<<7
+
+	if d.last == hdlcFlag {
+		// the flag bits (but the last) were added to the frame: the frame is complete if they were aligned
+		if d.inFrame && d.bits == 7 && len(d.frame) >= minFrame && crc(fcsInit, d.frame) == fcsResidue {
+			frame := append([]byte(nil), d.frame[:len(d.frame)-2]...)
+			d.onFrame(frame)
+		}
+
+		d.inFrame = true
+		d.ones = 0
+		d.reset()
+		return
+	}
+
+	if bit == 1 {
+		if d.ones++; d.ones >= 7 { // abort, or noise
+			d.inFrame = false
+			d.reset()
+			return
+		}
+	} else {
+		stuffed := d.ones == 5
+		d.ones = 0
+		if stuffed {
+			return
+		}
+	}
+
+	if !d.inFrame {
+		return
+	}
+
+	d.cur = d.cur>>1 | bit<<7
+	if d.bits++; d.bits == 8 {
+		if len(d.frame) == maxFrame {
+			d.inFrame = false
+			d.reset()
+			return
+		}
+
+		d.frame = append(d.frame, d.cur)
+		d.bits, d.cur = 0, 0
+	}
+}
@@ -0,0 +1,240 @@
+// Package ax25 encodes and decodes AX.25 frames (without the HDLC flags and FCS)
+package ax25
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidFrame   = fmt.Errorf("Invalid AX.25 frame")
+	ErrInvalidAddress = fmt.Errorf("Invalid AX.25 address")
+)
+
+const (
+	ControlUI = 0x03 // unnumbered information frame
+	PIDNoL3   = 0xF0 // no layer 3 protocol
+
+	MaxPath = 8 // maximum number of digipeaters
+)
+
+// Address is a station address: callsign and SSID (i.e. "N0CALL-9")
+type Address struct {
+	Call string
+	SSID int
+
+	// H is the "has been repeated" bit for digipeaters, and the command/response bit for destination and source
+	H bool
+}
+
+// ParseAddress parses a callsign with an optional SSID (i.e. "N0CALL-9").
+// A trailing '*' (as in the TNC2 format) sets the H bit.
+func ParseAddress(s string) (Address, error) {
+	var a Address
+
+	if strings.HasSuffix(s, "*") {
+		a.H = true
+		s = s[:len(s)-1]
+	}
+
+	call, ssid, found := strings.Cut(strings.ToUpper(s), "-")
+	if found {
+		n, err := strconv.Atoi(ssid)
+		if err != nil || n < 0 || n > 15 {
+			return a, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+		}
+		a.SSID = n
+	}
+
+	if call == "" || len(call) > 6 {
+		return a, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+	}
+	for _, c := range call {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return a, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+		}
+	}
+
+	a.Call = call
+	return a, nil
+}
+
+func (a Address) String() string {
+	if a.SSID == 0 {
+		return a.Call
+	}
+	return fmt.Sprintf("%s-%d", a.Call, a.SSID)
+}
+
+// encode writes the 7 bytes address field
+func (a Address) encode(b []byte, last bool) {
+	for i := range 6 {
+		c := byte(' ')
+		if i < len(a.Call) {
+			c = a.Call[i]
+		}
+		b[i] = c << 1
+	}
+
+	b[6] = 0x60 | byte(a.SSID&0x0F)<<1 // reserved bits set
+	if a.H {
+		b[6] |= 0x80
+	}
+	if last {
+		b[6] |= 0x01
+	}
+}
+
+func decodeAddress(b []byte) Address {
+	var call []byte
+	for _, c := range b[:6] {
+		if c&0x01 != 0 {
+			break
+		}
+		if c >>= 1; c != ' ' {
+			call = append(call, c)
+		}
+	}
+
+	return Address{
+		Call: string(call),
+		SSID: int(b[6]>>1) & 0x0F,
+		H:    b[6]&0x80 != 0,
+	}
+}
+
+// Frame is an AX.25 frame
+type Frame struct {
+	Dest    Address
+	Source  Address
+	Path    []Address // digipeaters
+	Control byte
+	PID     byte // only for I and UI frames
+	Info    []byte
+}
+
+// NewUI returns a UI frame, as used by APRS
+func NewUI(source, dest Address, path []Address, info []byte) *Frame {
+	return &Frame{Dest: dest, Source: source, Path: path, Control: ControlUI, PID: PIDNoL3, Info: info}
+}
+
+// hasPID returns true for I frames and UI frames, that have a PID byte
+func (f *Frame) hasPID() bool {
+	return f.Control&0x01 == 0 || f.Control&0xEF == ControlUI
+}
+
+// Encode returns the frame bytes, without the FCS
+func (f *Frame) Encode() []byte {
+	b := make([]byte, 14+7*len(f.Path), 16+7*len(f.Path)+len(f.Info))
+
+	f.Dest.encode(b[0:], false)
+	f.Source.encode(b[7:], len(f.Path) == 0)
+	for i, a := range f.Path {
+		a.encode(b[14+7*i:], i == len(f.Path)-1)
+	}
+
+	b = append(b, f.Control)
+	if f.hasPID() {
+		b = append(b, f.PID)
+	}
+	return append(b, f.Info...)
+}
+
+// Decode parses the frame bytes (without the FCS)
+func Decode(b []byte) (*Frame, error) {
+	// the address field ends with the byte that has the LSB set
+	end := -1
+	for i := 6; i < len(b); i += 7 {
+		if b[i]&0x01 != 0 {
+			end = i + 1
+			break
+		}
+	}
+	if end < 14 || end > 14+7*MaxPath || len(b) <= end {
+		return nil, ErrInvalidFrame
+	}
+
+	f := &Frame{
+		Dest:    decodeAddress(b[0:7]),
+		Source:  decodeAddress(b[7:14]),
+		Control: b[end],
+	}
+	for i := 14; i < end; i += 7 {
+		f.Path = append(f.Path, decodeAddress(b[i:i+7]))
+	}
+
+	b = b[end+1:]
+	if f.hasPID() {
+		if len(b) == 0 {
+			return nil, ErrInvalidFrame
+		}
+		f.PID, b = b[0], b[1:]
+	}
+	f.Info = append([]byte(nil), b...)
+
+	return f, nil
+}
+
+// Parse parses a frame in the TNC2 monitor format: "SOURCE>DEST,PATH1,PATH2*:info".
+// It returns a UI frame.
+func Parse(s string) (*Frame, error) {
+	header, info, found := strings.Cut(s, ":")
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+	}
+
+	source, rest, found := strings.Cut(header, ">")
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+	}
+
+	addresses := strings.Split(rest, ",")
+	if len(addresses) > MaxPath+1 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+	}
+
+	src, err := ParseAddress(source)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := ParseAddress(addresses[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var path []Address
+	for _, s := range addresses[1:] {
+		a, err := ParseAddress(s)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, a)
+	}
+
+	return NewUI(src, dest, path, []byte(info)), nil
+}
+
+// String returns the frame in the TNC2 monitor format
+func (f *Frame) String() string {
+	var b strings.Builder
+
+	b.WriteString(f.Source.String() + ">" + f.Dest.String())
+	for _, a := range f.Path {
+		b.WriteString("," + a.String())
+		if a.H {
+			b.WriteByte('*')
+		}
+	}
+	b.WriteByte(':')
+
+	for _, c := range f.Info {
+		if c < 0x20 && c != '\r' && c != '\n' || c == 0x7F {
+			fmt.Fprintf(&b, "<0x%02x>", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package ax25
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	f, err := Parse("N0CALL-9>APRS,WIDE1-1,WIDE2-1*:!4903.50N/07201.75W-Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := f.Encode()
+	if len(b) != 7*4+2+len(f.Info) {
+		t.Fatalf("unexpected length %d", len(b))
+	}
+
+	// the callsign characters are shifted left, the SSID is in bits 1-4
+	if !bytes.Equal(b[7:14], []byte{'N' << 1, '0' << 1, 'C' << 1, 'A' << 1, 'L' << 1, 'L' << 1, 0x60 | 9<<1}) {
+		t.Errorf("unexpected source address % x", b[7:14])
+	}
+	if b[27]&0x81 != 0x81 {
+		t.Errorf("last address without H and end bits: %02x", b[27])
+	}
+
+	d, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, f) {
+		t.Errorf("decoded\n%+v\nexpected\n%+v", d, f)
+	}
+
+	if s := d.String(); s != "N0CALL-9>APRS,WIDE1-1,WIDE2-1*:!4903.50N/07201.75W-Test" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, s := range []string{"N0CALL>APRS", "N0CALL-16>APRS:x", "TOOLONGCALL>APRS:x", "N0_CALL>APRS:x"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+
+	f, _ := Parse("N0CALL>APRS:x")
+	b := f.Encode()
+	for _, b := range [][]byte{b[:10], b[:14], append(bytes.Clone(b[:13]), 0x00)} {
+		if _, err := Decode(b); err == nil {
+			t.Errorf("% x: no error", b)
+		}
+	}
+}
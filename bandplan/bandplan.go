@@ -0,0 +1,133 @@
+// Package bandplan describes the frequencies a kv4p HT can receive and transmit on:
+// the limits of the radio module, the amateur bands of the ITU regions,
+// the US 2m/70cm sub-bands and the privileges of the US license classes.
+//
+// All frequencies are in MHz.
+package bandplan
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnsupported  = fmt.Errorf("Frequency not supported by the radio module")
+	ErrOutOfBand    = fmt.Errorf("Frequency outside of the amateur bands")
+	ErrNotPermitted = fmt.Errorf("Frequency not permitted by the license class")
+	ErrInvalidName  = fmt.Errorf("Invalid name")
+)
+
+// Range is a frequency range (limits included)
+type Range struct {
+	Min, Max float64
+}
+
+// Contains returns true if freq is in the range
+func (r Range) Contains(freq float64) bool {
+	return freq >= r.Min && freq <= r.Max
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%g-%g MHz", r.Min, r.Max)
+}
+
+// InRanges returns true if freq is in one of the ranges
+func InRanges(ranges []Range, freq float64) bool {
+	for _, r := range ranges {
+		if r.Contains(freq) {
+			return true
+		}
+	}
+	return false
+}
+
+// FrequencyError is returned for frequencies that are not allowed
+type FrequencyError struct {
+	Freq    float64
+	Err     error   // ErrUnsupported, ErrOutOfBand or ErrNotPermitted
+	Allowed []Range // the allowed ranges
+}
+
+func (e *FrequencyError) Error() string {
+	allowed := make([]string, len(e.Allowed))
+	for i, r := range e.Allowed {
+		allowed[i] = r.String()
+	}
+
+	if len(allowed) == 0 {
+		return fmt.Sprintf("%v: %.4f MHz", e.Err, e.Freq)
+	}
+	return fmt.Sprintf("%v: %.4f MHz not in %s", e.Err, e.Freq, strings.Join(allowed, ", "))
+}
+
+func (e *FrequencyError) Unwrap() error {
+	return e.Err
+}
+
+// Module describes the frequency limits of a radio module
+type Module struct {
+	Name string
+	VHF  Range
+	UHF  Range
+}
+
+var (
+	SA818  = Module{Name: "SA818", VHF: Range{134, 174}, UHF: Range{400, 480}}
+	DRA818 = Module{Name: "DRA818", VHF: Range{134, 174}, UHF: Range{400, 470}}
+
+	Modules = []Module{SA818, DRA818}
+)
+
+// ModuleByName returns the module with the specified name (case insensitive)
+func ModuleByName(name string) (Module, error) {
+	for _, m := range Modules {
+		if strings.EqualFold(m.Name, name) {
+			return m, nil
+		}
+	}
+
+	return Module{}, fmt.Errorf("%w: unknown module %q", ErrInvalidName, name)
+}
+
+// Ranges returns the frequency ranges supported by the module
+func (m Module) Ranges() []Range {
+	return []Range{m.VHF, m.UHF}
+}
+
+// Check returns a FrequencyError (ErrUnsupported) if the module can't tune to freq
+func (m Module) Check(freq float64) error {
+	if !InRanges(m.Ranges(), freq) {
+		return &FrequencyError{Freq: freq, Err: ErrUnsupported, Allowed: m.Ranges()}
+	}
+	return nil
+}
+
+// Clamp returns the supported frequency closest to freq
+func (m Module) Clamp(freq float64) float64 {
+	switch {
+	case freq < m.VHF.Min:
+		return m.VHF.Min
+	case freq > m.VHF.Max && freq < m.UHF.Min:
+		if freq-m.VHF.Max <= m.UHF.Min-freq {
+			return m.VHF.Max
+		}
+		return m.UHF.Min
+	case freq > m.UHF.Max:
+		return m.UHF.Max
+	}
+
+	return freq
+}
+
+// IsUHF returns true if freq is closer to the UHF band of the module than to the VHF one
+func (m Module) IsUHF(freq float64) bool {
+	return m.Clamp(freq) >= m.UHF.Min
+}
+
+// Band returns the band (VHF or UHF) of the module freq belongs to, after clamping
+func (m Module) Band(freq float64) Range {
+	if m.IsUHF(freq) {
+		return m.UHF
+	}
+	return m.VHF
+}
@@ -0,0 +1,94 @@
+package bandplan
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		plan Plan
+		freq float64
+		rx   error
+		tx   error
+	}{
+		{Default, 162.4, nil, nil},
+		{Default, 475, nil, nil},
+		{Default, 100, ErrUnsupported, ErrUnsupported},
+		{Plan{Module: DRA818}, 475, ErrUnsupported, ErrUnsupported},
+		{Plan{Module: SA818, Region: ITURegion2}, 162.4, nil, ErrOutOfBand},
+		{Plan{Module: SA818, Region: ITURegion2}, 146.52, nil, nil},
+		{Plan{Module: SA818, Region: ITURegion1}, 146.52, nil, ErrOutOfBand},
+		{Plan{Module: SA818, Region: ITURegion1}, 433.5, nil, nil},
+		{Plan{Module: SA818, Region: ITURegion3}, 445, nil, ErrOutOfBand},
+		{Plan{Module: SA818, License: Technician}, 446, nil, nil},
+		{Plan{Module: SA818, License: Novice}, 146.52, nil, ErrNotPermitted},
+		{Plan{Module: SA818, License: Unlicensed}, 162.4, nil, ErrNotPermitted},
+	}
+
+	for _, test := range tests {
+		if err := test.plan.CheckRX(test.freq); !errors.Is(err, test.rx) {
+			t.Errorf("%+v RX %v: expected %v, got %v", test.plan, test.freq, test.rx, err)
+		}
+
+		err := test.plan.CheckTX(test.freq)
+		if !errors.Is(err, test.tx) {
+			t.Errorf("%+v TX %v: expected %v, got %v", test.plan, test.freq, test.tx, err)
+		}
+
+		var ferr *FrequencyError
+		if err != nil && (!errors.As(err, &ferr) || ferr.Freq != test.freq) {
+			t.Errorf("%+v TX %v: expected a FrequencyError, got %#v", test.plan, test.freq, err)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	for _, test := range []struct {
+		module Module
+		freq   float64
+		expect float64
+	}{
+		{SA818, 100, 134},
+		{SA818, 146.52, 146.52},
+		{SA818, 200, 174},
+		{SA818, 350, 400},
+		{SA818, 500, 480},
+		{DRA818, 475, 470},
+	} {
+		if f := test.module.Clamp(test.freq); f != test.expect {
+			t.Errorf("%s.Clamp(%v): expected %v, got %v", test.module.Name, test.freq, test.expect, f)
+		}
+	}
+}
+
+func TestWarning(t *testing.T) {
+	us := Plan{Module: SA818, License: Technician}
+
+	if w := us.Warning(146.52); w != "" {
+		t.Errorf("unexpected warning for 146.52: %s", w)
+	}
+	if w := us.Warning(144.05); w == "" {
+		t.Error("expected a warning for FM in the CW sub-band")
+	}
+	if w := Default.Warning(144.05); w != "" {
+		t.Errorf("unexpected warning with no region: %s", w)
+	}
+}
+
+func TestUSSubBand(t *testing.T) {
+	if sb, ok := USSubBand(146.52); !ok || !strings.Contains(sb.Use, "146.52") {
+		t.Errorf("146.52 should be in the sub-band of the national calling frequency, got %+v", sb)
+	}
+	if sb, ok := USSubBand(146.595); !ok || sb.Use != "FM simplex" {
+		t.Errorf("unexpected sub-band for 146.595: %+v", sb)
+	}
+
+	// boundaries belong to the higher segment
+	for freq, offset := range map[float64]float64{146.0: 0, 146.61: -0.6, 147.0: 0.6, 442.0: 5, 447.0: -5} {
+		if sb, _ := USSubBand(freq); sb.Offset != offset {
+			t.Errorf("%v: expected offset %v, got %+v", freq, offset, sb)
+		}
+	}
+}
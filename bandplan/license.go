@@ -0,0 +1,57 @@
+package bandplan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LicenseClass is a US amateur license class
+type LicenseClass int
+
+const (
+	AnyLicense LicenseClass = iota // no license limits
+	Unlicensed                     // no amateur privileges
+	Novice                         // no 2m and 70cm privileges (only 222 MHz and 1.2 GHz above 50 MHz)
+	Technician                     // full privileges above 50 MHz
+	General
+	Advanced
+	AmateurExtra
+)
+
+var licenseNames = []string{"any", "unlicensed", "novice", "technician", "general", "advanced", "extra"}
+
+func (c LicenseClass) String() string {
+	if c < 0 || int(c) >= len(licenseNames) {
+		return fmt.Sprintf("LicenseClass(%d)", int(c))
+	}
+	return licenseNames[c]
+}
+
+// ParseLicense parses a license class name ("technician", "general", "extra", ...), or "" / "any" for no limits
+func ParseLicense(s string) (LicenseClass, error) {
+	s = strings.ToLower(s)
+	if s == "" {
+		return AnyLicense, nil
+	}
+
+	for i, name := range licenseNames {
+		if s == name || (len(s) > 2 && strings.HasPrefix(name, s)) {
+			return LicenseClass(i), nil
+		}
+	}
+
+	return AnyLicense, fmt.Errorf("%w: unknown license class %q", ErrInvalidName, s)
+}
+
+// Privileges returns the 2m and 70cm ranges where the license class can transmit (in the US).
+// It returns nil for AnyLicense (no limits).
+func (c LicenseClass) Privileges() []Range {
+	switch c {
+	case AnyLicense:
+		return nil
+	case Unlicensed, Novice:
+		return []Range{}
+	}
+
+	return []Range{{144, 148}, {420, 450}}
+}
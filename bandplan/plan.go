@@ -0,0 +1,47 @@
+package bandplan
+
+// Plan combines the limits of the radio module, the region and the license class
+type Plan struct {
+	Module  Module
+	Region  Region       // limits transmitting to the amateur bands of the region
+	License LicenseClass // limits transmitting to the privileges of the license class
+}
+
+// Default only enforces the limits of the SA818 module
+var Default = Plan{Module: SA818}
+
+// CheckRX returns a FrequencyError if the radio can't receive on freq
+func (p Plan) CheckRX(freq float64) error {
+	return p.Module.Check(freq)
+}
+
+// CheckTX returns a FrequencyError if transmitting on freq is not allowed
+func (p Plan) CheckTX(freq float64) error {
+	if err := p.Module.Check(freq); err != nil {
+		return err
+	}
+
+	if bands := p.Region.AmateurBands(); p.Region != AnyRegion && !InRanges(bands, freq) {
+		return &FrequencyError{Freq: freq, Err: ErrOutOfBand, Allowed: bands}
+	}
+
+	if privileges := p.License.Privileges(); p.License != AnyLicense && !InRanges(privileges, freq) {
+		return &FrequencyError{Freq: freq, Err: ErrNotPermitted, Allowed: privileges}
+	}
+
+	return nil
+}
+
+// Warning returns a description of the sub-band freq belongs to, if FM voice is not expected there,
+// or an empty string. Only the US band plan is checked, when the plan has a license class or is for ITU region 2.
+func (p Plan) Warning(freq float64) string {
+	if p.Region != ITURegion2 && p.License == AnyLicense {
+		return ""
+	}
+
+	if sb, ok := USSubBand(freq); ok && !sb.FM {
+		return sb.Use + " sub-band (" + sb.Range.String() + ")"
+	}
+
+	return ""
+}
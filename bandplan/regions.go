@@ -0,0 +1,120 @@
+package bandplan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Region is an ITU region
+type Region int
+
+const (
+	AnyRegion  Region = iota // no regional limits
+	ITURegion1               // Europe, Africa, Middle East, northern Asia
+	ITURegion2               // the Americas
+	ITURegion3               // southern Asia, Oceania
+)
+
+// AmateurBands returns the 2m and 70cm amateur allocations of the region
+func (r Region) AmateurBands() []Range {
+	switch r {
+	case ITURegion1:
+		return []Range{{144, 146}, {430, 440}}
+	case ITURegion2:
+		return []Range{{144, 148}, {420, 450}}
+	case ITURegion3:
+		return []Range{{144, 148}, {430, 440}}
+	}
+
+	return nil
+}
+
+func (r Region) String() string {
+	if r == AnyRegion {
+		return "any"
+	}
+	return fmt.Sprintf("ITU region %d", int(r))
+}
+
+// ParseRegion parses an ITU region number ("1", "2", "3"), or "" / "any" for no regional limits
+func ParseRegion(s string) (Region, error) {
+	switch strings.TrimPrefix(strings.ToLower(s), "r") {
+	case "", "any":
+		return AnyRegion, nil
+	case "1":
+		return ITURegion1, nil
+	case "2":
+		return ITURegion2, nil
+	case "3":
+		return ITURegion3, nil
+	}
+
+	return AnyRegion, fmt.Errorf("%w: unknown region %q", ErrInvalidName, s)
+}
+
+// SubBand is a segment of a band plan
+type SubBand struct {
+	Range
+	Use string
+	FM  bool // FM voice is expected in this segment
+
+	// Offset is the standard repeater offset for the repeater outputs in this segment
+	// (negative for a minus shift), 0 if the segment has no repeater outputs.
+	Offset float64
+}
+
+// US2m and US70cm are the ARRL band plans for the 2m and 70cm bands.
+// The 70cm plan varies by region: the 442-450 MHz repeater segments are the most common.
+var (
+	US2m = []SubBand{
+		{Range{144.00, 144.10}, "EME and weak signal CW", false, 0},
+		{Range{144.10, 144.275}, "Weak signal CW and SSB", false, 0},
+		{Range{144.275, 144.30}, "Beacons", false, 0},
+		{Range{144.30, 144.50}, "New OSCAR sub-band", false, 0},
+		{Range{144.50, 144.60}, "Linear translator inputs", false, 0},
+		{Range{144.60, 144.90}, "FM repeater inputs", true, 0},
+		{Range{144.90, 145.10}, "Weak signal and FM simplex", true, 0},
+		{Range{145.10, 145.20}, "Linear translator outputs", false, 0},
+		{Range{145.20, 145.50}, "FM repeater outputs", true, -0.6},
+		{Range{145.50, 145.80}, "Miscellaneous and experimental modes", true, 0},
+		{Range{145.80, 146.00}, "OSCAR sub-band", false, 0},
+		{Range{146.00, 146.40}, "FM repeater inputs", true, 0},
+		{Range{146.40, 146.58}, "FM simplex (national calling 146.52)", true, 0},
+		{Range{146.58, 146.61}, "FM simplex", true, 0},
+		{Range{146.61, 147.00}, "FM repeater outputs", true, -0.6},
+		{Range{147.00, 147.39}, "FM repeater outputs", true, 0.6},
+		{Range{147.39, 147.60}, "FM simplex", true, 0},
+		{Range{147.60, 148.00}, "FM repeater inputs", true, 0},
+	}
+
+	US70cm = []SubBand{
+		{Range{420.00, 426.00}, "ATV repeater or simplex, fixed digital message forwarding", false, 0},
+		{Range{426.00, 432.00}, "ATV simplex", false, 0},
+		{Range{432.00, 432.07}, "EME", false, 0},
+		{Range{432.07, 432.10}, "Weak signal CW", false, 0},
+		{Range{432.10, 432.30}, "Mixed mode and weak signal", false, 0},
+		{Range{432.30, 432.40}, "Propagation beacons", false, 0},
+		{Range{432.40, 433.00}, "Mixed mode and weak signal", false, 0},
+		{Range{433.00, 435.00}, "Auxiliary and repeater links", true, 0},
+		{Range{435.00, 438.00}, "Satellite only", false, 0},
+		{Range{438.00, 442.00}, "ATV, repeater inputs and outputs", true, 0},
+		{Range{442.00, 444.00}, "ATV, repeater inputs and outputs", true, 5.0},
+		{Range{444.00, 445.00}, "Repeater inputs and outputs", true, 5.0},
+		{Range{445.00, 447.00}, "Shared by auxiliary, control links, repeaters and simplex (national simplex 446.0)", true, 0},
+		{Range{447.00, 450.00}, "Repeater inputs and outputs", true, -5.0},
+	}
+)
+
+// USSubBand returns the US 2m or 70cm sub-band freq belongs to.
+// A frequency on the boundary of two segments belongs to the higher one.
+func USSubBand(freq float64) (SubBand, bool) {
+	for _, plan := range [][]SubBand{US2m, US70cm} {
+		for i := len(plan) - 1; i >= 0; i-- {
+			if plan[i].Contains(freq) {
+				return plan[i], true
+			}
+		}
+	}
+
+	return SubBand{}, false
+}
@@ -0,0 +1,220 @@
+// Package channels stores named memory channels in a JSON file,
+// and imports and exports them in the CHIRP CSV format.
+package channels
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+var (
+	ErrNoName          = fmt.Errorf("Channel name is required")
+	ErrInvalidChannel  = fmt.Errorf("Invalid channel")
+	ErrChannelNotFound = fmt.Errorf("Channel not found")
+)
+
+// DefaultFile is the name of the channels file in the user configuration directory
+const DefaultFile = "kv4p/channels.json"
+
+// Channel is a memory channel
+type Channel struct {
+	Name    string      `json:"name"`
+	RxFreq  float64     `json:"rx_freq"` // MHz
+	TxFreq  float64     `json:"tx_freq"` // MHz (same as RxFreq for simplex)
+	Narrow  bool        `json:"narrow,omitempty"`
+	Squelch int         `json:"squelch,omitempty"` // 0: listen mode, 1-8: squelch level
+	TxTone  kv4pht.Tone `json:"tx_tone"`
+	RxTone  kv4pht.Tone `json:"rx_tone"`
+	Band    string      `json:"band,omitempty"` // "vhf" or "uhf" (default: from RxFreq)
+
+	// audio filters
+	PreEmphasis bool `json:"pre_emphasis,omitempty"`
+	HighPass    bool `json:"high_pass"`
+	LowPass     bool `json:"low_pass"`
+
+	Skip    bool   `json:"skip,omitempty"` // skip when scanning
+	Comment string `json:"comment,omitempty"`
+}
+
+// New returns a simplex wide channel with the default filters
+func New(name string, freq float64) Channel {
+	return Channel{Name: name, RxFreq: freq, TxFreq: freq, HighPass: true, LowPass: true}
+}
+
+// Validate checks the channel settings.
+// DCS tones are accepted, even if the radio module can't use them, so that they can be exported.
+func (c Channel) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return ErrNoName
+	}
+	if c.RxFreq <= 0 || c.TxFreq <= 0 {
+		return fmt.Errorf("%w: %s: missing frequency", ErrInvalidChannel, c.Name)
+	}
+	if c.Squelch < 0 || c.Squelch > 8 {
+		return fmt.Errorf("%w: %s: squelch %d not in 0-8", ErrInvalidChannel, c.Name, c.Squelch)
+	}
+	if c.Band != "" && c.Band != "vhf" && c.Band != "uhf" {
+		return fmt.Errorf("%w: %s: band %q", ErrInvalidChannel, c.Name, c.Band)
+	}
+	return nil
+}
+
+// Mode returns the band (MODE_VHF or MODE_UHF) to configure the radio for
+func (c Channel) Mode() int {
+	switch {
+	case c.Band == "uhf":
+		return kv4pht.MODE_UHF
+	case c.Band == "vhf":
+		return kv4pht.MODE_VHF
+	case c.RxFreq >= kv4pht.UHF_MIN_FREQ:
+		return kv4pht.MODE_UHF
+	default:
+		return kv4pht.MODE_VHF
+	}
+}
+
+// Bandwidth returns DRA818_25K or DRA818_12K5
+func (c Channel) Bandwidth() int {
+	if c.Narrow {
+		return kv4pht.DRA818_12K5
+	}
+	return kv4pht.DRA818_25K
+}
+
+// GroupOptions returns the settings to send with SendGroupOptions
+func (c Channel) GroupOptions() kv4pht.GroupOptions {
+	return kv4pht.GroupOptions{
+		Bandwidth: c.Bandwidth(),
+		TxFreq:    c.TxFreq,
+		RxFreq:    c.RxFreq,
+		Squelch:   c.Squelch,
+		TxTone:    c.TxTone,
+		RxTone:    c.RxTone,
+	}
+}
+
+// ScanChannel returns the channel for a memory scan
+func (c Channel) ScanChannel() kv4pht.ScanChannel {
+	return kv4pht.ScanChannel{Name: c.Name, GroupOptions: c.GroupOptions()}
+}
+
+// Apply configures the radio with the channel settings (CONFIG, FILTERS and GROUP)
+func (c Channel) Apply(p *kv4pht.CommandProcessor) error {
+	if err := p.SendConfig(c.Mode()); err != nil {
+		return err
+	}
+	if err := p.SendFilters(c.PreEmphasis, c.HighPass, c.LowPass); err != nil {
+		return err
+	}
+	return p.SendGroupOptions(c.GroupOptions())
+}
+
+// Store is a list of channels, persisted to a JSON file
+type Store struct {
+	Path     string
+	Channels []Channel
+}
+
+// DefaultPath returns the path of the channels file in the user configuration directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, DefaultFile), nil
+}
+
+// Load reads the channels from a file. A missing file is an empty store.
+func Load(path string) (*Store, error) {
+	s := &Store{Path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.Channels); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save writes the channels to the store file
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s.Channels, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+
+	// write a new file and rename it, so that the channels are never left truncated
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *Store) find(name string) int {
+	for i, c := range s.Channels {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the channel with the specified name (case insensitive)
+func (s *Store) Get(name string) (Channel, error) {
+	if i := s.find(name); i >= 0 {
+		return s.Channels[i], nil
+	}
+	return Channel{}, fmt.Errorf("%w: %q", ErrChannelNotFound, name)
+}
+
+// Set adds a channel, or replaces the channel with the same name
+func (s *Store) Set(c Channel) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if i := s.find(c.Name); i >= 0 {
+		s.Channels[i] = c
+	} else {
+		s.Channels = append(s.Channels, c)
+	}
+	return nil
+}
+
+// Delete removes the channel with the specified name. It returns false if there is no such channel.
+func (s *Store) Delete(name string) bool {
+	i := s.find(name)
+	if i < 0 {
+		return false
+	}
+
+	s.Channels = append(s.Channels[:i], s.Channels[i+1:]...)
+	return true
+}
+
+// ScanChannels returns the channels for a memory scan, excluding the ones marked as skip
+func (s *Store) ScanChannels() []kv4pht.ScanChannel {
+	var list []kv4pht.ScanChannel
+	for _, c := range s.Channels {
+		if !c.Skip {
+			list = append(list, c.ScanChannel())
+		}
+	}
+	return list
+}
@@ -0,0 +1,132 @@
+package channels
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+// exported by CHIRP (older versions don't have the RxDtcsCode and CrossMode columns)
+const chirpCSV = `Location,Name,Frequency,Duplex,Offset,Tone,rToneFreq,cToneFreq,DtcsCode,DtcsPolarity,RxDtcsCode,CrossMode,Mode,TStep,Skip,Power,Comment,URCALL,RPT1CALL,RPT2CALL,DVCODE
+1,CALL,146.520000,,0.600000,,88.5,88.5,023,NN,023,Tone->Tone,FM,5.00,,50W,simplex calling,,,,
+2,RPT1,146.940000,-,0.600000,Tone,100.0,88.5,023,NN,023,Tone->Tone,FM,5.00,,50W,,,,,
+3,RPT2,442.100000,+,5.000000,TSQL,88.5,127.3,023,NN,023,Tone->Tone,NFM,5.00,S,50W,,,,,
+4,DCS,446.000000,,0.000000,DTCS,88.5,88.5,125,RN,125,Tone->Tone,FM,5.00,,50W,,,,,
+5,SPLIT,147.000000,split,444.000000,Cross,123.0,88.5,023,NN,754,Tone->DTCS,FM,5.00,,50W,,,,,
+6,AM,121.500000,,0.000000,,88.5,88.5,023,NN,023,Tone->Tone,AM,5.00,,50W,,,,,
+7,,162.400000,off,0.000000,,88.5,88.5,023,NN,023,Tone->Tone,FM,5.00,,50W,,,,,
+`
+
+func tone(t *testing.T, s string) kv4pht.Tone {
+	t.Helper()
+	tone, err := kv4pht.ParseTone(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tone
+}
+
+func TestReadCSV(t *testing.T) {
+	list, err := ReadCSV(strings.NewReader(chirpCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpt1 := New("RPT1", 146.94)
+	rpt1.TxFreq = 146.34
+	rpt1.TxTone = tone(t, "100.0")
+
+	rpt2 := New("RPT2", 442.1)
+	rpt2.TxFreq = 447.1
+	rpt2.TxTone = tone(t, "127.3")
+	rpt2.RxTone = rpt2.TxTone
+	rpt2.Narrow = true
+	rpt2.Skip = true
+
+	dcs := New("DCS", 446)
+	dcs.TxTone = tone(t, "D125I")
+	dcs.RxTone = tone(t, "D125N")
+
+	split := New("SPLIT", 147)
+	split.TxFreq = 444
+	split.TxTone = tone(t, "123.0")
+	split.RxTone = tone(t, "D754N")
+
+	call := New("CALL", 146.52)
+	call.Comment = "simplex calling"
+
+	expected := []Channel{call, rpt1, rpt2, dcs, split, New("CH7", 162.4)}
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("unexpected channels\n%+v\nexpected\n%+v", list, expected)
+	}
+
+	// round trip
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, list); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ReadCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, expected) {
+		t.Fatalf("round trip\n%+v\nexpected\n%+v", again, expected)
+	}
+
+	if _, err := ReadCSV(strings.NewReader("Name,Frequency\nX,146.52\n")); err == nil {
+		t.Error("missing columns not detected")
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv4p", "channels.json")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wx := New("WX", 162.4)
+	wx.Squelch = 2
+	wx.RxTone = tone(t, "88.5")
+
+	for _, c := range []Channel{New("CALL", 146.52), wx, New("CALL", 146.55)} {
+		if err := s.Set(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(New("", 146.52)); err != ErrNoName {
+		t.Errorf("expected ErrNoName, got %v", err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Channels) != 2 {
+		t.Fatalf("expected 2 channels, got %+v", s.Channels)
+	}
+	if c, err := s.Get("call"); err != nil || c.RxFreq != 146.55 {
+		t.Errorf("unexpected channel %+v (%v)", c, err)
+	}
+	if c, err := s.Get("WX"); err != nil || !reflect.DeepEqual(c, wx) {
+		t.Errorf("unexpected channel %+v (%v)", c, err)
+	}
+	if c := s.ScanChannels(); len(c) != 2 || c[1].Name != "WX" || c[1].RxTone != wx.RxTone || c[1].Squelch != 2 {
+		t.Errorf("unexpected scan channels %+v", c)
+	}
+
+	if !s.Delete("CALL") || s.Delete("CALL") {
+		t.Error("unexpected Delete result")
+	}
+	if _, err := s.Get("CALL"); err == nil {
+		t.Error("deleted channel found")
+	}
+}
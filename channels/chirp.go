@@ -0,0 +1,295 @@
+package channels
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+var ErrInvalidCSV = fmt.Errorf("Invalid CHIRP CSV file")
+
+// CSVHeader is the header of the CSV files exported by CHIRP
+var CSVHeader = []string{
+	"Location", "Name", "Frequency", "Duplex", "Offset", "Tone",
+	"rToneFreq", "cToneFreq", "DtcsCode", "DtcsPolarity", "RxDtcsCode", "CrossMode",
+	"Mode", "TStep", "Skip", "Power", "Comment",
+	"URCALL", "RPT1CALL", "RPT2CALL", "DVCODE",
+}
+
+// maxOffset is the largest repeater offset exported as +/- (larger differences are exported as split)
+const maxOffset = 10.0
+
+// ReadCSV reads the channels from a CSV file in the CHIRP format.
+//
+// Only FM and NFM channels are imported. Unnamed channels are named after their location.
+func ReadCSV(r io.Reader) ([]Channel, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, ErrInvalidCSV
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.TrimSpace(h)] = i
+	}
+	for _, h := range []string{"Frequency", "Duplex", "Offset", "Mode"} {
+		if _, ok := columns[h]; !ok {
+			return nil, fmt.Errorf("%w: missing column %s", ErrInvalidCSV, h)
+		}
+	}
+
+	var list []Channel
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		mode := field("Mode")
+		if mode != "FM" && mode != "NFM" {
+			continue
+		}
+
+		c, err := parseRecord(field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidCSV, line, err)
+		}
+		c.Narrow = mode == "NFM"
+
+		list = append(list, c)
+	}
+}
+
+func parseRecord(field func(string) string) (Channel, error) {
+	freq, err := strconv.ParseFloat(field("Frequency"), 64)
+	if err != nil {
+		return Channel{}, fmt.Errorf("frequency %q", field("Frequency"))
+	}
+
+	name := field("Name")
+	if name == "" {
+		name = "CH" + field("Location")
+	}
+
+	c := New(name, freq)
+	c.Skip = field("Skip") != ""
+	c.Comment = field("Comment")
+
+	var offset float64
+	if s := field("Offset"); s != "" {
+		if offset, err = strconv.ParseFloat(s, 64); err != nil {
+			return Channel{}, fmt.Errorf("offset %q", s)
+		}
+	}
+
+	switch field("Duplex") {
+	case "":
+	case "+":
+		c.TxFreq = round(freq + offset)
+	case "-":
+		c.TxFreq = round(freq - offset)
+	case "split":
+		c.TxFreq = offset
+	case "off":
+		c.TxFreq = freq // receive only: transmitting is refused by the band plan, if configured
+	default:
+		return Channel{}, fmt.Errorf("duplex %q", field("Duplex"))
+	}
+
+	ctcss := func(name string) (kv4pht.Tone, error) {
+		f, err := strconv.ParseFloat(field(name), 64)
+		if err != nil {
+			return kv4pht.Tone{}, fmt.Errorf("%s %q", name, field(name))
+		}
+		return kv4pht.CTCSS(f)
+	}
+
+	polarity := field("DtcsPolarity") + "NN"
+	dcs := func(name string, pol byte) (kv4pht.Tone, error) {
+		code, err := strconv.Atoi(field(name))
+		if err != nil {
+			return kv4pht.Tone{}, fmt.Errorf("%s %q", name, field(name))
+		}
+		return kv4pht.DCS(code, pol == 'R')
+	}
+
+	switch field("Tone") {
+	case "":
+	case "Tone":
+		c.TxTone, err = ctcss("rToneFreq")
+	case "TSQL":
+		if c.TxTone, err = ctcss("cToneFreq"); err == nil {
+			c.RxTone = c.TxTone
+		}
+	case "DTCS":
+		if c.TxTone, err = dcs("DtcsCode", polarity[0]); err == nil {
+			c.RxTone, err = dcs("DtcsCode", polarity[1])
+		}
+	case "Cross":
+		tx, rx, _ := strings.Cut(field("CrossMode"), "->")
+
+		switch tx {
+		case "":
+		case "Tone":
+			c.TxTone, err = ctcss("rToneFreq")
+		case "DTCS":
+			c.TxTone, err = dcs("DtcsCode", polarity[0])
+		default:
+			err = fmt.Errorf("cross mode %q", field("CrossMode"))
+		}
+		if err != nil {
+			break
+		}
+
+		switch rx {
+		case "":
+		case "Tone":
+			c.RxTone, err = ctcss("cToneFreq")
+		case "DTCS":
+			code := "RxDtcsCode"
+			if field(code) == "" {
+				code = "DtcsCode"
+			}
+			c.RxTone, err = dcs(code, polarity[1])
+		default:
+			err = fmt.Errorf("cross mode %q", field("CrossMode"))
+		}
+	default:
+		err = fmt.Errorf("tone mode %q", field("Tone"))
+	}
+	if err != nil {
+		return Channel{}, err
+	}
+
+	return c, nil
+}
+
+// WriteCSV writes the channels in the CHIRP CSV format
+func WriteCSV(w io.Writer, list []Channel) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+
+	for i, c := range list {
+		record := map[string]string{
+			"Location":     strconv.Itoa(i + 1),
+			"Name":         c.Name,
+			"Frequency":    fmt.Sprintf("%.6f", c.RxFreq),
+			"Offset":       "0.000000",
+			"rToneFreq":    "88.5",
+			"cToneFreq":    "88.5",
+			"DtcsCode":     "023",
+			"DtcsPolarity": "NN",
+			"RxDtcsCode":   "023",
+			"CrossMode":    "Tone->Tone",
+			"Mode":         "FM",
+			"TStep":        "5.00",
+			"Power":        "1.0W",
+			"Comment":      c.Comment,
+		}
+
+		if c.Narrow {
+			record["Mode"] = "NFM"
+		}
+		if c.Skip {
+			record["Skip"] = "S"
+		}
+
+		switch offset := round(c.TxFreq - c.RxFreq); {
+		case offset == 0:
+		case math.Abs(offset) > maxOffset:
+			record["Duplex"] = "split"
+			record["Offset"] = fmt.Sprintf("%.6f", c.TxFreq)
+		case offset > 0:
+			record["Duplex"] = "+"
+			record["Offset"] = fmt.Sprintf("%.6f", offset)
+		default:
+			record["Duplex"] = "-"
+			record["Offset"] = fmt.Sprintf("%.6f", -offset)
+		}
+
+		writeTones(record, c.TxTone, c.RxTone)
+
+		row := make([]string, len(CSVHeader))
+		for i, h := range CSVHeader {
+			row[i] = record[h]
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTones sets the CHIRP tone mode and tone columns
+func writeTones(record map[string]string, tx, rx kv4pht.Tone) {
+	polarity := []byte("NN")
+	if tx.Type == kv4pht.ToneDCS && tx.Inverted {
+		polarity[0] = 'R'
+	}
+	if rx.Type == kv4pht.ToneDCS && rx.Inverted {
+		polarity[1] = 'R'
+	}
+	record["DtcsPolarity"] = string(polarity)
+
+	name := map[kv4pht.ToneType]string{kv4pht.ToneCTCSS: "Tone", kv4pht.ToneDCS: "DTCS"}
+
+	if tx.Type == kv4pht.ToneCTCSS {
+		record["rToneFreq"] = fmt.Sprintf("%.1f", tx.Freq)
+	}
+	if rx.Type == kv4pht.ToneCTCSS {
+		record["cToneFreq"] = fmt.Sprintf("%.1f", rx.Freq)
+	}
+	if tx.Type == kv4pht.ToneDCS {
+		record["DtcsCode"] = fmt.Sprintf("%03d", tx.DCS)
+	}
+	if rx.Type == kv4pht.ToneDCS {
+		record["RxDtcsCode"] = fmt.Sprintf("%03d", rx.DCS)
+	}
+
+	switch {
+	case tx.Type == kv4pht.ToneNone && rx.Type == kv4pht.ToneNone:
+	case tx.Type == kv4pht.ToneCTCSS && rx.Type == kv4pht.ToneNone:
+		record["Tone"] = "Tone"
+	case tx.Type == kv4pht.ToneCTCSS && rx.Type == kv4pht.ToneCTCSS && tx.Freq == rx.Freq:
+		record["Tone"] = "TSQL"
+		record["cToneFreq"] = record["rToneFreq"]
+	case tx.Type == kv4pht.ToneDCS && rx.Type == kv4pht.ToneDCS && tx.DCS == rx.DCS:
+		record["Tone"] = "DTCS"
+	default:
+		record["Tone"] = "Cross"
+		record["CrossMode"] = name[tx.Type] + "->" + name[rx.Type]
+	}
+}
+
+// round rounds a frequency (MHz) to 1 Hz
+func round(f float64) float64 {
+	return math.Round(f*1e6) / 1e6
+}
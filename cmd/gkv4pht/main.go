@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
@@ -9,6 +11,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +22,8 @@ import (
 	"github.com/hajimehoshi/ebiten/v2/vector"
 
 	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/bandplan"
+	"github.com/raff/kv4p-go/otosink"
 )
 
 const fontSize = 28
@@ -63,6 +68,7 @@ type Game struct {
 	low         *ToggleButton
 	scan        *ToggleButton
 	bandwidth   *ToggleButton
+	duplex      *ToggleButton
 	quit        bool
 
 	radio   *kv4pht.CommandProcessor
@@ -70,8 +76,16 @@ type Game struct {
 	bw      int
 	squelch int
 	freq    float64
+	shift   string  // repeater shift (+, -, auto)
+	offset  float64 // repeater offset, in MHz
+	txfreq  float64 // odd split transmit frequency, in MHz
+
+	module bandplan.Module
 
 	smeterValue int
+
+	statusMu sync.Mutex
+	status   string // last error, shown at the bottom of the window
 }
 
 type NumberInput struct {
@@ -437,11 +451,39 @@ func (g *Game) Draw(screen *ebiten.Image) {
 	g.waveform.Draw(screen)
 	g.scan.Draw(screen)
 	g.bandwidth.Draw(screen)
+	g.duplex.Draw(screen)
 	g.smeter.Draw(screen)
 	g.band.Draw(screen)
 	g.pre.Draw(screen)
 	g.high.Draw(screen)
 	g.low.Draw(screen)
+
+	if status := g.Status(); status != "" {
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(20, screenHeight-30)
+		op.ColorScale.ScaleWithColor(color.RGBA{0xcc, 0x00, 0x00, 0xff})
+		text.Draw(screen, status, smallFont, op)
+	}
+}
+
+// SetStatus sets the message shown at the bottom of the window ("" to clear it)
+func (g *Game) SetStatus(status string) {
+	g.statusMu.Lock()
+	g.status = status
+	g.statusMu.Unlock()
+}
+
+// Status returns the message shown at the bottom of the window
+func (g *Game) Status() string {
+	g.statusMu.Lock()
+	defer g.statusMu.Unlock()
+	return g.status
+}
+
+// ShowError logs the error and shows it at the bottom of the window
+func (g *Game) ShowError(what string, err error) {
+	log.Printf("%s: %v", what, err)
+	g.SetStatus(fmt.Sprintf("%s: %v", what, err))
 }
 
 func (g *Game) Update() error {
@@ -454,6 +496,7 @@ func (g *Game) Update() error {
 	g.waveform.Update(g.samples[:])
 	g.scan.Update()
 	g.bandwidth.Update()
+	g.duplex.Update()
 	g.smeter.Update(g.smeterValue)
 	g.band.Update()
 	g.pre.Update()
@@ -466,13 +509,56 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 	return screenWidth, screenHeight
 }
 
+// TxFreq returns the transmit frequency for the current settings
+func (g *Game) TxFreq() (float64, error) {
+	var txfreq float64
+
+	switch {
+	case g.duplex == nil || g.duplex.value:
+		txfreq = g.freq
+	case g.txfreq != 0:
+		txfreq = g.txfreq
+	default:
+		shift := g.shift
+		if shift == "" {
+			shift = "auto"
+		}
+
+		f, err := kv4pht.RepeaterTxFrequency(g.freq, shift, g.offset)
+		if err != nil {
+			return 0, err
+		}
+		txfreq = f
+	}
+
+	if err := kv4pht.CheckFrequency(g.module, g.mode, txfreq); err != nil {
+		return 0, err
+	}
+
+	return txfreq, nil
+}
+
+// SendGroup sends the current settings to the radio
+func (g *Game) SendGroup() error {
+	if err := kv4pht.CheckFrequency(g.module, g.mode, g.freq); err != nil {
+		return err
+	}
+
+	txfreq, err := g.TxFreq()
+	if err != nil {
+		return err
+	}
+
+	return g.radio.SendGroup(g.bw, txfreq, g.freq, g.squelch)
+}
+
 func (g *Game) Scan(freq float64) {
 	var min, max, step float64
 
 	if g.mode == kv4pht.MODE_VHF {
-		min, max = freq, float64(kv4pht.VHF_MAX_FREQ)
+		min, max = freq, g.module.VHF.Max
 	} else {
-		min, max = freq, float64(kv4pht.UHF_MAX_FREQ)
+		min, max = freq, g.module.UHF.Max
 	}
 
 	if g.bw == kv4pht.DRA818_25K {
@@ -482,12 +568,12 @@ func (g *Game) Scan(freq float64) {
 	}
 
 	if err := g.radio.SendFilters(false, false, false); err != nil {
-		log.Printf("Send FILTERS: %v", err)
+		g.ShowError("Send FILTERS", err)
 		return
 	}
 
 	if err := g.radio.SendGroup(g.bw, min, min, 4); err != nil { // it seems that scan needs to be sent with a squelch > 0
-		log.Printf("Send GROUP: %v", err)
+		g.ShowError("Send GROUP", err)
 		return
 	}
 
@@ -496,7 +582,7 @@ freq_loop:
 		g.numberInput.SetValue(int(f * 1000000))
 
 		if err := g.radio.SendScan(f); err != nil {
-			log.Printf("Send SCAN: %v", err)
+			g.ShowError("Send SCAN", err)
 			return
 		}
 
@@ -508,72 +594,102 @@ freq_loop:
 			time.Sleep(100 * time.Millisecond)
 		}
 
-		scanned := g.radio.Scanned()
-		switch {
-		case scanned == kv4pht.SCAN_WAITING:
-			log.Fatal("No SCAN response received")
+		switch scanned := g.radio.Scanned(); scanned {
+		case kv4pht.SCAN_WAITING:
+			// stop scanning, but restore the settings below
+			g.ShowError("Scan", fmt.Errorf("no SCAN response received for %v", f))
+			break freq_loop
 
-		case scanned == kv4pht.SCAN_NOT_FOUND:
+		case kv4pht.SCAN_NOT_FOUND:
 			continue
 
-		case scanned&kv4pht.SCAN_FOUND != 0:
+		case kv4pht.SCAN_FOUND:
 			log.Printf("SCAN found %v", f)
 			g.freq = f
 			break freq_loop
 
 		default:
-			log.Printf("SCAN unknown response %v", g.radio.Scanned())
+			log.Printf("SCAN unknown response %v", scanned)
 		}
 	}
 
 	g.numberInput.SetValue(int(g.freq * 1000000))
 
 	if err := g.radio.SendFilters(g.pre.value, g.high.value, g.low.value); err != nil {
-		log.Printf("Send FILTERS: %v", err)
+		g.ShowError("Send FILTERS", err)
 		return
 	}
-	if err := g.radio.SendGroup(g.bw, g.freq, g.freq, g.squelch); err != nil {
-		log.Printf("Send GROUP: %v", err)
+	if err := g.SendGroup(); err != nil {
+		g.ShowError("Send GROUP", err)
 	}
 }
 
 func main() {
-	dev := flag.String("dev", "", "Serial device to use (e.g. /dev/ttyUSB0)")
+	dev := flag.String("dev", "", "Serial device to use (e.g. /dev/ttyUSB0 or tcp://host:port)")
 	flag.BoolVar(&kv4pht.Debug, "debug", kv4pht.Debug, "Enable debug output")
 
 	band := flag.String("band", "vhf", "Band (vhf, uhf)")
 	bw := flag.String("bw", "wide", "Bandwidth (wide=25k, narrow=12.5k)")
 	freq := flag.Float64("freq", 162.4, "Frequency in MHz") // NOAA Weather Radio
+	shift := flag.String("shift", "", "Repeater shift (+, -, auto)")
+	offset := flag.Float64("offset", 0, "Repeater offset in MHz (default: 0.6 for VHF, 5 for UHF)")
+	txfreq := flag.Float64("txfreq", 0, "Transmit frequency in MHz, for odd splits (overrides -shift)")
 	squelch := flag.Int("squelch", 0, "Squelch level (0-8)")
 	pre := flag.Bool("pre", true, "pre-emphasis filter")
 	high := flag.Bool("high", true, "high-pass filter")
 	low := flag.Bool("low", true, "low-pass filter")
 	reset := flag.Bool("reset", false, "reset board")
+	module := flag.String("module", "sa818", "Radio module (sa818, dra818)")
+	reconnect := flag.Bool("reconnect", true, "Reopen the device and restore the settings when the connection is lost")
 	flag.Parse()
 
-	if *freq < kv4pht.VHF_MIN_FREQ {
-		*freq = kv4pht.VHF_MIN_FREQ
-	} else if *freq > kv4pht.VHF_MAX_FREQ && *freq < kv4pht.UHF_MIN_FREQ {
-		*freq = kv4pht.VHF_MAX_FREQ
-	} else if *freq > kv4pht.UHF_MAX_FREQ {
-		*freq = kv4pht.UHF_MAX_FREQ
+	g := &Game{shift: *shift, offset: *offset, txfreq: *txfreq}
+
+	var err error
+	if g.module, err = bandplan.ModuleByName(*module); err != nil {
+		log.Fatal(err)
 	}
 
-	g := &Game{}
+	*freq = g.module.Clamp(*freq)
+
+	if _, err := kv4pht.RepeaterTxFrequency(*freq, *shift, *offset); err != nil {
+		log.Fatal(err)
+	}
 
 	left := float32(20)
 	top := float32(20)
 
-	minfreq := int(kv4pht.VHF_MIN_FREQ * 1000000)
-	maxfreq := int(kv4pht.VHF_MAX_FREQ * 1000000)
-	if *band == "uhf" || *freq >= kv4pht.UHF_MIN_FREQ {
-		minfreq = int(kv4pht.UHF_MIN_FREQ * 1000000)
-		maxfreq = int(kv4pht.UHF_MAX_FREQ * 1000000)
+	limits := g.module.VHF
+	if *band == "uhf" || g.module.IsUHF(*freq) {
+		limits = g.module.UHF
+	}
+	minfreq, maxfreq := int(limits.Min*1000000), int(limits.Max*1000000)
+
+	player, err := otosink.New()
+	if err != nil {
+		log.Fatalf("Audio: %v", err)
+	}
+
+	g.mode = kv4pht.MODE_VHF
+	if *band == "uhf" || g.module.IsUHF(*freq) {
+		g.mode = kv4pht.MODE_UHF
 	}
 
-	radio, err := kv4pht.Start(*dev)
+	if err := kv4pht.CheckFrequency(g.module, g.mode, *freq); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	radio, err := kv4pht.Connect(ctx, kv4pht.ConnectOptions{
+		Device:    *dev,
+		Mode:      g.mode,
+		Reset:     *reset,
+		Reconnect: *reconnect,
+		Options:   []kv4pht.Option{kv4pht.WithAudioSink(player)},
+	})
+	stop()
 	if err != nil {
-		log.Fatalf("Start: %v", err)
+		log.Fatal(err)
 	}
 
 	g.numberInput = NewNumberInput(minfreq, maxfreq, left, top)
@@ -581,20 +697,16 @@ func main() {
 
 	g.band = NewToggleButton(left+w+20, top, w/2-10, h, "VHF   UHF", true, func(value bool) {
 
-		if value {
-			g.mode = kv4pht.MODE_VHF
-			minfreq := int(kv4pht.VHF_MIN_FREQ * 1000000)
-			maxfreq := int(kv4pht.VHF_MAX_FREQ * 1000000)
-			g.numberInput.SetLimits(minfreq, maxfreq)
-		} else {
+		limits := g.module.VHF
+		g.mode = kv4pht.MODE_VHF
+		if !value {
+			limits = g.module.UHF
 			g.mode = kv4pht.MODE_UHF
-			minfreq := int(kv4pht.UHF_MIN_FREQ * 1000000)
-			maxfreq := int(kv4pht.UHF_MAX_FREQ * 1000000)
-			g.numberInput.SetLimits(minfreq, maxfreq)
 		}
+		g.numberInput.SetLimits(int(limits.Min*1000000), int(limits.Max*1000000))
 
 		if err := g.radio.SendConfig(g.mode); err != nil {
-			log.Printf("Send CONFIG: %v", err)
+			g.ShowError("Send CONFIG", err)
 		}
 	})
 
@@ -611,15 +723,15 @@ func main() {
 			g.bw = kv4pht.DRA818_12K5
 		}
 
-		if err := g.radio.SendGroup(g.bw, g.freq, g.freq, g.squelch); err != nil {
-			log.Printf("Send GROUP: %v", err)
+		if err := g.SendGroup(); err != nil {
+			g.ShowError("Send GROUP", err)
 		}
 	})
 
 	top += h + 10
 	g.pre = NewToggleButton(left, top, w, h, "Pre-emph.", *pre, func(value bool) {
 		if err := g.radio.SendFilters(g.pre.value, g.high.value, g.low.value); err != nil {
-			log.Printf("Send FILTERS: %v", err)
+			g.ShowError("Send FILTERS", err)
 		}
 	})
 
@@ -635,24 +747,45 @@ func main() {
 	top += h + 10
 	g.high = NewToggleButton(left, top, w, h, "High-pass", *high, func(value bool) {
 		if err := g.radio.SendFilters(g.pre.value, g.high.value, g.low.value); err != nil {
-			log.Printf("Send FILTERS: %v", err)
+			g.ShowError("Send FILTERS", err)
+		}
+	})
+
+	g.duplex = NewToggleButton(left+w+20, top, w/2-10, h, "Simp. Dup.", *txfreq == 0 && *shift == "", func(value bool) {
+		if err := g.SendGroup(); err != nil {
+			g.ShowError("Send GROUP", err)
 		}
 	})
 
 	top += h + 10
 	g.low = NewToggleButton(left, top, w, h, "Low-pass", *high, func(value bool) {
 		if err := g.radio.SendFilters(g.pre.value, g.high.value, g.low.value); err != nil {
-			log.Printf("Send FILTERS: %v", err)
+			g.ShowError("Send FILTERS", err)
 		}
 	})
 
 	g.radio = radio
-	g.radio.AudioCallback = func(samples []int16) {
-		g.samples = samples
-	}
-	g.radio.SMeterCallback = func(smeter int) {
-		g.smeterValue = smeter
-	}
+	g.radio.BandPlan = bandplan.Plan{Module: g.module}
+
+	go func() {
+		for ev := range g.radio.Events() {
+			switch ev := ev.(type) {
+			case kv4pht.RxAudioEvent:
+				g.samples = ev.Samples
+			case kv4pht.SMeterEvent:
+				g.smeterValue = ev.Value
+			case kv4pht.ConnectionEvent:
+				switch ev.State {
+				case kv4pht.ConnectionLost:
+					g.ShowError("Connection lost", ev.Err)
+				case kv4pht.ConnectionRetrying:
+					g.SetStatus(fmt.Sprintf("Reconnecting (attempt %d)", ev.Attempt))
+				case kv4pht.ConnectionRestored:
+					g.SetStatus("")
+				}
+			}
+		}
+	}()
 
 	shutdown := func() {
 		g.quit = true
@@ -675,66 +808,16 @@ func main() {
 	}()
 
 	go func() {
-		if *reset {
-			log.Println("Resetting board")
-			g.radio.Reset()
-			time.Sleep(1 * time.Second)
-		}
-
-		// Wait for HELLO message
-		for i := 0; i < 2; i++ {
-			if g.radio.Hello() {
-				break
-			}
-
-			if i > 0 {
-				log.Println("Reset board")
-				g.radio.Reset()
-			}
-
-			for j := 0; j < 10 && !g.radio.Hello(); j++ {
-				log.Println("Waiting for HELLO message...")
-				time.Sleep(1 * time.Second)
-			}
-		}
-
-		if !g.radio.Hello() {
-			log.Println("No HELLO message received")
-			return
-		}
-
-		if err := g.radio.SendStop(); err != nil {
-			log.Fatalf("Send STOP: %v", err)
-			return
-		}
-
-		g.mode = kv4pht.MODE_VHF
-		if *band == "uhf" || *freq >= kv4pht.UHF_MIN_FREQ {
-			g.mode = kv4pht.MODE_UHF
-		}
-		if err := g.radio.SendConfig(g.mode); err != nil {
-			log.Fatalf("Send CONFIG: %v", err)
-			return
-		}
-
-		// Wait for VERSION message
-		for i := 0; i < 10; i++ {
-			v, _, _ := g.radio.Version()
-			if v != 0 {
-				break
-			}
-
-			log.Println("Waiting for VERSION message...")
-			time.Sleep(1 * time.Second)
-		}
-
-		if v, _, _ := g.radio.Version(); v == 0 {
-			log.Println("No VERSION message received")
-			return
+		<-g.radio.Done()
+		// the window stays open, showing why
+		if err := g.radio.Err(); !errors.Is(err, kv4pht.ErrStopped) {
+			g.ShowError("Connection lost", err)
 		}
+	}()
 
+	go func() {
 		if err := g.radio.SendFilters(*pre, *high, *low); err != nil {
-			log.Fatalf("Send FILTERS: %v", err)
+			g.ShowError("Send FILTERS", err)
 			return
 		}
 
@@ -757,13 +840,13 @@ func main() {
 		g.numberInput.ValueCallback = func(value int) {
 			g.freq = float64(value) / 1000000
 
-			if err := g.radio.SendGroup(g.bw, g.freq, g.freq, g.squelch); err != nil {
-				log.Printf("Send GROUP: %v", err)
+			if err := g.SendGroup(); err != nil {
+				g.ShowError("Send GROUP", err)
 			}
 		}
 
-		if err := g.radio.SendGroup(g.bw, g.freq, g.freq, g.squelch); err != nil {
-			log.Fatalf("Send GROUP: %v", err)
+		if err := g.SendGroup(); err != nil {
+			g.ShowError("Send GROUP", err)
 			return
 		}
 	}()
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/raff/kv4p-go/channels"
+)
+
+// memoryChannels imports and exports the memory channels, and lists them
+func memoryChannels(path, importFile, exportFile string) error {
+	store, err := channels.Load(path)
+	if err != nil {
+		return err
+	}
+
+	if importFile != "" {
+		f, err := os.Open(importFile)
+		if err != nil {
+			return err
+		}
+
+		list, err := channels.ReadCSV(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, c := range list {
+			if err := store.Set(c); err != nil {
+				return err
+			}
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+
+		log.Printf("Imported %d channels to %s", len(list), path)
+	}
+
+	if exportFile != "" {
+		f, err := os.Create(exportFile)
+		if err != nil {
+			return err
+		}
+
+		err = channels.WriteCSV(f, store.Channels)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Exported %d channels to %s", len(store.Channels), exportFile)
+	}
+
+	for _, c := range store.Channels {
+		bw := "wide"
+		if c.Narrow {
+			bw = "narrow"
+		}
+
+		fmt.Printf("%-16s RX %9.4f  TX %9.4f  %-6s  tones %s/%s  squelch %d\n",
+			c.Name, c.RxFreq, c.TxFreq, bw, c.TxTone, c.RxTone, c.Squelch)
+	}
+
+	return nil
+}
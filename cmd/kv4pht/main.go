@@ -1,47 +1,365 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/ax25"
+	"github.com/raff/kv4p-go/bandplan"
+	"github.com/raff/kv4p-go/channels"
+	"github.com/raff/kv4p-go/kiss"
+	"github.com/raff/kv4p-go/otosink"
+	"github.com/raff/kv4p-go/recorder"
+	"github.com/raff/kv4p-go/rigctld"
+	"github.com/raff/kv4p-go/same"
+	"github.com/raff/kv4p-go/tnc"
 )
 
+const usage = `Usage: kv4pht [command] [options]
+
+Commands:
+  rx		receive (default)
+  tx		transmit the audio file specified with -file
+  devices	list the connected devices (select one with -dev usb:SERIAL)
+  channels	list the memory channels (-import and -export read and write CHIRP CSV files)
+  rigctld	control the radio with the Hamlib rigctld protocol, on the -rigctl address
+  dtmf		print the DTMF digits received, or transmit the -digits
+  tonesearch	print the CTCSS tone or DCS code of the stations received on the current frequency
+  kiss		packet TNC (AFSK 1200), for APRS clients and the Linux AX.25 tools, on the -kiss address
+
+Options:
+`
+
 func main() {
-	dev := flag.String("dev", "", "Serial device to use (e.g. /dev/ttyUSB0)")
+	dev := flag.String("dev", "", "Serial device to use (e.g. /dev/ttyUSB0, usb:SERIAL or tcp://host:port)")
+	usbids := flag.String("usbid", "", "Comma separated list of additional USB IDs (VID:PID, in hex) of the devices")
 	reset := flag.Bool("reset", false, "Reset board")
+	reconnect := flag.Bool("reconnect", false, "Reopen the device and restore the settings when the connection is lost")
 	wait := flag.Duration("wait", 60*time.Second, "Receive time before exiting")
 	flag.BoolVar(&kv4pht.Debug, "debug", kv4pht.Debug, "Enable debug output")
 
 	band := flag.String("band", "vhf", "Band (vhf, uhf)")
 	bw := flag.String("bw", "wide", "Bandwidth (wide=25k, narrow=12.5k)")
 	freq := flag.Float64("freq", 162.4, "Frequency in MHz") // NOAA Weather Radio
+	shift := flag.String("shift", "", "Repeater shift (+, -, auto)")
+	offset := flag.Float64("offset", 0, "Repeater offset in MHz (default: 0.6 for VHF, 5 for UHF)")
+	txfreq := flag.Float64("txfreq", 0, "Transmit frequency in MHz, for odd splits (overrides -shift)")
 	squelch := flag.Int("squelch", 0, "Squelch level (0-8)")
+	txtone := flag.String("txtone", "", "CTCSS tone to transmit (e.g. 88.5; DCS codes are not supported by the radio module)")
+	rxtone := flag.String("rxtone", "", "CTCSS tone required to open the squelch (e.g. 88.5; DCS codes are not supported by the radio module)")
 	pre := flag.Bool("pre", false, "pre-emphasis filter")
 	high := flag.Bool("high", true, "high-pass filter")
 	low := flag.Bool("low", true, "low-pass filter")
 	scan := flag.Bool("scan", false, "Scan selected band")
+	scanEnd := flag.Float64("scanend", 0, "Frequency in MHz where the scan ends (default: end of the band)")
+	resume := flag.String("resume", "stop", "What to do after a scan hit (stop, carrier: resume when the signal is gone, timed: resume after 5 seconds)")
+	dwell := flag.Duration("dwell", 250*time.Millisecond, "Time spent listening on each frequency while scanning")
+	skip := flag.String("skip", "", "Comma separated list of frequencies (MHz) to skip while scanning")
+	carrier := flag.Bool("carrier", false, "Scan with the carrier detection of the radio module instead of the S-meter")
+	priority := flag.Float64("priority", 0, "Priority frequency in MHz, checked every 2 seconds while scanning")
 
 	volume := flag.Int("volume", 100, "Volume (0-100)")
-	flag.Parse()
+	recdir := flag.String("recdir", "", "Record each received transmission to its own file in this directory, with an index.json")
+	recformat := flag.String("recformat", "ogg", "Format of the recordings in -recdir (ogg, wav)")
+	sameAlerts := flag.Bool("same", false, "Decode the NOAA Weather Radio SAME alerts")
+	samedir := flag.String("samedir", "", "Record the message following each SAME alert in this directory (implies -same), in -recformat")
+	record := flag.String("record", "", "Record the received audio to a WAV (.wav) or Ogg Opus (.ogg, .opus) file")
+	audio := flag.String("audio", "oto", "Audio output (oto: speakers, pcm: raw 16-bit 48kHz mono to stdout, none)")
+	digits := flag.String("digits", "", "DTMF digits to transmit, with the dtmf command")
+	file := flag.String("file", "", "Audio file (WAV or Ogg Opus) to transmit, with the tx command")
+	module := flag.String("module", "sa818", "Radio module (sa818, dra818)")
+	region := flag.String("region", "", "ITU region (1, 2, 3): only transmit in its amateur bands")
+	license := flag.String("license", "", "US license class (technician, general, extra, ...): only transmit within its privileges")
+	maxtx := flag.Duration("maxtx", 3*time.Minute, "Maximum transmit time (0: no limit)")
+	channel := flag.String("channel", "", "Memory channel to use (flags set explicitly override its settings)")
+	channelsFile := flag.String("channels", "", "Memory channels file (default: kv4p/channels.json in the user configuration directory)")
+	rigctlAddr := flag.String("rigctl", rigctld.DefaultAddress, "Address to listen on, with the rigctld command")
+	kissAddr := flag.String("kiss", kiss.DefaultAddress, "Address to listen on, with the kiss command")
+	importCSV := flag.String("import", "", "CHIRP CSV file to import the memory channels from, with the channels command")
+	exportCSV := flag.String("export", "", "CHIRP CSV file to export the memory channels to, with the channels command")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	command := "rx"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if *channelsFile == "" {
+		path, err := channels.DefaultPath()
+		if err != nil {
+			log.Fatalf("Channels: %v", err)
+		}
+		*channelsFile = path
+	}
+
+	for _, id := range strings.FieldsFunc(*usbids, func(r rune) bool { return r == ',' }) {
+		vid, pid, ok := strings.Cut(strings.TrimSpace(id), ":")
+		if !ok {
+			log.Fatalf("Invalid USB ID: %q", id)
+		}
+		kv4pht.DeviceIDs = append(kv4pht.DeviceIDs, kv4pht.USBID{VID: vid, PID: pid})
+	}
+
+	if command == "devices" {
+		if err := listDevices(); err != nil {
+			log.Fatalf("Devices: %v", err)
+		}
+		return
+	}
+
+	if command == "channels" {
+		if err := memoryChannels(*channelsFile, *importCSV, *exportCSV); err != nil {
+			log.Fatalf("Channels: %v", err)
+		}
+		return
+	}
+
+	if *channel != "" {
+		store, err := channels.Load(*channelsFile)
+		if err != nil {
+			log.Fatalf("Channels: %v", err)
+		}
+		ch, err := store.Get(*channel)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		if !set["freq"] {
+			*freq = ch.RxFreq
+		}
+		if !set["txfreq"] && !set["shift"] && !set["freq"] {
+			*txfreq = ch.TxFreq
+		}
+		if !set["bw"] && ch.Narrow {
+			*bw = "narrow"
+		}
+		if !set["band"] && ch.Mode() == kv4pht.MODE_UHF {
+			*band = "uhf"
+		}
+		if !set["squelch"] {
+			*squelch = ch.Squelch
+		}
+		if !set["txtone"] {
+			*txtone = ch.TxTone.String()
+		}
+		if !set["rxtone"] {
+			*rxtone = ch.RxTone.String()
+		}
+		if !set["pre"] {
+			*pre = ch.PreEmphasis
+		}
+		if !set["high"] {
+			*high = ch.HighPass
+		}
+		if !set["low"] {
+			*low = ch.LowPass
+		}
+	}
+
+	var plan bandplan.Plan
+	var err error
+
+	if plan.Module, err = bandplan.ModuleByName(*module); err != nil {
+		log.Fatal(err)
+	}
+	if plan.Region, err = bandplan.ParseRegion(*region); err != nil {
+		log.Fatal(err)
+	}
+	if plan.License, err = bandplan.ParseLicense(*license); err != nil {
+		log.Fatal(err)
+	}
+
+	var txAudio []int16
+
+	switch command {
+	case "rx", "rigctld", "kiss":
+	case "tonesearch":
+		// the high-pass filter and the RX tone squelch remove the sub-audible tones
+		*high = false
+		*rxtone = ""
+	case "dtmf":
+		if _, err := kv4pht.DTMFTones(kv4pht.AUDIO_SAMPLING_RATE, *digits, 0, 0); err != nil {
+			log.Fatalf("dtmf: %v", err)
+		}
+	case "tx":
+		if *file == "" {
+			log.Fatal("tx: missing -file")
+		}
+
+		if txAudio, err = loadAudio(*file); err != nil {
+			log.Fatalf("tx: %v", err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	txTone, err := kv4pht.ParseTone(*txtone)
+	if err != nil {
+		log.Fatalf("TX tone: %v", err)
+	}
+	rxTone, err := kv4pht.ParseTone(*rxtone)
+	if err != nil {
+		log.Fatalf("RX tone: %v", err)
+	}
+
+	resumeMode, err := kv4pht.ParseResumeMode(*resume)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var skipFreqs []float64
+	for _, f := range strings.Split(*skip, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+
+		sf, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			log.Fatalf("Invalid skip frequency %q: %v", f, err)
+		}
+		skipFreqs = append(skipFreqs, sf)
+	}
+
+	var sink kv4pht.AudioSink
+
+	switch *audio {
+	case "oto":
+		if sink, err = otosink.New(); err != nil {
+			log.Fatalf("Audio: %v", err)
+		}
+	case "pcm":
+		sink = kv4pht.PCMSink(os.Stdout)
+	case "none":
+		sink = kv4pht.NullSink()
+	default:
+		log.Fatalf("Invalid audio output: %q", *audio)
+	}
+
+	var rec *recorder.Recorder
+	if *recdir != "" {
+		config := recorder.Config{Dir: *recdir, Format: *recformat}
+		if *squelch > 0 {
+			// with the squelch open the noise would trigger the recordings
+			config.EnergyThreshold = 0.01
+		}
+		if rec, err = recorder.New(config); err != nil {
+			log.Fatalf("Record: %v", err)
+		}
+	}
+
+	var monitor *same.Monitor
+	if *sameAlerts || *samedir != "" {
+		if monitor, err = same.New(same.Config{Dir: *samedir, Format: *recformat}); err != nil {
+			log.Fatalf("SAME: %v", err)
+		}
+	}
+
+	if f := plan.Module.Clamp(*freq); f != *freq {
+		log.Printf("%v, using %.4f MHz", plan.CheckRX(*freq), f)
+		*freq = f
+	}
+
+	mode := kv4pht.MODE_VHF
+	if *band == "uhf" || plan.Module.IsUHF(*freq) {
+		mode = kv4pht.MODE_UHF
+	}
+
+	if err := kv4pht.CheckFrequency(plan.Module, mode, *freq); err != nil {
+		log.Fatalf("RX frequency: %v", err)
+	}
 
-	p, err := kv4pht.Start(*dev)
+	if *txfreq == 0 {
+		if *txfreq, err = kv4pht.RepeaterTxFrequency(*freq, *shift, *offset); err != nil {
+			log.Fatalf("TX frequency: %v", err)
+		}
+	}
+	if err := kv4pht.CheckFrequency(plan.Module, mode, *txfreq); err != nil {
+		log.Fatalf("TX frequency: %v", err)
+	}
+
+	connectCtx, stopConnect := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	p, err := kv4pht.Connect(connectCtx, kv4pht.ConnectOptions{
+		Device:    *dev,
+		Mode:      mode,
+		Reset:     *reset,
+		Reconnect: *reconnect,
+		Options:   []kv4pht.Option{kv4pht.WithAudioSink(sink)},
+	})
+	stopConnect()
 	if err != nil {
-		log.Fatalf("Start: %v", err)
+		log.Fatal(err)
+	}
+
+	p.MaxTxTime = *maxtx
+	p.BandPlan = plan
+
+	recDone := make(chan struct{})
+	if rec != nil {
+		go func() {
+			defer close(recDone)
+			rec.Run(context.Background(), p)
+		}()
+	} else {
+		close(recDone)
 	}
 
+	sameDone := make(chan struct{})
+	if monitor != nil {
+		events, _ := p.Subscribe(16)
+		go logAlerts(events)
+
+		go func() {
+			defer close(sameDone)
+			monitor.Run(context.Background(), p)
+		}()
+	} else {
+		close(sameDone)
+	}
+
+	// shutdown can be called from main, the signal handler and the connection monitor:
+	// only the first call runs, the others block until the process exits
+	var shutdownOnce sync.Once
 	shutdown := func() {
-		p.Stop()
-		os.Exit(0)
+		shutdownOnce.Do(func() {
+			p.Stop()
+			<-p.Done()
+			<-recDone // wait for the last transmission to be saved
+			<-sameDone
+
+			if err := p.Err(); !errors.Is(err, kv4pht.ErrStopped) {
+				os.Exit(1) // connection lost
+			}
+			os.Exit(0)
+		})
 	}
 
 	defer shutdown()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc,
 		syscall.SIGHUP,
@@ -51,14 +369,17 @@ func main() {
 	go func() {
 		s := <-sigc
 		log.Println(s)
+		cancel() // stop transmitting
 		shutdown()
 	}()
 
-	if *reset {
-		log.Println("Resetting board")
-		p.Reset()
-		time.Sleep(1 * time.Second)
-	}
+	go func() {
+		<-p.Done()
+		if !errors.Is(p.Err(), kv4pht.ErrStopped) {
+			cancel()
+			shutdown()
+		}
+	}()
 
 	/*
 		if *dtr {
@@ -80,66 +401,6 @@ func main() {
 		}
 	*/
 
-	// Wait for HELLO message
-	for i := 0; i < 2; i++ {
-		if p.Hello() {
-			break
-		}
-
-		if i > 0 {
-			log.Println("Reset board")
-			p.Reset()
-		}
-
-		for j := 0; j < 10 && !p.Hello(); j++ {
-			log.Println("Waiting for HELLO message...")
-			time.Sleep(1 * time.Second)
-		}
-	}
-
-	if !p.Hello() {
-		log.Println("No HELLO message received")
-		return
-	}
-
-	if err := p.SendStop(); err != nil {
-		log.Fatalf("Send STOP: %v", err)
-		return
-	}
-
-	if *freq < kv4pht.VHF_MIN_FREQ {
-		*freq = kv4pht.VHF_MIN_FREQ
-	} else if *freq > kv4pht.VHF_MAX_FREQ && *freq < kv4pht.UHF_MIN_FREQ {
-		*freq = kv4pht.VHF_MAX_FREQ
-	} else if *freq > kv4pht.UHF_MAX_FREQ {
-		*freq = kv4pht.UHF_MAX_FREQ
-	}
-
-	mode := kv4pht.MODE_VHF
-	if *band == "uhf" || *freq >= kv4pht.UHF_MIN_FREQ {
-		mode = kv4pht.MODE_UHF
-	}
-	if err := p.SendConfig(mode); err != nil {
-		log.Fatalf("Send CONFIG: %v", err)
-		return
-	}
-
-	// Wait for VERSION message
-	for i := 0; i < 10; i++ {
-		v, _, _ := p.Version()
-		if v != 0 {
-			break
-		}
-
-		log.Println("Waiting for VERSION message...")
-		time.Sleep(1 * time.Second)
-	}
-
-	if v, _, _ := p.Version(); v == 0 {
-		log.Println("No VERSION message received")
-		return
-	}
-
 	if err := p.SendFilters(*pre, *high, *low); err != nil {
 		log.Fatalf("Send FILTERS: %v", err)
 		return
@@ -164,55 +425,218 @@ func main() {
 	}
 
 	if *scan {
-		var min, max, step float64
-
-		if mode == kv4pht.MODE_VHF {
-			min, max = float64(*freq), float64(kv4pht.VHF_MAX_FREQ)
-		} else {
-			min, max = float64(*freq), float64(kv4pht.UHF_MAX_FREQ)
+		config := kv4pht.ScanConfig{
+			Start:     *freq,
+			End:       *scanEnd,
+			Bandwidth: rbw,
+			Squelch:   *squelch,
+			Dwell:     *dwell,
+			Resume:    resumeMode,
+			Skip:      skipFreqs,
+
+			CarrierDetect: *carrier,
 		}
 
-		if rbw == kv4pht.DRA818_25K {
-			step = 0.025
-		} else {
-			step = 0.0125
+		if config.CarrierDetect && config.Squelch == 0 {
+			config.Squelch = 4 // carrier detection requires squelch
 		}
 
-		fmt.Println("SCANNING...")
-	freq_loop:
-		for f := min; f <= max; f += step {
-			log.Printf("FREQ: %3.3f", f)
-			if err := p.SendGroup(rbw, f, f, *squelch); err != nil {
-				log.Fatalf("Send GROUP: %v", err)
-				return
+		if config.End == 0 {
+			if mode == kv4pht.MODE_VHF {
+				config.End = plan.Module.VHF.Max
+			} else {
+				config.End = plan.Module.UHF.Max
 			}
+		}
+		if rbw == kv4pht.DRA818_12K5 {
+			config.Step = 0.0125
+		}
+		if resumeMode == kv4pht.ResumeStop {
+			config.Passes = 1
+		}
+		if *priority != 0 {
+			config.Priority = &kv4pht.ScanChannel{GroupOptions: kv4pht.GroupOptions{
+				Bandwidth: rbw,
+				TxFreq:    *priority,
+				RxFreq:    *priority,
+				Squelch:   *squelch,
+			}}
+		}
 
-			_, start := p.SMeter()
-
-			for {
-				smeter, scount := p.SMeter()
-
-				if kv4pht.Debug {
-					log.Printf("...%v (%v)", scount-start, smeter)
-				}
+		scanner, err := kv4pht.NewScanner(p, config)
+		if err != nil {
+			log.Fatalf("Scan: %v", err)
+		}
 
-				if smeter > 3 || scount-start > 20 {
-					break freq_loop
+		events, cancelEvents := p.Subscribe(64)
+		go func() {
+			for ev := range events {
+				if hit, ok := ev.(kv4pht.ScanHitEvent); ok && hit.SMeter > 0 {
+					log.Printf("FREQ: %3.4f S%d", hit.Channel.RxFreq, hit.SMeter)
+				} else if ok {
+					log.Printf("FREQ: %3.4f", hit.Channel.RxFreq)
 				}
+			}
+		}()
 
-				time.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(os.Stderr, "SCANNING...")
+		if resumeMode == kv4pht.ResumeStop {
+			if _, err := scanner.Run(ctx); err != nil {
+				log.Printf("Scan: %v", err)
 			}
-		}
+			cancelEvents()
+			fmt.Fprintln(os.Stderr, "SCAN Done")
+		} else {
+			scanCtx, cancelScan := context.WithTimeout(ctx, *wait)
+			defer cancelScan()
 
-		fmt.Println("SCAN Done")
+			go func() {
+				if _, err := scanner.Run(scanCtx); err != nil && scanCtx.Err() == nil {
+					log.Printf("Scan: %v", err)
+				}
+			}()
+		}
 	} else {
-		log.Printf("FREQ: %3.3f", *freq)
-		if err := p.SendGroup(rbw, *freq, *freq, *squelch); err != nil {
+		log.Printf("FREQ: %3.3f, TX: %3.3f", *freq, *txfreq)
+		if err := p.SendGroupOptions(kv4pht.GroupOptions{
+			Bandwidth: rbw,
+			TxFreq:    *txfreq,
+			RxFreq:    *freq,
+			Squelch:   *squelch,
+			TxTone:    txTone,
+			RxTone:    rxTone,
+		}); err != nil {
 			log.Fatalf("Send GROUP: %v", err)
 			return
 		}
 	}
 
-	fmt.Println("Press Ctrl+C to exit")
+	if *record != "" {
+		comments := []string{"DATE=" + time.Now().Format(time.RFC3339)}
+		if !*scan {
+			comments = append(comments, fmt.Sprintf("FREQUENCY=%.4f", *freq))
+		}
+
+		rec, err := kv4pht.CreateRecording(*record, comments...)
+		if err != nil {
+			log.Fatalf("Record: %v", err)
+		}
+
+		// the recording is closed by Stop
+		p.AddSink(rec)
+		log.Println("Recording to", *record)
+	}
+
+	if command == "rigctld" {
+		l, err := net.Listen("tcp", *rigctlAddr)
+		if err != nil {
+			log.Fatalf("rigctld: %v", err)
+		}
+
+		log.Println("rigctld listening on", l.Addr())
+		go func() {
+			log.Println("rigctld:", rigctld.New(p, mode).Serve(l))
+		}()
+
+		select {} // until interrupted: the signal handler shuts down
+	}
+
+	if command == "kiss" {
+		l, err := net.Listen("tcp", *kissAddr)
+		if err != nil {
+			log.Fatalf("kiss: %v", err)
+		}
+
+		srv := kiss.NewServer(nil)
+		t := tnc.New(tnc.Config{OnFrame: func(frame []byte) {
+			if f, err := ax25.Decode(frame); err == nil {
+				log.Println("Packet:", f)
+			}
+			srv.Broadcast(frame)
+		}})
+		srv.TNC = t
+
+		log.Println("KISS TNC listening on", l.Addr())
+		go func() {
+			log.Println("kiss:", srv.Serve(l))
+		}()
+		go func() {
+			log.Println("tnc:", t.Run(ctx, p))
+		}()
+
+		select {} // until interrupted: the signal handler shuts down
+	}
+
+	if command == "dtmf" {
+		if *digits != "" {
+			if err := p.SendDTMF(ctx, *digits); err != nil {
+				log.Printf("dtmf: %v", err)
+			}
+			return
+		}
+
+		events, _ := p.Subscribe(16)
+		go func() {
+			for ev := range events {
+				if ev, ok := ev.(kv4pht.DTMFEvent); ok {
+					log.Printf("DTMF: %c (%dms)", ev.Digit, ev.Duration.Milliseconds())
+				}
+			}
+		}()
+		p.DetectDTMF()
+	}
+
+	if command == "tonesearch" {
+		events, _ := p.Subscribe(16)
+		go func() {
+			for ev := range events {
+				if ev, ok := ev.(kv4pht.ToneEvent); ok {
+					log.Println("TONE:", ev.Tone)
+				}
+			}
+		}()
+		p.DetectTones()
+	}
+
+	if command == "tx" {
+		if err := transmitFile(ctx, p, txAudio); err != nil {
+			log.Printf("tx: %v", err)
+		}
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Press Ctrl+C to exit")
 	time.Sleep(*wait)
 }
+
+// listDevices prints the serial ports with a supported device
+func listDevices() error {
+	devices, err := kv4pht.Discover()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range devices {
+		fmt.Printf("%-16s %s:%s  serial %-20s %s\n", d.Port, d.VID, d.PID, d.Serial, d.Product)
+	}
+	if len(devices) == 0 {
+		return kv4pht.ErrNoDevice
+	}
+	return nil
+}
+
+// logAlerts logs the SAME events, until the channel is closed
+func logAlerts(events <-chan kv4pht.Event) {
+	for ev := range events {
+		switch ev := ev.(type) {
+		case same.AlertEvent:
+			h := ev.Header
+			log.Printf("ALERT: %s from %s (%s), until %s, locations %s", h.EventName(), h.OriginatorName(), h.Sender,
+				h.Expires().Local().Format("Jan 2 15:04"), strings.Join(h.Locations, " "))
+		case same.AttentionToneEvent:
+			log.Printf("ALERT: attention tone (%.1fs)", ev.Duration.Seconds())
+		case same.EndOfMessageEvent:
+			log.Println("ALERT: end of message")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/dsp"
+	"github.com/raff/kv4p-go/oggopus"
+	"github.com/raff/kv4p-go/wav"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// loadAudio reads a WAV or Ogg Opus file and returns its content as mono samples at kv4pht.AUDIO_SAMPLING_RATE
+func loadAudio(path string) ([]int16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []int16
+	var rate, channels int
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		r, err := wav.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		if samples, err = r.ReadAll(); err != nil {
+			return nil, err
+		}
+		rate, channels = r.SampleRate, r.Channels
+
+	case ".ogg", ".opus":
+		if samples, err = decodeOgg(f); err != nil {
+			return nil, err
+		}
+		rate, channels = kv4pht.AUDIO_SAMPLING_RATE, 1
+
+	default:
+		return nil, fmt.Errorf("%s: unsupported audio file (use .wav, .ogg or .opus)", path)
+	}
+
+	samples = dsp.Mono(samples, channels)
+	return dsp.Resample(samples, rate, kv4pht.AUDIO_SAMPLING_RATE), nil
+}
+
+// decodeOgg decodes an Ogg Opus stream to mono samples at 48kHz
+func decodeOgg(r io.Reader) ([]int16, error) {
+	or, err := oggopus.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// decode to mono: libopus mixes down multichannel streams
+	dec, err := opus.NewDecoder(oggopus.SampleRate, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []int16
+	var frame [5760]int16 // 120ms, the longest Opus packet
+
+	for {
+		packet, err := or.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := dec.Decode(packet, frame[:])
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, frame[:n]...)
+	}
+
+	return samples[min(or.PreSkip, len(samples)):], nil
+}
+
+// transmitFile transmits the audio file on the current frequency
+func transmitFile(ctx context.Context, p *kv4pht.CommandProcessor, samples []int16) error {
+	duration := time.Duration(len(samples)) * time.Second / kv4pht.AUDIO_SAMPLING_RATE
+	log.Printf("Transmitting %v of audio", duration.Round(time.Millisecond))
+
+	start := time.Now()
+	if err := p.Transmit(ctx, samples); err != nil {
+		return err
+	}
+
+	log.Printf("Transmission completed in %v", time.Since(start).Round(time.Millisecond))
+	return nil
+}
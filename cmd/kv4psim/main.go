@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/raff/kv4p-go/simulator"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:8650", "Address to listen on (use -dev tcp://address with the clients)")
+	freqs := flag.String("freq", "162.4", "Comma separated list of frequencies (MHz) with a simulated carrier")
+	level := flag.Int("level", 180, "S-meter level of the carriers (0-255)")
+	tone := flag.Float64("tone", 1000, "Frequency of the tone transmitted by the carriers, in Hz")
+	wavFile := flag.String("wav", "", "WAV file (16-bit) transmitted by the carriers, instead of a tone")
+	noise := flag.Float64("noise", 0.05, "Amplitude of the noise received with no carrier and open squelch (0-1)")
+	window := flag.Int("window", 1024, "Receive window size")
+	flag.Parse()
+
+	d := simulator.New()
+	d.WindowSize = *window
+	d.Noise = simulator.Noise(*noise)
+
+	for _, f := range strings.Split(*freqs, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+
+		freq, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			log.Fatalf("Invalid frequency %q: %v", f, err)
+		}
+
+		var source simulator.Source
+		if *wavFile != "" {
+			if source, err = simulator.WAVFile(*wavFile); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			source = simulator.Tone(*tone, 0.5)
+		}
+
+		d.Carriers = append(d.Carriers, simulator.Carrier{Freq: freq, Level: *level, Source: source})
+	}
+
+	frames := 0
+	d.TxAudio = func(samples []int16) {
+		if frames++; frames%25 == 0 {
+			log.Printf("Received %d TX audio frames", frames)
+		}
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Listening on", l.Addr())
+	log.Fatal(d.Serve(l))
+}
@@ -0,0 +1,269 @@
+package kv4pht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrNoHello   = fmt.Errorf("No HELLO message received")
+	ErrNoVersion = fmt.Errorf("No VERSION message received")
+)
+
+// ConnectError is returned by Connect when one of the connection steps fails
+type ConnectError struct {
+	Step string // "open", "hello", "stop", "config" or "version"
+	Err  error  // i.e. ErrNoDevice, ErrNoHello, ErrNoVersion, ErrTransportClosed or the context error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("Connect (%s): %v", e.Step, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectOptions configures Connect. Zero values select the defaults.
+type ConnectOptions struct {
+	Device    string                    // see OpenTransport (default: the first serial port with an ESP32 device)
+	DeviceIDs []USBID                   // USB IDs of the devices, when Device is "" or "usb:SERIAL" (default: DeviceIDs)
+	Open      func() (Transport, error) // opens the transport, instead of OpenTransport(Device)
+	Mode      int                       // MODE_VHF (default) or MODE_UHF, sent with the CONFIG command
+	Reset     bool                      // reset the board before waiting for HELLO
+	Timeout   time.Duration             // maximum wait for the HELLO and VERSION messages (default: 10s)
+	Options   []Option                  // options for the CommandProcessor (i.e. WithAudioSink)
+
+	// Reconnect reopens the device when the connection is lost (i.e. the board resets or the cable is unplugged),
+	// performs the handshake again and restores the last settings sent with SendConfig, SendFilters and SendGroup.
+	// With the default Device, or "usb:SERIAL", the serial ports are enumerated again, since the port name may change.
+	// The subscribers receive a ConnectionEvent for each state change.
+	Reconnect     bool
+	RetryInterval time.Duration // between the reconnection attempts (default: 2s)
+}
+
+// stopDelay is how long the device is given to stop streaming after CMD_STOP
+const stopDelay = time.Second
+
+// Connect opens the device and starts a CommandProcessor, then performs the handshake: it waits for HELLO
+// (resetting the board once if it doesn't come), sends STOP and CONFIG and waits for VERSION.
+//
+// Failures are returned as *ConnectError. Once connected, use Done and Err to know if the connection is lost.
+func Connect(ctx context.Context, opts ConnectOptions) (*CommandProcessor, error) {
+	if opts.Mode == 0 {
+		opts.Mode = MODE_VHF
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = 2 * time.Second
+	}
+	if len(opts.DeviceIDs) == 0 {
+		opts.DeviceIDs = DeviceIDs
+	}
+	if opts.Open == nil {
+		opts.Open = func() (Transport, error) { return openTransport(opts.Device, opts.DeviceIDs) }
+	}
+
+	t, err := opts.Open()
+	if err != nil {
+		return nil, &ConnectError{Step: "open", Err: err}
+	}
+
+	p, err := newCommandProcessor(t)
+	if err != nil {
+		t.Close()
+		return nil, &ConnectError{Step: "open", Err: err}
+	}
+
+	for _, opt := range opts.Options {
+		opt(p)
+	}
+	p.connect = &opts
+
+	go p.readLoop()
+
+	if err := p.handshake(ctx, opts); err != nil {
+		p.abort()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// handshake brings the device to a known state, after it's (re)connected
+func (p *CommandProcessor) handshake(ctx context.Context, opts ConnectOptions) error {
+	if opts.Reset {
+		log.Println("Resetting board")
+		p.Reset()
+	}
+
+	log.Println("Waiting for HELLO message...")
+	err := p.waitFor(ctx, opts.Timeout, ErrNoHello, p.Hello)
+	if errors.Is(err, ErrNoHello) {
+		log.Println("Reset board")
+		p.Reset()
+		err = p.waitFor(ctx, opts.Timeout, ErrNoHello, p.Hello)
+	}
+	if err != nil {
+		return &ConnectError{Step: "hello", Err: err}
+	}
+
+	log.Println("Sending STOP command")
+	if err := p.sendCommandContext(ctx, CMD_STOP, nil); err != nil {
+		return &ConnectError{Step: "stop", Err: err}
+	}
+	select {
+	case <-ctx.Done():
+		return &ConnectError{Step: "stop", Err: ctx.Err()}
+	case <-p.done:
+		return &ConnectError{Step: "stop", Err: p.Err()}
+	case <-time.After(stopDelay):
+	}
+
+	p.mu.Lock()
+	p.version = 0
+	p.mu.Unlock()
+
+	log.Println("Sending CONFIG command")
+	if err := p.sendCommandContext(ctx, CMD_CONFIG, []byte{byte(opts.Mode)}); err != nil {
+		return &ConnectError{Step: "config", Err: err}
+	}
+
+	p.mu.Lock()
+	p.mode = opts.Mode
+	p.mu.Unlock()
+
+	log.Println("Waiting for VERSION message...")
+	if err := p.waitFor(ctx, opts.Timeout, ErrNoVersion, func() bool { v, _, _ := p.Version(); return v != 0 }); err != nil {
+		return &ConnectError{Step: "version", Err: err}
+	}
+
+	return nil
+}
+
+// waitFor polls cond until it's true. It returns timeoutErr after timeout, the context error if the context is done,
+// or Err if the CommandProcessor stops.
+func (p *CommandProcessor) waitFor(ctx context.Context, timeout time.Duration, timeoutErr error, cond func() bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return p.Err()
+		case <-timer.C:
+			return timeoutErr
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// abort closes the transport of a CommandProcessor that failed to connect (the sinks are left open)
+func (p *CommandProcessor) abort() {
+	p.mu.Lock()
+	p.quit = true
+	p.mu.Unlock()
+	p.stopCancel()
+
+	p.conn().Close()
+	<-p.done
+}
+
+// reconnect is called by the reader goroutine when the connection is lost because of cause.
+// It reopens the transport until the handshake succeeds, then it reads from the new transport until
+// the connection is lost again, and returns why (ErrStopped if p is stopped).
+func (p *CommandProcessor) reconnect(cause error) error {
+	p.emit(ConnectionEvent{State: ConnectionLost, Err: cause})
+
+	// release the port, that may be the one reopened
+	p.conn().Close()
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-p.stopCtx.Done():
+			return ErrStopped
+		case <-time.After(p.connect.RetryInterval):
+		}
+
+		log.Printf("Reconnecting (attempt %d)", attempt)
+		p.emit(ConnectionEvent{State: ConnectionRetrying, Err: cause, Attempt: attempt})
+
+		t, err := p.connect.Open()
+		if err != nil {
+			log.Printf("Reconnect: %v", err)
+			cause = err
+			continue
+		}
+
+		p.mu.Lock()
+		if p.quit {
+			p.mu.Unlock()
+			t.Close()
+			return ErrStopped
+		}
+		p.transport, p.hello = t, false
+		p.mu.Unlock()
+		p.window.set(INITIAL_WINDOW)
+
+		read := make(chan error, 1)
+		go func() { read <- p.read() }()
+
+		if err := p.restore(); err != nil {
+			log.Printf("Reconnect: %v", err)
+			cause = err
+
+			t.Close()
+			if err := <-read; errors.Is(err, ErrStopped) {
+				return err
+			}
+			continue
+		}
+
+		log.Println("Reconnected")
+		p.emit(ConnectionEvent{State: ConnectionRestored, Attempt: attempt})
+		return <-read
+	}
+}
+
+// restore performs the handshake on a new transport, and sends the last FILTERS and GROUP again
+func (p *CommandProcessor) restore() error {
+	p.mu.Lock()
+	opts := *p.connect
+	opts.Reset = false
+	if p.mode != 0 {
+		opts.Mode = p.mode
+	}
+	filters, hasFilters := p.filters, p.hasFilters
+	group, hasGroup := p.group, p.hasGroup
+	p.mu.Unlock()
+
+	if err := p.handshake(p.stopCtx, opts); err != nil {
+		return err
+	}
+
+	if hasFilters {
+		log.Println("Sending FILTERS command")
+		if err := p.sendCommandContext(p.stopCtx, CMD_FILTERS, []byte{filters}); err != nil {
+			return err
+		}
+	}
+	if hasGroup {
+		if err := p.SendGroupOptions(group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func tone(freq float64, rate, n int, amplitude float64) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return samples
+}
+
+func level(samples []int16) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestResample(t *testing.T) {
+	for _, rates := range [][2]int{{8000, 48000}, {44100, 48000}, {96000, 48000}} {
+		from, to := rates[0], rates[1]
+
+		// 250ms
+		in := tone(1000, from, from/4, 10000)
+		out := Resample(in, from, to)
+		if len(out) != to/4 {
+			t.Errorf("%d->%d: expected %d samples, got %d", from, to, to/4, len(out))
+			continue
+		}
+
+		// skip the edges, then compare with the ideal tone at the new rate
+		expected := tone(1000, to, to/4, 10000)
+		var errSum float64
+		for i := 100; i < len(out)-100; i++ {
+			d := float64(out[i]) - float64(expected[i])
+			errSum += d * d
+		}
+		if rmsErr := math.Sqrt(errSum / float64(len(out)-200)); rmsErr > 50 {
+			t.Errorf("%d->%d: RMS error %.1f", from, to, rmsErr)
+		}
+	}
+
+	// frequencies above the new Nyquist frequency are filtered out
+	out := Resample(tone(30000, 96000, 96000/4, 10000), 96000, 48000)
+	if l := level(out[100 : len(out)-100]); l > 100 {
+		t.Errorf("aliased tone not filtered (level %.1f)", l)
+	}
+}
+
+func TestMono(t *testing.T) {
+	mono := Mono([]int16{100, 300, -200, 200, 32767, 32767}, 2)
+	if len(mono) != 3 || mono[0] != 200 || mono[1] != 0 || mono[2] != 32767 {
+		t.Errorf("unexpected mono samples %v", mono)
+	}
+}
+
+func TestLowPass(t *testing.T) {
+	for _, c := range []struct {
+		freq float64
+		min  float64
+		max  float64
+	}{
+		{100, 0.95, 1.05}, // pass band
+		{300, 0.65, 0.75}, // cutoff: -3dB
+		{3000, 0, 0.02},   // stop band
+	} {
+		f := LowPass(48000, 300, 0.7071)
+		in := tone(c.freq, 48000, 48000, 10000)
+		out := make([]int16, len(in))
+		for i, s := range in {
+			out[i] = Clip(f.Filter(float64(s)))
+		}
+
+		// skip the transient
+		if gain := level(out[4800:]) / level(in[4800:]); gain < c.min || gain > c.max {
+			t.Errorf("%.0f Hz: gain %.3f, expected %.2f-%.2f", c.freq, gain, c.min, c.max)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package dsp
+
+import "math"
+
+// Biquad is a second order IIR filter
+type Biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// LowPass returns a second order low-pass filter, with the cutoff frequency and Q (0.7071 for Butterworth)
+func LowPass(sampleRate int, cutoff, q float64) *Biquad {
+	w := 2 * math.Pi * cutoff / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+
+	return &Biquad{
+		b0: (1 - cos) / 2 / a0,
+		b1: (1 - cos) / a0,
+		b2: (1 - cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+// Filter returns the next output sample
+func (f *Biquad) Filter(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
@@ -0,0 +1,74 @@
+// Package dsp contains the signal processing used by the kv4p tools
+package dsp
+
+import "math"
+
+// resampleTaps is the half width (in samples of the lower rate) of the interpolation filter
+const resampleTaps = 16
+
+// Resample converts the samples from one sample rate to another, with a windowed sinc interpolation
+// that also filters out the frequencies above the lower Nyquist frequency.
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || len(samples) == 0 {
+		return append([]int16(nil), samples...)
+	}
+
+	ratio := float64(to) / float64(from)
+	cutoff := min(1, ratio) // relative to the input Nyquist frequency
+	width := resampleTaps / cutoff
+
+	out := make([]int16, int(float64(len(samples))*ratio))
+	for i := range out {
+		t := float64(i) / ratio // position in the input
+		first := max(0, int(math.Ceil(t-width)))
+		last := min(len(samples)-1, int(math.Floor(t+width)))
+
+		var sum float64
+		for k := first; k <= last; k++ {
+			x := t - float64(k)
+			sum += float64(samples[k]) * cutoff * sinc(cutoff*x) * blackman(x/width)
+		}
+
+		out[i] = Clip(sum)
+	}
+
+	return out
+}
+
+// Mono mixes interleaved samples with the specified number of channels down to one channel
+func Mono(samples []int16, channels int) []int16 {
+	if channels <= 1 {
+		return samples
+	}
+
+	mono := make([]int16, len(samples)/channels)
+	for i := range mono {
+		var sum int
+		for c := 0; c < channels; c++ {
+			sum += int(samples[i*channels+c])
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return mono
+}
+
+// Clip rounds a sample value to the nearest int16, saturating at the limits
+func Clip(v float64) int16 {
+	return int16(max(math.MinInt16, min(math.MaxInt16, math.Round(v))))
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// blackman is the Blackman window, for x in [-1, 1]
+func blackman(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
@@ -0,0 +1,264 @@
+package kv4pht
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidDTMF = fmt.Errorf("Invalid DTMF digit")
+	ErrNoTxAudio   = fmt.Errorf("No audio written in the current transmission")
+)
+
+const (
+	DTMF_DIGITS = "123A456B789C*0#D" // by row and column
+
+	DTMFDuration  = 100 * time.Millisecond // default duration of the generated digits
+	DTMFGap       = 100 * time.Millisecond // default silence between the generated digits
+	DTMFAmplitude = 0.4                    // of each of the two tones (0-1)
+)
+
+var (
+	DTMF_ROWS    = []float64{697, 770, 852, 941}     // Hz
+	DTMF_COLUMNS = []float64{1209, 1336, 1477, 1633} // Hz
+)
+
+// DTMFTones returns the audio (16-bit mono at sampleRate) for the digits, each lasting duration
+// and followed by gap of silence. Zero durations select DTMFDuration and DTMFGap.
+func DTMFTones(sampleRate int, digits string, duration, gap time.Duration) ([]int16, error) {
+	if duration == 0 {
+		duration = DTMFDuration
+	}
+	if gap == 0 {
+		gap = DTMFGap
+	}
+
+	toneSamples := int(duration.Seconds() * float64(sampleRate))
+	gapSamples := int(gap.Seconds() * float64(sampleRate))
+
+	var out []int16
+	for _, d := range strings.ToUpper(digits) {
+		i := strings.IndexRune(DTMF_DIGITS, d)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDTMF, d)
+		}
+
+		row, col := DTMF_ROWS[i/4], DTMF_COLUMNS[i%4]
+		for n := range toneSamples {
+			t := float64(n) / float64(sampleRate)
+			s := DTMFAmplitude * (math.Sin(2*math.Pi*row*t) + math.Sin(2*math.Pi*col*t))
+			out = append(out, int16(s*math.MaxInt16))
+		}
+		out = append(out, make([]int16, gapSamples)...)
+	}
+
+	return out, nil
+}
+
+// SendDTMF keys the transmitter, sends the digits (with the default duration and gap) and unkeys the transmitter.
+// It returns ErrAlreadyTransmitting if the transmitter is already keyed: use InjectDTMF to send the digits
+// in a transmission where audio is being written, or write the tones returned by DTMFTones.
+func (p *CommandProcessor) SendDTMF(ctx context.Context, digits string) error {
+	samples, err := DTMFTones(AUDIO_SAMPLING_RATE, digits, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sending DTMF %s", digits)
+	return p.Transmit(ctx, samples)
+}
+
+// InjectDTMF sends the digits (with the default duration and gap) in the current transmission:
+// the tones replace the audio written with WriteAudio, until they are over.
+//
+// The tones only go out while the caller keeps writing audio. It returns ErrNotTransmitting
+// if the transmitter is not keyed, and ErrNoTxAudio if no audio was written since it was keyed
+// (i.e. with manual keying): write the tones returned by DTMFTones with WriteAudio instead.
+func (p *CommandProcessor) InjectDTMF(digits string) error {
+	samples, err := DTMFTones(AUDIO_SAMPLING_RATE, digits, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+
+	if !p.ptt {
+		return ErrNotTransmitting
+	}
+	if !p.txWritten {
+		return ErrNoTxAudio
+	}
+
+	log.Printf("Injecting DTMF %s", digits)
+	p.txInject = append(p.txInject, samples...)
+	return nil
+}
+
+// inject replaces the beginning of samples with the pending injected audio. Must be called with txMu held.
+func (p *CommandProcessor) inject(samples []int16) []int16 {
+	if len(p.txInject) == 0 {
+		return samples
+	}
+
+	n := min(len(samples), len(p.txInject))
+
+	out := make([]int16, len(samples))
+	copy(out, p.txInject[:n])
+	copy(out[n:], samples[n:])
+	p.txInject = p.txInject[n:]
+	return out
+}
+
+// DTMF detection
+const (
+	dtmfBlock    = 25 * time.Millisecond
+	dtmfMinBlock = 2    // consecutive blocks with the same digit (digits shorter than ~50ms are ignored)
+	dtmfLevel    = 0.01 // minimum RMS level
+	dtmfRatio    = 0.6  // minimum fraction of the energy in the two tones
+	dtmfMargin   = 4.0  // the tones must be stronger than the others of their group by this factor
+	dtmfTwist    = 8.0  // maximum ratio between the power of the two tones
+)
+
+// DTMFDetector detects the DTMF digits in the received audio, with the Goertzel algorithm
+type DTMFDetector struct {
+	mu sync.Mutex
+
+	blockSize int
+	coeffs    [8]float64 // rows, then columns
+	s1, s2    [8]float64
+	energy    float64
+	pos       int
+
+	digit  byte // digit of the current blocks (0 for none)
+	blocks int  // consecutive blocks with digit
+	misses int
+	quiet  int // blocks since the end of the last digit
+
+	onDigit func(DTMFEvent)
+}
+
+// NewDTMFDetector returns a DTMFDetector for audio at sampleRate, that calls onDigit at the end of each digit.
+// onDigit is called from WriteAudio, so it should not block.
+func NewDTMFDetector(sampleRate int, onDigit func(DTMFEvent)) *DTMFDetector {
+	d := &DTMFDetector{
+		blockSize: int(dtmfBlock.Seconds() * float64(sampleRate)),
+		onDigit:   onDigit,
+	}
+
+	for i, f := range append(append([]float64{}, DTMF_ROWS...), DTMF_COLUMNS...) {
+		d.coeffs[i] = 2 * math.Cos(2*math.Pi*f/float64(sampleRate))
+	}
+	return d
+}
+
+// WriteAudio detects the digits in the samples. It implements AudioSink.
+func (d *DTMFDetector) WriteAudio(samples []int16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range samples {
+		x := float64(s) / math.MaxInt16
+		for i, c := range d.coeffs {
+			s0 := x + c*d.s1[i] - d.s2[i]
+			d.s2[i], d.s1[i] = d.s1[i], s0
+		}
+		d.energy += x * x
+
+		if d.pos++; d.pos == d.blockSize {
+			d.block(d.detect())
+			d.s1, d.s2, d.energy, d.pos = [8]float64{}, [8]float64{}, 0, 0
+		}
+	}
+
+	return nil
+}
+
+// detect returns the digit in the current block, or 0
+func (d *DTMFDetector) detect() byte {
+	n := float64(d.blockSize)
+	if d.energy/n < dtmfLevel*dtmfLevel {
+		return 0
+	}
+
+	var power [8]float64
+	for i, c := range d.coeffs {
+		power[i] = d.s1[i]*d.s1[i] + d.s2[i]*d.s2[i] - c*d.s1[i]*d.s2[i]
+	}
+
+	strongest := func(p []float64) int {
+		best := 0
+		for i := range p {
+			if p[i] > p[best] {
+				best = i
+			}
+		}
+		for i := range p {
+			if i != best && p[i]*dtmfMargin > p[best] {
+				return -1
+			}
+		}
+		return best
+	}
+
+	row, col := strongest(power[:4]), strongest(power[4:])
+	if row < 0 || col < 0 {
+		return 0
+	}
+
+	pr, pc := power[row], power[4+col]
+	if pr > pc*dtmfTwist || pc > pr*dtmfTwist || 2*(pr+pc)/(n*d.energy) < dtmfRatio {
+		return 0
+	}
+
+	return DTMF_DIGITS[row*4+col]
+}
+
+// block updates the state with the digit detected in the last block
+func (d *DTMFDetector) block(digit byte) {
+	switch {
+	case digit != 0 && digit == d.digit:
+		d.blocks++
+		d.misses = 0
+
+	case digit == 0 && d.digit != 0 && d.misses == 0:
+		// tolerate a single bad block
+		d.misses++
+
+	default:
+		d.end()
+		d.digit, d.blocks, d.misses = digit, 0, 0
+		if digit != 0 {
+			d.blocks = 1
+		}
+	}
+
+	if d.digit == 0 {
+		d.quiet++
+	}
+}
+
+// end reports the current digit, if it lasted long enough
+func (d *DTMFDetector) end() {
+	if d.digit == 0 || d.blocks < dtmfMinBlock {
+		return
+	}
+
+	d.onDigit(DTMFEvent{
+		Digit:    d.digit,
+		Duration: time.Duration(d.blocks) * dtmfBlock,
+		Gap:      time.Duration(d.quiet) * dtmfBlock,
+	})
+	d.quiet = 0
+}
+
+// DetectDTMF adds a DTMFDetector to the received audio, that sends a DTMFEvent to the subscribers for each digit.
+// It returns a function to remove it.
+func (p *CommandProcessor) DetectDTMF() (remove func()) {
+	return p.AddSink(NewDTMFDetector(AUDIO_SAMPLING_RATE, func(ev DTMFEvent) { p.emit(ev) }))
+}
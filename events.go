@@ -0,0 +1,240 @@
+package kv4pht
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event is a notification received from the device, or generated by the library (i.e. ScanHitEvent, or the events passed to Emit).
+// Use a type switch to get to the specific event.
+type Event interface {
+	Code() byte // response code (RES_*), 0 for events not received from the device
+}
+
+// DebugEvent is a log message from the firmware
+type DebugEvent struct {
+	Level   byte // RES_DEBUG_INFO, RES_DEBUG_ERROR, RES_DEBUG_WARNING, RES_DEBUG_DEBUG or RES_DEBUG_TRACE
+	Message string
+}
+
+func (e DebugEvent) Code() byte { return e.Level }
+
+// PhysPTTEvent is sent when the physical PTT button is pressed or released
+type PhysPTTEvent struct {
+	Down bool
+}
+
+func (e PhysPTTEvent) Code() byte {
+	if e.Down {
+		return RES_PHYS_PTT_DOWN
+	}
+	return RES_PHYS_PTT_UP
+}
+
+// HelloEvent is sent when the device (re)starts
+type HelloEvent struct{}
+
+func (e HelloEvent) Code() byte { return RES_HELLO }
+
+// VersionEvent is sent in response to CMD_CONFIG
+type VersionEvent struct {
+	Version     uint16
+	RadioStatus byte // 'f' radio module found, 'x' not found
+	HwVersion   byte
+	WindowSize  int
+}
+
+func (e VersionEvent) Code() byte { return RES_VERSION }
+
+// WindowUpdateEvent is sent when the device returns window credits
+type WindowUpdateEvent struct {
+	Size int
+}
+
+func (e WindowUpdateEvent) Code() byte { return RES_WINDOW_UPDATE }
+
+// SMeterEvent reports the received signal strength
+type SMeterEvent struct {
+	Value int // S-unit (1-9)
+	Raw   int // value reported by the radio module (0-255)
+}
+
+func (e SMeterEvent) Code() byte { return RES_SMETER_REPORT }
+
+// RxAudioEvent contains a received audio frame
+type RxAudioEvent struct {
+	Samples []int16 // decoded 16-bit mono samples at AUDIO_SAMPLING_RATE
+	Packet  []byte  // Opus packet as received from the device
+}
+
+func (e RxAudioEvent) Code() byte { return RES_RX_AUDIO }
+
+// ScanResultEvent is sent in response to CMD_SCAN
+type ScanResultEvent struct {
+	Freq   float64 // MHz
+	Result byte    // SCAN_NOT_FOUND or SCAN_FOUND
+}
+
+func (e ScanResultEvent) Code() byte { return RES_SCAN_RESULT }
+
+// Found returns true if a carrier was detected
+func (e ScanResultEvent) Found() bool { return e.Result == SCAN_FOUND }
+
+// ScanHitEvent is sent by a Scanner when it finds an active channel
+type ScanHitEvent struct {
+	Channel  ScanChannel
+	SMeter   int  // S-unit (1-9), 0 with CarrierDetect
+	Priority bool // the hit is on the priority channel
+}
+
+func (e ScanHitEvent) Code() byte { return 0 }
+
+// DTMFEvent is sent at the end of each DTMF digit received, when enabled with DetectDTMF
+type DTMFEvent struct {
+	Digit    byte          // one of DTMF_DIGITS
+	Duration time.Duration // how long the digit was received
+	Gap      time.Duration // silence before the digit, since the previous one
+}
+
+func (e DTMFEvent) Code() byte { return 0 }
+
+// ToneEvent is sent when the CTCSS tone or DCS code detected in the received audio changes, when enabled with DetectTones.
+// The zero Tone means that the tone is gone.
+type ToneEvent struct {
+	Tone Tone
+}
+
+func (e ToneEvent) Code() byte { return 0 }
+
+// ConnectionState is the state of the connection to the device, reported with ConnectionEvent
+type ConnectionState int
+
+const (
+	ConnectionLost     ConnectionState = iota // the transport was closed or failed
+	ConnectionRetrying                        // trying to reopen the device
+	ConnectionRestored                        // reconnected, the handshake is done and the settings restored
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionLost:
+		return "lost"
+	case ConnectionRetrying:
+		return "retrying"
+	case ConnectionRestored:
+		return "restored"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// ConnectionEvent is sent when the connection state changes, with ConnectOptions.Reconnect
+type ConnectionEvent struct {
+	State   ConnectionState
+	Err     error // why the connection was lost, or why the previous attempt failed
+	Attempt int   // reconnection attempt, starting from 1
+}
+
+func (e ConnectionEvent) Code() byte { return 0 }
+
+// UnknownEvent is a response with an unknown code
+type UnknownEvent struct {
+	Cmd    byte
+	Params []byte
+}
+
+func (e UnknownEvent) Code() byte { return e.Cmd }
+
+type subscribers struct {
+	sync.Mutex
+
+	list    []chan Event
+	events  chan Event // the channel returned by Events()
+	dropped int
+	closed  bool
+}
+
+// Subscribe returns a channel that receives all the events from the device, and a function to cancel the subscription.
+//
+// Events are delivered in order, but they are dropped if the channel buffer (of the specified size) is full,
+// so the receiver shouldn't block for long.
+// The channel is closed when the subscription is canceled or the CommandProcessor stops (see Done).
+func (p *CommandProcessor) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+
+	p.subs.Lock()
+	if p.subs.closed {
+		close(ch)
+	} else {
+		p.subs.list = append(p.subs.list, ch)
+	}
+	p.subs.Unlock()
+
+	return ch, func() { p.unsubscribe(ch) }
+}
+
+// Events returns a shared subscription to the device events (see Subscribe)
+func (p *CommandProcessor) Events() <-chan Event {
+	p.subs.Lock()
+	if p.subs.events == nil {
+		p.subs.events = make(chan Event, 64)
+		if p.subs.closed {
+			close(p.subs.events)
+		} else {
+			p.subs.list = append(p.subs.list, p.subs.events)
+		}
+	}
+	ch := p.subs.events
+	p.subs.Unlock()
+
+	return ch
+}
+
+func (p *CommandProcessor) unsubscribe(ch chan Event) {
+	p.subs.Lock()
+	defer p.subs.Unlock()
+
+	for i, c := range p.subs.list {
+		if c == ch {
+			p.subs.list = append(p.subs.list[:i], p.subs.list[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// closeSubscribers closes all the subscribed channels
+func (p *CommandProcessor) closeSubscribers() {
+	p.subs.Lock()
+	defer p.subs.Unlock()
+
+	for _, ch := range p.subs.list {
+		close(ch)
+	}
+
+	p.subs.list = nil
+	p.subs.closed = true
+}
+
+// Emit sends an event to the subscribers, i.e. for the events generated by other packages (see package same)
+func (p *CommandProcessor) Emit(ev Event) {
+	p.emit(ev)
+}
+
+// emit sends the event to all subscribers, without blocking
+func (p *CommandProcessor) emit(ev Event) {
+	p.subs.Lock()
+	defer p.subs.Unlock()
+
+	for _, ch := range p.subs.list {
+		select {
+		case ch <- ev:
+		default:
+			p.subs.dropped++
+			if Debug {
+				log.Printf("Event %02x dropped (%d)", ev.Code(), p.subs.dropped)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package kv4pht
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/raff/kv4p-go/bandplan"
+)
+
+var (
+	ErrFrequencyRange = fmt.Errorf("Frequency out of range")
+	ErrInvalidShift   = fmt.Errorf("Invalid repeater shift")
+)
+
+// FrequencyRange returns the frequency limits (in MHz) of the band of module selected with mode (MODE_VHF or MODE_UHF)
+func FrequencyRange(module bandplan.Module, mode int) (float64, float64) {
+	if mode == MODE_UHF {
+		return module.UHF.Min, module.UHF.Max
+	}
+
+	return module.VHF.Min, module.VHF.Max
+}
+
+// CheckFrequency returns ErrFrequencyRange if freq (in MHz) is outside of the band of module selected with mode
+func CheckFrequency(module bandplan.Module, mode int, freq float64) error {
+	if min, max := FrequencyRange(module, mode); freq < min || freq > max {
+		return fmt.Errorf("%w: %.4f MHz not in %.0f-%.0f MHz (%s)", ErrFrequencyRange, freq, min, max, module.Name)
+	}
+
+	return nil
+}
+
+// Range is a frequency range, in MHz (limits included)
+type Range = bandplan.Range
+
+// DefaultOffset returns the standard repeater offset (in MHz) for the band freq belongs to:
+// 600kHz on 2m and 5MHz on 70cm.
+func DefaultOffset(freq float64) float64 {
+	if freq >= UHF_MIN_FREQ {
+		return 5.0
+	}
+
+	return 0.6
+}
+
+// RepeaterOffset returns the repeater offset (in MHz, negative for a minus shift)
+// for a repeater output (receive) frequency, according to the US band plan (see bandplan.US2m and bandplan.US70cm).
+// It returns 0 for frequencies that are not in a repeater output sub-band.
+func RepeaterOffset(freq float64) float64 {
+	if sb, ok := bandplan.USSubBand(freq); ok {
+		return sb.Offset
+	}
+
+	return 0
+}
+
+// RepeaterTxFrequency returns the transmit frequency for the receive frequency rxfreq (in MHz) and a repeater shift:
+//
+//	"" or "simplex" - same as rxfreq
+//	"+" or "-"      - rxfreq plus or minus offset
+//	"auto"          - the shift for rxfreq in the band plan, with offset as the amount if not 0
+//
+// An offset of 0 selects the standard offset for the band (see DefaultOffset).
+func RepeaterTxFrequency(rxfreq float64, shift string, offset float64) (float64, error) {
+	offset = math.Abs(offset)
+	if offset == 0 {
+		offset = DefaultOffset(rxfreq)
+	}
+
+	switch shift {
+	case "", "simplex":
+		return rxfreq, nil
+	case "+":
+		return rxfreq + offset, nil
+	case "-":
+		return rxfreq - offset, nil
+	case "auto":
+		auto := RepeaterOffset(rxfreq)
+		if auto == 0 {
+			return rxfreq, nil
+		}
+		return rxfreq + math.Copysign(offset, auto), nil
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalidShift, shift)
+}
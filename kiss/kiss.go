@@ -0,0 +1,122 @@
+// Package kiss implements the KISS TNC protocol, and a server that exposes a TNC over TCP
+// (i.e. for APRS clients and the Linux AX.25 tools, with kissattach/socat).
+package kiss
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+var ErrFrameTooLong = fmt.Errorf("KISS frame too long")
+
+// special characters
+const (
+	FEND  = 0xC0
+	FESC  = 0xDB
+	TFEND = 0xDC
+	TFESC = 0xDD
+)
+
+// commands, in the low nibble of the type byte (the high nibble is the port)
+const (
+	CMD_DATA        = 0x00
+	CMD_TXDELAY     = 0x01 // in 10ms units
+	CMD_PERSIST     = 0x02 // p-persistence (0-255)
+	CMD_SLOTTIME    = 0x03 // in 10ms units
+	CMD_TXTAIL      = 0x04 // in 10ms units
+	CMD_FULLDUPLEX  = 0x05
+	CMD_SETHARDWARE = 0x06
+	CMD_RETURN      = 0xFF
+)
+
+// maxFrame is the largest frame accepted by the Decoder
+const maxFrame = 4096
+
+// Frame is a KISS frame
+type Frame struct {
+	Port    int
+	Command byte
+	Data    []byte
+}
+
+// Encode returns the KISS encoding of the frame
+func (f Frame) Encode() []byte {
+	b := make([]byte, 0, len(f.Data)+4)
+	b = append(b, FEND)
+
+	typ := byte(f.Port<<4) | f.Command&0x0F
+	if f.Command == CMD_RETURN {
+		typ = CMD_RETURN
+	}
+
+	for _, c := range append([]byte{typ}, f.Data...) {
+		switch c {
+		case FEND:
+			b = append(b, FESC, TFEND)
+		case FESC:
+			b = append(b, FESC, TFESC)
+		default:
+			b = append(b, c)
+		}
+	}
+
+	return append(b, FEND)
+}
+
+// Decoder reads KISS frames
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next frame. Empty frames are skipped.
+func (d *Decoder) Next() (Frame, error) {
+	var b []byte
+	escaped := false
+	inFrame := false
+
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return Frame{}, err
+		}
+
+		switch {
+		case c == FEND:
+			if len(b) > 0 {
+				f := Frame{Port: int(b[0] >> 4), Command: b[0] & 0x0F, Data: b[1:]}
+				if b[0] == CMD_RETURN {
+					f.Port, f.Command = 0, CMD_RETURN
+				}
+				return f, nil
+			}
+			inFrame, escaped = true, false
+
+		case !inFrame:
+			// data before the first FEND is ignored
+
+		case c == FESC:
+			escaped = true
+
+		default:
+			if escaped {
+				switch c {
+				case TFEND:
+					c = FEND
+				case TFESC:
+					c = FESC
+				}
+				escaped = false
+			}
+
+			if len(b) == maxFrame {
+				return Frame{}, ErrFrameTooLong
+			}
+			b = append(b, c)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package kiss
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	frames := []Frame{
+		{Command: CMD_DATA, Data: []byte("hello")},
+		{Command: CMD_DATA, Data: []byte{FEND, FESC, TFEND, TFESC, 0}},
+		{Port: 1, Command: CMD_TXDELAY, Data: []byte{50}},
+		{Command: CMD_RETURN},
+	}
+
+	if b := frames[1].Encode(); !bytes.Equal(b, []byte{FEND, 0, FESC, TFEND, FESC, TFESC, TFEND, TFESC, 0, FEND}) {
+		t.Errorf("unexpected encoding % x", b)
+	}
+
+	var stream []byte
+	stream = append(stream, "noise"...)
+	for _, f := range frames {
+		stream = append(stream, FEND) // empty frames are skipped
+		stream = append(stream, f.Encode()...)
+	}
+
+	dec := NewDecoder(bytes.NewReader(stream))
+	for i, expected := range frames {
+		f, err := dec.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Port != expected.Port || f.Command != expected.Command || !bytes.Equal(f.Data, expected.Data) {
+			t.Errorf("frame %d: got %+v, expected %+v", i, f, expected)
+		}
+	}
+
+	if _, err := dec.Next(); err == nil {
+		t.Error("expected EOF")
+	}
+}
+
+type testTNC struct {
+	sent   chan []byte
+	params map[byte]byte
+}
+
+func (t *testTNC) Send(frame []byte) error {
+	t.sent <- frame
+	return nil
+}
+
+func (t *testTNC) SetParameter(cmd, value byte) {
+	t.params[cmd] = value
+}
+
+func TestServer(t *testing.T) {
+	tnc := &testTNC{sent: make(chan []byte, 1), params: map[byte]byte{}}
+	s := NewServer(tnc)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	client.Write(Frame{Command: CMD_TXDELAY, Data: []byte{30}}.Encode())
+	client.Write(Frame{Command: CMD_DATA, Data: []byte("frame")}.Encode())
+
+	select {
+	case f := <-tnc.sent:
+		if string(f) != "frame" {
+			t.Errorf("unexpected frame %q", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame not sent")
+	}
+	if tnc.params[CMD_TXDELAY] != 30 {
+		t.Errorf("unexpected parameters %v", tnc.params)
+	}
+
+	s.Broadcast([]byte{FEND, 1})
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	f, err := NewDecoder(client).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Command != CMD_DATA || !bytes.Equal(f.Data, []byte{FEND, 1}) {
+		t.Errorf("unexpected frame %+v", f)
+	}
+}
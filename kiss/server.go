@@ -0,0 +1,131 @@
+package kiss
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// DefaultAddress is the address the KISS server listens on by default (the same as Dire Wolf)
+const DefaultAddress = "localhost:8001"
+
+// TNC transmits the frames received from the clients
+type TNC interface {
+	Send(frame []byte) error
+}
+
+// ParameterSetter is implemented by TNCs that support the KISS parameters (CMD_TXDELAY, CMD_PERSIST, ...)
+type ParameterSetter interface {
+	SetParameter(cmd byte, value byte)
+}
+
+// Server exposes a TNC to KISS clients over TCP.
+// Frames sent by the clients are passed to the TNC, received frames should be passed to Broadcast.
+type Server struct {
+	TNC TNC
+
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+type client struct {
+	out chan []byte
+}
+
+// NewServer returns a server for the TNC
+func NewServer(tnc TNC) *Server {
+	return &Server{TNC: tnc, clients: map[*client]bool{}}
+}
+
+// Serve accepts connections from the listener and serves them, until the listener is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		log.Println("KISS connection from", conn.RemoteAddr())
+		go func() {
+			if err := s.ServeConn(conn); err != nil && err != io.EOF {
+				log.Println("KISS:", err)
+			}
+			log.Println("KISS connection closed", conn.RemoteAddr())
+		}()
+	}
+}
+
+// ServeConn serves a client connection, until it's closed
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	c := &client{out: make(chan []byte, 64)}
+
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for b := range c.out {
+			if _, err := conn.Write(b); err != nil {
+				conn.Close()
+				for range c.out { // discard until removed
+				}
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		close(c.out)
+		s.mu.Unlock()
+
+		conn.Close()
+		<-done
+	}()
+
+	dec := NewDecoder(conn)
+	for {
+		f, err := dec.Next()
+		if err == ErrFrameTooLong {
+			log.Println("KISS:", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case f.Port != 0:
+			// only one port
+		case f.Command == CMD_DATA:
+			if err := s.TNC.Send(f.Data); err != nil {
+				log.Println("KISS: send:", err)
+			}
+		case f.Command == CMD_RETURN:
+		default:
+			if ps, ok := s.TNC.(ParameterSetter); ok && len(f.Data) > 0 {
+				ps.SetParameter(f.Command, f.Data[0])
+			}
+		}
+	}
+}
+
+// Broadcast sends a received frame to all the clients. It doesn't block:
+// the frame is dropped for clients that are not reading fast enough.
+func (s *Server) Broadcast(frame []byte) {
+	b := Frame{Command: CMD_DATA, Data: frame}.Encode()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		select {
+		case c.out <- b:
+		default:
+		}
+	}
+}
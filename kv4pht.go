@@ -1,31 +1,34 @@
 package kv4pht
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/ebitengine/oto/v3"
-	"go.bug.st/serial"
-	"go.bug.st/serial/enumerator"
+	"github.com/raff/kv4p-go/bandplan"
 	"gopkg.in/hraban/opus.v2"
 )
 
 var (
-	// Must match the ESP32 device we support.
-	// Idx 0 matches https://www.amazon.com/gp/product/B08D5ZD528
-	esp32_vendor_ids  = []string{"10C4", "1A86"}
-	esp32_product_ids = []string{"EA60", "7523"}
-
 	cmd_prefix = []byte{0xDE, 0xAD, 0xBE, 0xEF}
 	Debug      = false
 
-	ErrNoDevice = fmt.Errorf("No device found")
+	ErrNoDevice            = fmt.Errorf("No device found")
+	ErrNotTransmitting     = fmt.Errorf("PTT is not down")
+	ErrAlreadyTransmitting = fmt.Errorf("PTT is already down")
+	ErrWindowTimeout       = fmt.Errorf("Timeout waiting for window update")
+	ErrTxTimeout           = fmt.Errorf("Maximum transmit time exceeded")
+	ErrTxNotAllowed        = fmt.Errorf("Transmit frequency not allowed")
+	ErrStopped             = fmt.Errorf("Command processor stopped")
+	ErrTransportClosed     = fmt.Errorf("Transport closed")
 )
 
 const (
@@ -39,6 +42,11 @@ const (
 	CMD_TX_AUDIO      = 0x07
 	CMD_WINDOW_UPDATE = 0x08
 
+	// Experimental: the scan command and its result are not part of a released firmware protocol.
+	// The codes are the next free ones after CMD_WINDOW_UPDATE and RES_WINDOW_UPDATE,
+	// and are only implemented by the simulator package.
+	CMD_SCAN = 0x09
+
 	// Response codes
 	RES_SMETER_REPORT = 0x53
 	RES_PHYS_PTT_DOWN = 0x44
@@ -52,6 +60,12 @@ const (
 	RES_RX_AUDIO      = 0x07
 	RES_VERSION       = 0x08
 	RES_WINDOW_UPDATE = 0x09
+	RES_SCAN_RESULT   = 0x0A // experimental, see CMD_SCAN
+
+	// result of CMD_SCAN (see Scanned), experimental
+	SCAN_WAITING   = 0x00 // no response yet
+	SCAN_NOT_FOUND = 0x01 // no carrier on the frequency
+	SCAN_FOUND     = 0x02 // carrier detected
 
 	MODE_VHF = 0x04
 	MODE_UHF = 0x05
@@ -68,8 +82,11 @@ const (
 	UHF_MIN_FREQ = 400.0 // SA818U lower limit, in MHz
 	UHF_MAX_FREQ = 480.0 // SA818U upper limit, in MHz (DRA818U can only go to 470MHz)
 
+	INITIAL_WINDOW = 1024 // until the device reports the size of its receive buffer with RES_VERSION
+
 	AUDIO_SAMPLING_RATE = 48000 // 48kHz
 	OPUS_FRAME_SIZE     = 1920  // 40ms at 48kHz
+	OPUS_MAX_PACKET     = 4000  // recommended max size of an encoded packet
 )
 
 type Group struct {
@@ -82,56 +99,207 @@ type Group struct {
 }
 
 type CommandProcessor struct {
+	// parser state, only accessed by the reader goroutine
 	state  int
 	cmd    byte
 	plen   int
 	params []byte
 
+	rxBytes      int // received bytes not yet acknowledged with CMD_WINDOW_UPDATE
+	audioDecoder *opus.Decoder
+
+	mu sync.Mutex // protects the device state below
+
 	version     uint16
 	radioStatus byte
 	hwver       byte
-	windowSize  int
 
 	smeter int
 	scount int
 
+	scanFreq float32 // frequency of the last CMD_SCAN
+	scanned  byte    // SCAN_WAITING, SCAN_NOT_FOUND or SCAN_FOUND
+
 	hello bool
 	quit  bool
+	err   error // why the reader goroutine exited
 
-	port serial.Port
+	group    GroupOptions // last GROUP sent
+	hasGroup bool
 
-	audioDecoder *opus.Decoder
-	audioBuffer  []int16
-	player       *oto.Player
+	// restored after a reconnection
+	mode       int  // last CONFIG sent
+	filters    byte // last FILTERS sent
+	hasFilters bool
+
+	connect *ConnectOptions // set by Connect, to reconnect
+
+	sinks []*sinkEntry
+
+	window *window // flow control for commands sent to the device
 
+	transport Transport  // protected by mu, replaced when reconnecting
+	writeMu   sync.Mutex // serializes writes to the transport
+
+	txMu         sync.Mutex // protects the transmit state below
+	audioEncoder *opus.Encoder
+	txBuffer     []int16
+	txInject     []int16 // audio that replaces the written one (see InjectDTMF)
+	txWritten    bool    // audio was written in the current transmission
+	ptt          bool
+	txErr        error                   // why the transmitter was forced off
+	txCtx        context.Context         // done when the transmitter is unkeyed
+	txStop       func() bool             // stops the watchdog
+	txCancel     context.CancelCauseFunc // cancels txCtx, protected by mu so that it can be called while txMu is held
+
+	subs       subscribers
+	done       chan struct{}   // closed when the reader goroutine exits (see Done)
+	stopCtx    context.Context // done on Stop
+	stopCancel context.CancelFunc
+
+	// AudioCallback and SMeterCallback are called from the reader goroutine and should be set right after Start.
+	// See Subscribe and Events for a way to receive all the device notifications.
 	AudioCallback  func([]int16)
 	SMeterCallback func(int)
+
+	// WriteTimeout is the maximum time a command waits for the device to return window credits.
+	// Zero means wait forever.
+	WriteTimeout time.Duration
+
+	// MaxTxTime is the maximum key-down time: the transmitter is forced off after that (default 3 minutes).
+	// Zero means no limit.
+	MaxTxTime time.Duration
+
+	// TxRanges, if not empty, are the only frequency ranges where transmitting is allowed
+	TxRanges []Range
+
+	// BandPlan validates the frequencies sent with SendGroup, and the transmit frequency on PTT down
+	// (default: bandplan.Default, that only checks the limits of the radio module)
+	BandPlan bandplan.Plan
 }
 
 func (p *CommandProcessor) Hello() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.hello
 }
 
 func (p *CommandProcessor) Version() (uint16, byte, byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.version, p.radioStatus, p.hwver
 }
 
 func (p *CommandProcessor) SMeter() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.smeter, p.scount
 }
 
+// Transmitting returns true between SendPTTDown and SendPTTUp
+func (p *CommandProcessor) Transmitting() bool {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+	return p.ptt
+}
+
+// Done returns a channel that is closed when the CommandProcessor stops: after Stop, or when the connection
+// to the device is lost (unless it was connected with ConnectOptions.Reconnect).
+// The event subscriptions are closed at the same time.
+func (p *CommandProcessor) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns nil until Done is closed, then why the CommandProcessor stopped:
+// ErrStopped after Stop, or ErrTransportClosed (wrapping the transport error, if any) when the connection was lost.
+func (p *CommandProcessor) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// conn returns the current transport
+func (p *CommandProcessor) conn() Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transport
+}
+
+func (p *CommandProcessor) stopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.quit
+}
+
+// readLoop reads from the transport and parses the incoming frames, in order.
+// It's the only goroutine that accesses the parser state.
+func (p *CommandProcessor) readLoop() {
+	err := p.read()
+	for !errors.Is(err, ErrStopped) {
+		log.Println(err)
+
+		// the device can't be reached anymore: don't leave the transmitter on
+		p.forcePTTUp(nil, err)
+
+		if p.connect == nil || !p.connect.Reconnect {
+			break
+		}
+		err = p.reconnect(err)
+	}
+
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+
+	close(p.done)
+	p.closeSubscribers()
+}
+
+// read reads from the current transport until it's closed or fails, and returns why
+func (p *CommandProcessor) read() error {
+	t := p.conn()
+	p.state, p.cmd, p.plen, p.params, p.rxBytes = 0, 0, 0, nil, 0
+
+	buf := make([]byte, 1024)
+
+	for {
+		n, err := t.Read(buf)
+		if p.stopped() {
+			return ErrStopped
+		}
+		if n > 0 {
+			p.processBytes(buf[:n])
+		}
+		if err == io.EOF {
+			return ErrTransportClosed
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrTransportClosed, err)
+		}
+	}
+}
+
 func (p *CommandProcessor) processBytes(buf []byte) {
 	for _, b := range buf {
 		switch {
 		case p.state < len(cmd_prefix):
-			if b != cmd_prefix[p.state] {
-				p.params = append(p.params, b)
-			} else {
-				if len(p.params) > 0 {
+			if b == cmd_prefix[p.state] {
+				p.state++
+
+				if p.state == len(cmd_prefix) && len(p.params) > 0 {
 					log.Print("Skipped bytes:\n", hex.Dump(p.params))
 					p.params = nil
 				}
-				p.state++
+			} else {
+				// a partial prefix is not a prefix
+				p.params = append(p.params, cmd_prefix[:p.state]...)
+				p.state = 0
+
+				if b == cmd_prefix[0] {
+					p.state++
+				} else {
+					p.params = append(p.params, b)
+				}
 			}
 
 		case p.state == len(cmd_prefix):
@@ -178,53 +346,95 @@ func (p *CommandProcessor) processCommand() {
 	switch p.cmd {
 	case RES_DEBUG_INFO:
 		log.Printf("INFO: %s", string(p.params))
+		p.emit(DebugEvent{Level: p.cmd, Message: string(p.params)})
 	case RES_DEBUG_ERROR:
 		log.Printf("ERROR: %s", string(p.params))
+		p.emit(DebugEvent{Level: p.cmd, Message: string(p.params)})
 	case RES_DEBUG_WARNING:
 		log.Printf("WARNING: %s", string(p.params))
+		p.emit(DebugEvent{Level: p.cmd, Message: string(p.params)})
 	case RES_DEBUG_DEBUG:
 		log.Printf("DEBUG: %s", string(p.params))
+		p.emit(DebugEvent{Level: p.cmd, Message: string(p.params)})
 	case RES_DEBUG_TRACE:
 		log.Printf("TRACE %s", string(p.params))
+		p.emit(DebugEvent{Level: p.cmd, Message: string(p.params)})
 	case RES_PHYS_PTT_DOWN:
 		log.Println("PTT BUTTON DOWN")
+		p.emit(PhysPTTEvent{Down: true})
 	case RES_PHYS_PTT_UP:
 		log.Println("PTT BUTTON UP")
+		p.emit(PhysPTTEvent{Down: false})
 	case RES_HELLO:
 		log.Printf("HELLO\n")
+		p.mu.Lock()
 		p.hello = true
+		p.mu.Unlock()
+		p.emit(HelloEvent{})
 	case RES_VERSION:
 		if p.plen != 8 {
 			log.Printf("Invalid version length: %d (%02x)\n", p.plen, p.params)
 			break
 		}
-		p.version = binary.LittleEndian.Uint16(p.params[0:2])
-		p.radioStatus = p.params[2]
-		p.hwver = p.params[3]
-		p.windowSize = int(binary.LittleEndian.Uint32(p.params[4:8]))
-		log.Printf("Version: %d, rstatus: %c, hwver: %02x, windowSize: %d\n", p.version, p.radioStatus, p.hwver, p.windowSize)
+		ev := VersionEvent{
+			Version:     binary.LittleEndian.Uint16(p.params[0:2]),
+			RadioStatus: p.params[2],
+			HwVersion:   p.params[3],
+			WindowSize:  int(binary.LittleEndian.Uint32(p.params[4:8])),
+		}
+		p.mu.Lock()
+		p.version, p.radioStatus, p.hwver = ev.Version, ev.RadioStatus, ev.HwVersion
+		p.mu.Unlock()
+		p.window.set(ev.WindowSize)
+		log.Printf("Version: %d, rstatus: %c, hwver: %02x, windowSize: %d\n", ev.Version, ev.RadioStatus, ev.HwVersion, ev.WindowSize)
+		p.emit(ev)
 	case RES_WINDOW_UPDATE:
 		if p.plen != 4 {
 			log.Printf("Invalid window update length: %d (%02x)\n", p.plen, p.params)
 			break
 		}
 		wsize := binary.LittleEndian.Uint32(p.params[0:4])
-		p.windowSize += int(wsize)
-		log.Printf("Window update: %d\n", p.windowSize)
+		p.window.add(int(wsize))
+		if Debug {
+			credits, _ := p.window.available()
+			log.Printf("Window update: %d (%d)\n", wsize, credits)
+		}
+		p.emit(WindowUpdateEvent{Size: int(wsize)})
 	case RES_SMETER_REPORT:
 		if p.plen != 1 {
 			log.Printf("Invalid S-Meter length: %d (%02x)\n", p.plen, p.params)
 			break
 		}
-		smeter := smeterValue(int(p.params[0]) & 0xFF)
+		raw := int(p.params[0]) & 0xFF
+		smeter := smeterValue(raw)
+		p.mu.Lock()
 		p.scount++
-		if p.smeter != smeter || Debug {
+		changed := p.smeter != smeter
+		p.smeter = smeter
+		p.mu.Unlock()
+		if changed || Debug {
 			log.Printf("S-Meter: %d\n", smeter)
-			p.smeter = smeter
 		}
 		if p.SMeterCallback != nil {
 			p.SMeterCallback(smeter)
 		}
+		p.emit(SMeterEvent{Value: smeter, Raw: raw})
+	case RES_SCAN_RESULT:
+		if p.plen != 5 {
+			log.Printf("Invalid SCAN result length: %d (%02x)\n", p.plen, p.params)
+			break
+		}
+		result := p.params[0]
+		freq := math.Float32frombits(binary.LittleEndian.Uint32(p.params[1:5]))
+		p.mu.Lock()
+		if freq == p.scanFreq { // ignore late results for a previous scan
+			p.scanned = result
+		}
+		p.mu.Unlock()
+		if Debug {
+			log.Printf("SCAN %.4f: %02x\n", freq, result)
+		}
+		p.emit(ScanResultEvent{Freq: float64(freq), Result: result})
 	case RES_RX_AUDIO:
 		if Debug {
 			log.Printf("RX AUDIO (%v bytes):", p.plen)
@@ -243,17 +453,35 @@ func (p *CommandProcessor) processCommand() {
 				log.Printf("Decoded %d samples\n", n)
 			}
 
-			p.audioBuffer = append(p.audioBuffer, out[:n]...)
+			for _, s := range p.audioSinks() {
+				if f, ok := s.AudioSink.(PacketSinkFunc); ok {
+					f(p.params, out[:n])
+					continue
+				}
+				if ps, ok := s.AudioSink.(PacketSink); ok {
+					if err := ps.WritePacket(p.params, n); err != nil {
+						log.Printf("Audio sink: %v", err)
+					}
+				}
+				if err := s.WriteAudio(out[:n]); err != nil {
+					log.Printf("Audio sink: %v", err)
+				}
+			}
 
 			if p.AudioCallback != nil {
 				p.AudioCallback(out[:n])
 			}
+
+			p.emit(RxAudioEvent{Samples: append([]int16(nil), out[:n]...), Packet: p.params})
 		}
 
 	default:
 		log.Printf("Unknown command %02x: %02x\n", p.cmd, p.params)
+		p.emit(UnknownEvent{Cmd: p.cmd, Params: p.params})
 	}
 
+	p.acknowledge(len(cmd_prefix) + 3 + p.plen)
+
 	p.state = 0
 	p.cmd = 0
 	p.plen = 0
@@ -273,117 +501,154 @@ func (p *CommandProcessor) newCommand(cmd byte, params []byte) []byte {
 	if Debug {
 		log.Printf("newCommand %02x: %02x\n", cmd, buffer)
 	}
-	l = len(buffer)
-	if l > p.windowSize {
-		log.Printf("Window size exceeded: %d > %d\n", l, p.windowSize)
-		time.Sleep(1 * time.Second)
-	}
-	p.windowSize -= l
 	return buffer
 }
 
-func Start(portName string) (*CommandProcessor, error) {
-	if portName == "" {
-		ports, err := enumerator.GetDetailedPortsList()
-		if err != nil {
-			return nil, err
-		}
+// sendCommand writes a command to the serial port and waits for it to be transmitted
+func (p *CommandProcessor) sendCommand(cmd byte, params []byte) error {
+	return p.sendCommandContext(context.Background(), cmd, params)
+}
 
-		for _, port := range ports {
-			if port.IsUSB {
-				for i, id := range esp32_vendor_ids {
-					if port.VID == id && port.PID == esp32_product_ids[i] {
-						portName = port.Name
-						if Debug {
-							log.Printf("Found ESP32 device: %s\n", port.Name)
-						}
-						break
-					}
-				}
-			}
+// sendCommandContext waits for enough window credits to send the command (or for the context to be done),
+// then writes it to the serial port and waits for it to be transmitted.
+//
+// CMD_WINDOW_UPDATE is not subject to flow control, since it's what the device needs to make progress.
+func (p *CommandProcessor) sendCommandContext(ctx context.Context, cmd byte, params []byte) error {
+	buffer := p.newCommand(cmd, params)
+
+	if cmd != CMD_WINDOW_UPDATE {
+		if p.WriteTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeoutCause(ctx, p.WriteTimeout, ErrWindowTimeout)
+			defer cancel()
 		}
 
-		if portName == "" {
-			return nil, ErrNoDevice
+		if err := p.window.acquire(ctx, len(buffer)); err != nil {
+			return context.Cause(ctx)
 		}
 	}
 
-	smode := &serial.Mode{
-		BaudRate: 115200,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
-		Parity:   serial.NoParity,
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	t := p.conn()
+	n, err := t.Write(buffer)
+	if err != nil {
+		return err
+	}
+	if n != len(buffer) {
+		return io.ErrShortWrite
+	}
+
+	if d, ok := t.(Drainer); ok {
+		return d.Drain()
 	}
+	return nil
+}
 
-	port, err := serial.Open(portName, smode)
+// acknowledge accounts for n bytes received from the device and returns them as credits
+// with CMD_WINDOW_UPDATE once half of the window has been consumed.
+//
+// The device doesn't report the size of its transmit window, so we assume it matches the receive one.
+func (p *CommandProcessor) acknowledge(n int) {
+	p.rxBytes += n
+
+	if _, size := p.window.available(); p.rxBytes < size/2 {
+		return
+	}
+
+	var params [4]byte
+	binary.LittleEndian.PutUint32(params[:], uint32(p.rxBytes))
+	p.rxBytes = 0
+
+	if err := p.sendCommand(CMD_WINDOW_UPDATE, params[:]); err != nil {
+		log.Printf("Send WINDOW_UPDATE: %v", err)
+	}
+}
+
+// Start opens the named device (see OpenTransport) and starts a CommandProcessor,
+// without waiting for the device to be ready: see Connect for that.
+//
+// The received audio goes to the sinks specified with WithAudioSink (see the otosink package to play it).
+func Start(portName string, opts ...Option) (*CommandProcessor, error) {
+	t, err := OpenTransport(portName)
 	if err != nil {
 		return nil, err
 	}
 
-	p := &CommandProcessor{windowSize: 1024, port: port}
-	p.audioDecoder, err = opus.NewDecoder(AUDIO_SAMPLING_RATE, 1)
+	p, err := NewCommandProcessor(t, opts...)
 	if err != nil {
-		port.Close()
+		t.Close()
 		return nil, err
 	}
 
-	op := &oto.NewContextOptions{
-		SampleRate:   AUDIO_SAMPLING_RATE,
-		ChannelCount: 1,
-		Format:       oto.FormatSignedInt16LE,
-	}
-	c, ready, err := oto.NewContext(op)
+	return p, nil
+}
+
+// NewCommandProcessor returns a CommandProcessor that talks to the device over the specified transport,
+// and starts reading from it.
+//
+// Received audio is delivered to the audio sinks, AudioCallback and the event subscribers.
+func NewCommandProcessor(t Transport, opts ...Option) (*CommandProcessor, error) {
+	p, err := newCommandProcessor(t)
 	if err != nil {
-		port.Close()
 		return nil, err
 	}
-	<-ready
 
-	p.player = c.NewPlayer(p)
+	for _, opt := range opts {
+		opt(p)
+	}
 
-	// Read from the serial port
-	go func() {
-		buf := make([]byte, 1024)
+	// Read from the transport
+	go p.readLoop()
 
-		for {
-			if p.quit {
-				break
-			}
+	return p, nil
+}
 
-			n, err := p.port.Read(buf)
-			if err == io.EOF || p.quit {
-				break
-			}
-			if err != nil {
-				log.Fatal("Error reading from serial port:", err)
-				break
-			}
+func newCommandProcessor(t Transport) (*CommandProcessor, error) {
+	var err error
 
-			go p.processBytes(buf[:n])
-		}
-	}()
+	p := &CommandProcessor{
+		window:       newWindow(INITIAL_WINDOW),
+		transport:    t,
+		done:         make(chan struct{}),
+		WriteTimeout: 5 * time.Second,
+		MaxTxTime:    3 * time.Minute,
+		BandPlan:     bandplan.Default,
+	}
+	p.stopCtx, p.stopCancel = context.WithCancel(context.Background())
+
+	p.audioDecoder, err = opus.NewDecoder(AUDIO_SAMPLING_RATE, 1)
+	if err != nil {
+		return nil, err
+	}
+	p.audioEncoder, err = opus.NewEncoder(AUDIO_SAMPLING_RATE, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
 
 	return p, nil
 }
 
 func (p *CommandProcessor) SendStop() error {
 	log.Println("Sending STOP command")
-	if _, err := p.port.Write(p.newCommand(CMD_STOP, nil)); err != nil {
+	if err := p.sendCommand(CMD_STOP, nil); err != nil {
 		return err
 	}
 
-	p.port.Drain()
 	time.Sleep(1 * time.Second)
 	return nil
 }
 
 func (p *CommandProcessor) SendConfig(mode int) error {
 	log.Println("Sending CONFIG command")
-	if _, err := p.port.Write(p.newCommand(CMD_CONFIG, []byte{byte(mode)})); err != nil {
+	if err := p.sendCommand(CMD_CONFIG, []byte{byte(mode)}); err != nil {
 		return err
 	}
 
-	p.port.Drain()
+	p.mu.Lock()
+	p.mode = mode
+	p.mu.Unlock()
 	return nil
 }
 
@@ -401,95 +666,431 @@ func (p *CommandProcessor) SendFilters(pre, high, low bool) error {
 	}
 
 	log.Println("Sending FILTERS command")
-	if _, err := p.port.Write(p.newCommand(CMD_FILTERS, []byte{byte(filters)})); err != nil {
+	if err := p.sendCommand(CMD_FILTERS, []byte{byte(filters)}); err != nil {
 		return err
 	}
 
-	p.port.Drain()
+	p.mu.Lock()
+	p.filters, p.hasFilters = filters, true
+	p.mu.Unlock()
 	return nil
 }
 
+// GroupOptions are the radio settings sent with the GROUP command
+type GroupOptions struct {
+	Bandwidth int     // DRA818_25K or DRA818_12K5
+	TxFreq    float64 // transmit frequency, in MHz
+	RxFreq    float64 // receive frequency, in MHz
+	Squelch   int     // 0: listen mode, 1-8: squelch level
+	TxTone    Tone    // CTCSS tone sent when transmitting (DCS is not supported, see Tone.Index)
+	RxTone    Tone    // CTCSS tone required to open the squelch (DCS is not supported, see Tone.Index)
+}
+
 func (p *CommandProcessor) SendGroup(bw int, txfreq, rxfreq float64, squelch int) error {
+	return p.SendGroupOptions(GroupOptions{
+		Bandwidth: bw,
+		TxFreq:    txfreq,
+		RxFreq:    rxfreq,
+		Squelch:   squelch,
+	})
+}
+
+func (p *CommandProcessor) SendGroupOptions(opts GroupOptions) error {
+	txtone, err := opts.TxTone.Index()
+	if err != nil {
+		return err
+	}
+	rxtone, err := opts.RxTone.Index()
+	if err != nil {
+		return err
+	}
+
+	// the radio must be able to tune to both frequencies
+	if err := p.BandPlan.CheckRX(opts.RxFreq); err != nil {
+		return err
+	}
+	if err := p.BandPlan.Module.Check(opts.TxFreq); err != nil {
+		return err
+	}
+
+	// transmitting may not be allowed: that's only an error while transmitting (see SendPTTDown)
+	if err := p.BandPlan.CheckTX(opts.TxFreq); err != nil {
+		if p.Transmitting() {
+			return fmt.Errorf("%w: %w", ErrTxNotAllowed, err)
+		}
+		log.Printf("Warning: transmit not allowed: %v", err)
+	} else if w := p.BandPlan.Warning(opts.TxFreq); w != "" {
+		log.Printf("Warning: %.4f MHz is in the %s", opts.TxFreq, w)
+	}
+	if len(p.TxRanges) > 0 && p.Transmitting() && !bandplan.InRanges(p.TxRanges, opts.TxFreq) {
+		return fmt.Errorf("%w: %.4f MHz", ErrTxNotAllowed, opts.TxFreq)
+	}
+
 	log.Println("Sending GROUP command")
 	group := Group{
-		bw:       byte(bw),
-		freq_tx:  float32(txfreq),
-		freq_rx:  float32(rxfreq),
-		squelch:  byte(squelch),
-		ctxss_tx: 0x00,
-		ctxss_rx: 0x00,
+		bw:       byte(opts.Bandwidth),
+		freq_tx:  float32(opts.TxFreq),
+		freq_rx:  float32(opts.RxFreq),
+		squelch:  byte(opts.Squelch),
+		ctxss_tx: txtone,
+		ctxss_rx: rxtone,
 	}
 
 	var buffer [12]byte
 	binary.Encode(buffer[:], binary.LittleEndian, group)
-	if n, err := p.port.Write(p.newCommand(CMD_GROUP, buffer[:])); err != nil || n != len(buffer)+7 {
+	if err := p.sendCommand(CMD_GROUP, buffer[:]); err != nil {
 		return err
 	}
 
-	p.port.Drain()
+	p.mu.Lock()
+	p.group, p.hasGroup = opts, true
+	p.mu.Unlock()
 	return nil
 }
 
-func (p *CommandProcessor) Stop() {
-	p.quit = true
+// SendScan asks the radio module to check for a carrier on the specified frequency (MHz),
+// without changing the frequency set with SendGroup. The squelch should be set to a level > 0.
+//
+// Experimental: CMD_SCAN is not part of a released firmware protocol (see CMD_SCAN).
+// With a firmware that doesn't implement it, Scanned stays SCAN_WAITING.
+//
+// The result is reported with a ScanResultEvent, and returned by Scanned.
+func (p *CommandProcessor) SendScan(freq float64) error {
+	if err := p.BandPlan.CheckRX(freq); err != nil {
+		return err
+	}
 
-	if err := p.SendStop(); err != nil {
-		log.Printf("Send STOP: %v", err)
+	p.mu.Lock()
+	p.scanFreq = float32(freq)
+	p.scanned = SCAN_WAITING
+	p.mu.Unlock()
+
+	log.Println("Sending SCAN command")
+	return p.sendCommand(CMD_SCAN, binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(freq))))
+}
+
+// Scanned returns the result of the last SendScan: SCAN_WAITING until the response is received,
+// then SCAN_NOT_FOUND or SCAN_FOUND
+func (p *CommandProcessor) Scanned() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scanned
+}
+
+// CurrentGroup returns the last radio settings sent with SendGroup or SendGroupOptions.
+// It returns false if no GROUP command was sent yet.
+func (p *CommandProcessor) CurrentGroup() (GroupOptions, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.group, p.hasGroup
+}
+
+// SendPTTDown keys the transmitter.
+// Audio written with WriteAudio is transmitted until SendPTTUp is called.
+// It returns ErrAlreadyTransmitting if the transmitter is already keyed.
+func (p *CommandProcessor) SendPTTDown() error {
+	return p.SendPTTDownContext(context.Background())
+}
+
+// SendPTTDownContext keys the transmitter, like SendPTTDown.
+// The transmitter is forced off when the context is done, or after MaxTxTime.
+//
+// It returns ErrTxNotAllowed if TxRanges is set and the transmit frequency of the current group is not in it.
+func (p *CommandProcessor) SendPTTDownContext(ctx context.Context) error {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+
+	if p.ptt {
+		// the transmission belongs to whoever keyed it: don't take it over
+		return ErrAlreadyTransmitting
+	}
+
+	if err := p.checkTx(); err != nil {
+		return err
 	}
 
-	p.player.Close()
-	p.port.Close()
+	log.Println("Sending PTT_DOWN command")
+	if err := p.sendCommandContext(ctx, CMD_PTT_DOWN, nil); err != nil {
+		return err
+	}
+
+	txCtx, cancel := context.WithCancelCause(ctx)
+	if p.MaxTxTime > 0 {
+		timer := time.AfterFunc(p.MaxTxTime, func() { cancel(ErrTxTimeout) })
+		context.AfterFunc(txCtx, func() { timer.Stop() })
+	}
+
+	// the watchdog: unkey when the context is done or on timeout
+	stop := context.AfterFunc(txCtx, func() { p.forcePTTUp(txCtx, context.Cause(txCtx)) })
+
+	p.mu.Lock()
+	p.txCancel = cancel
+	p.mu.Unlock()
+
+	p.txCtx, p.txStop = txCtx, stop
+	p.txBuffer = p.txBuffer[:0]
+	p.txInject = nil
+	p.txWritten = false
+	p.txErr = nil
+	p.ptt = true
+	return nil
 }
 
-func (p *CommandProcessor) Reset() {
-	p.port.SetDTR(false)
-	p.port.SetRTS(true)
-	time.Sleep(100 * time.Millisecond)
-	p.port.SetDTR(true)
-	p.port.SetRTS(false)
-	time.Sleep(100 * time.Millisecond)
-	p.port.SetDTR(false)
-	p.port.SetRTS(true)
+// must be called with txMu held
+func (p *CommandProcessor) checkTx() error {
+	g, ok := p.CurrentGroup()
+	if !ok {
+		if len(p.TxRanges) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%w: no frequency selected", ErrTxNotAllowed)
+	}
+
+	if err := p.BandPlan.CheckTX(g.TxFreq); err != nil {
+		return fmt.Errorf("%w: %w", ErrTxNotAllowed, err)
+	}
+	if len(p.TxRanges) > 0 && !bandplan.InRanges(p.TxRanges, g.TxFreq) {
+		return fmt.Errorf("%w: %.4f MHz", ErrTxNotAllowed, g.TxFreq)
+	}
+	return nil
 }
 
-// implement io.Reader interface for oto.Player
-func (p *CommandProcessor) Read(buf []byte) (int, error) {
-	if len(p.audioBuffer) == 0 {
-		for i := 0; i < len(buf); i++ {
-			buf[i] = 0
+// SendPTTUp flushes any pending transmit audio and unkeys the transmitter.
+func (p *CommandProcessor) SendPTTUp() error {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+
+	if p.ptt {
+		if err := p.flushAudio(); err != nil {
+			log.Printf("Flush TX audio: %v", err)
 		}
+	}
+
+	return p.unkey()
+}
 
-		return len(buf), nil
+// forcePTTUp unkeys the transmitter, dropping any pending audio, because of cause.
+// If txCtx is not nil, it only unkeys the transmission that txCtx belongs to.
+func (p *CommandProcessor) forcePTTUp(txCtx context.Context, cause error) {
+	if txCtx == nil {
+		// unblock the writers waiting for window credits, that hold txMu
+		p.mu.Lock()
+		cancel := p.txCancel
+		p.mu.Unlock()
+		if cancel != nil {
+			cancel(cause)
+		}
 	}
 
-	la := len(p.audioBuffer)
-	lb := la * 2
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
 
-	if lb > len(buf) {
-		lb = len(buf)
-		la = lb / 2
+	if !p.ptt || (txCtx != nil && txCtx != p.txCtx) {
+		return
 	}
 
-	j := 0
-	for i := 0; i < lb; i += 2 {
-		buf[i+0] = byte(p.audioBuffer[j])
-		buf[i+1] = byte(p.audioBuffer[j] >> 8)
-		j++
+	log.Printf("Forcing PTT up: %v", cause)
+	p.txErr = cause
+	if err := p.unkey(); err != nil {
+		log.Printf("Send PTT_UP: %v", err)
+	}
+}
+
+// must be called with txMu held
+func (p *CommandProcessor) unkey() error {
+	if p.txStop != nil {
+		p.txStop()
+		p.txStop = nil
 	}
 
-	p.audioBuffer = p.audioBuffer[la:]
-	return lb, nil
+	p.mu.Lock()
+	if p.txCancel != nil {
+		p.txCancel(nil)
+		p.txCancel = nil
+	}
+	p.mu.Unlock()
+
+	p.ptt = false
+	p.txBuffer = p.txBuffer[:0]
+	p.txInject = nil
+
+	log.Println("Sending PTT_UP command")
+
+	// not subject to the context of the transmission, that may be already done
+	return p.sendCommand(CMD_PTT_UP, nil)
 }
 
-func (p *CommandProcessor) SetVolume(volume float64) {
-	if p.player != nil {
-		p.player.SetVolume(volume)
-		if volume > 0 && !p.player.IsPlaying() {
-			p.player.Play()
-		} else if volume == 0 && p.player.IsPlaying() {
-			p.player.Pause()
+// WriteAudio queues 16-bit mono samples at AUDIO_SAMPLING_RATE for transmission.
+//
+// Samples are Opus encoded and sent as CMD_TX_AUDIO packets of OPUS_FRAME_SIZE samples each.
+// A partial frame is kept until more samples are written or FlushAudio is called.
+// The caller is responsible for pacing the audio in real time.
+func (p *CommandProcessor) WriteAudio(samples []int16) (int, error) {
+	return p.WriteAudioContext(context.Background(), samples)
+}
+
+// WriteAudioContext is like WriteAudio but gives up waiting for the device window when the context is done.
+func (p *CommandProcessor) WriteAudioContext(ctx context.Context, samples []int16) (int, error) {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+
+	if !p.ptt {
+		if p.txErr != nil {
+			return 0, p.txErr
 		}
+		return 0, ErrNotTransmitting
 	}
+
+	// stop waiting for window credits if the transmitter is forced off
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(p.txCtx, func() { cancel(context.Cause(p.txCtx)) })
+	defer stop()
+
+	p.txWritten = true
+	p.txBuffer = append(p.txBuffer, p.inject(samples)...)
+
+	sent := 0
+	for len(p.txBuffer)-sent >= OPUS_FRAME_SIZE {
+		if err := p.sendAudioFrame(ctx, p.txBuffer[sent:sent+OPUS_FRAME_SIZE]); err != nil {
+			p.txBuffer = append(p.txBuffer[:0], p.txBuffer[sent:]...)
+			if ctx.Err() != nil {
+				err = context.Cause(ctx)
+			}
+			return 0, err
+		}
+
+		sent += OPUS_FRAME_SIZE
+	}
+
+	p.txBuffer = append(p.txBuffer[:0], p.txBuffer[sent:]...)
+	return len(samples), nil
+}
+
+// FlushAudio pads the pending partial frame (if any) with silence and transmits it.
+func (p *CommandProcessor) FlushAudio() error {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+
+	return p.flushAudio()
+}
+
+// must be called with txMu held
+func (p *CommandProcessor) flushAudio() error {
+	if !p.ptt {
+		return ErrNotTransmitting
+	}
+
+	if len(p.txBuffer) == 0 {
+		return nil
+	}
+
+	var frame [OPUS_FRAME_SIZE]int16
+	copy(frame[:], p.txBuffer)
+	p.txBuffer = p.txBuffer[:0]
+	return p.sendAudioFrame(context.Background(), frame[:])
+}
+
+// WithPTT keys the transmitter, calls fn and unkeys the transmitter when fn returns, even if it panics.
+// The transmitter is also forced off when the context is done, or after MaxTxTime.
+//
+// It returns ErrAlreadyTransmitting, without calling fn, if the transmitter is already keyed:
+// the current transmission is left alone.
+func (p *CommandProcessor) WithPTT(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if err := p.SendPTTDownContext(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		if perr := p.SendPTTUp(); err == nil {
+			err = perr
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// Transmit keys the transmitter, sends the samples (16-bit mono at AUDIO_SAMPLING_RATE) paced in real time
+// and unkeys the transmitter when done, or when the context is done.
+func (p *CommandProcessor) Transmit(ctx context.Context, samples []int16) error {
+	return p.WithPTT(ctx, func(ctx context.Context) error {
+		return p.writePaced(ctx, samples)
+	})
+}
+
+// txLead is how much audio is sent ahead of real time, to avoid gaps in the transmission
+const txLead = 3 * OPUS_FRAME_SIZE
+
+// writePaced writes the samples one frame at a time, staying at most txLead samples ahead of real time
+func (p *CommandProcessor) writePaced(ctx context.Context, samples []int16) error {
+	start := time.Now()
+
+	for sent := 0; sent < len(samples); sent += OPUS_FRAME_SIZE {
+		ahead := time.Duration(sent-txLead) * time.Second / AUDIO_SAMPLING_RATE
+		if wait := time.Until(start.Add(ahead)); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		if _, err := p.WriteAudioContext(ctx, samples[sent:min(sent+OPUS_FRAME_SIZE, len(samples))]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *CommandProcessor) sendAudioFrame(ctx context.Context, frame []int16) error {
+	var data [OPUS_MAX_PACKET]byte
+
+	n, err := p.audioEncoder.Encode(frame, data[:])
+	if err != nil {
+		return err
+	}
+
+	if Debug {
+		log.Printf("TX AUDIO (%v bytes)", n)
+	}
+
+	return p.sendCommandContext(ctx, CMD_TX_AUDIO, data[:n])
+}
+
+func (p *CommandProcessor) Stop() {
+	p.forcePTTUp(nil, ErrStopped)
+
+	p.mu.Lock()
+	p.quit = true
+	p.mu.Unlock()
+	p.stopCancel()
+
+	if err := p.SendStop(); err != nil {
+		log.Printf("Send STOP: %v", err)
+	}
+
+	p.conn().Close()
+	p.closeSinks()
+	p.closeSubscribers()
+}
+
+// Reset resets the board toggling DTR and RTS, if supported by the transport
+func (p *CommandProcessor) Reset() {
+	port, ok := p.conn().(ModemControl)
+	if !ok {
+		log.Println("Reset not supported by transport")
+		return
+	}
+
+	port.SetDTR(false)
+	port.SetRTS(true)
+	time.Sleep(100 * time.Millisecond)
+	port.SetDTR(true)
+	port.SetRTS(false)
+	time.Sleep(100 * time.Millisecond)
+	port.SetDTR(false)
+	port.SetRTS(true)
 }
 
 func smeterValue(s255 int) int {
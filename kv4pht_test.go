@@ -0,0 +1,1674 @@
+package kv4pht
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raff/kv4p-go/bandplan"
+	"github.com/raff/kv4p-go/oggopus"
+	"github.com/raff/kv4p-go/wav"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// fakePort is a Transport where the test plays the device:
+// bytes sent with send are read by the CommandProcessor, commands it writes are collected.
+type fakePort struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func newFakePort() *fakePort {
+	r, w := io.Pipe()
+	return &fakePort{r: r, w: w}
+}
+
+func (f *fakePort) send(t *testing.T, data []byte) {
+	t.Helper()
+	if _, err := f.w.Write(data); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+type command struct {
+	cmd    byte
+	params []byte
+}
+
+// commands returns the commands written so far
+func (f *fakePort) commands() []command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var cmds []command
+	b := f.written.Bytes()
+	for len(b) >= 7 {
+		l := int(binary.LittleEndian.Uint16(b[5:7]))
+		cmds = append(cmds, command{cmd: b[4], params: b[7 : 7+l]})
+		b = b[7+l:]
+	}
+	return cmds
+}
+
+func (f *fakePort) waitCommand(t *testing.T, cmd byte) command {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		for _, c := range f.commands() {
+			if c.cmd == cmd {
+				return c
+			}
+		}
+	}
+	t.Fatalf("command %02x not sent", cmd)
+	return command{}
+}
+
+func (f *fakePort) Read(p []byte) (int, error) { return f.r.Read(p) }
+
+func (f *fakePort) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written.Write(p)
+}
+
+func (f *fakePort) Close() error {
+	f.w.Close()
+	return f.r.Close()
+}
+
+func frame(cmd byte, params []byte) []byte {
+	b := append([]byte{}, cmd_prefix...)
+	b = append(b, cmd, byte(len(params)), byte(len(params)>>8))
+	return append(b, params...)
+}
+
+func versionFrame(window int) []byte {
+	params := make([]byte, 8)
+	binary.LittleEndian.PutUint16(params[0:], 13)
+	params[2] = 'f'
+	params[3] = 0x01
+	binary.LittleEndian.PutUint32(params[4:], uint32(window))
+	return frame(RES_VERSION, params)
+}
+
+func windowUpdateFrame(n int) []byte {
+	params := make([]byte, 4)
+	binary.LittleEndian.PutUint32(params, uint32(n))
+	return frame(RES_WINDOW_UPDATE, params)
+}
+
+func newTestProcessor(t *testing.T, opts ...Option) (*CommandProcessor, *fakePort) {
+	t.Helper()
+
+	port := newFakePort()
+	p, err := NewCommandProcessor(port, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		p.mu.Lock()
+		p.quit = true
+		p.mu.Unlock()
+		port.Close()
+		<-p.done
+		p.closeSubscribers()
+	})
+
+	return p, port
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+func TestParseFrames(t *testing.T) {
+	p, port := newTestProcessor(t)
+	events, cancel := p.Subscribe(16)
+	defer cancel()
+
+	var stream []byte
+	stream = append(stream, 0x00, 0xDE, 0xAD, 0x42) // garbage, including a partial prefix
+	stream = append(stream, frame(RES_HELLO, nil)...)
+	stream = append(stream, versionFrame(2048)...)
+	stream = append(stream, frame(RES_DEBUG_WARNING, []byte("low battery"))...)
+	stream = append(stream, 0xDE) // partial prefix followed by a real one
+	stream = append(stream, frame(RES_SMETER_REPORT, []byte{200})...)
+	stream = append(stream, frame(RES_PHYS_PTT_DOWN, nil)...)
+
+	// deliver the stream in small chunks, splitting frames
+	for len(stream) > 0 {
+		n := min(3, len(stream))
+		port.send(t, stream[:n])
+		stream = stream[n:]
+	}
+
+	if _, ok := nextEvent(t, events).(HelloEvent); !ok {
+		t.Fatal("expected HelloEvent")
+	}
+	if ev, ok := nextEvent(t, events).(VersionEvent); !ok || ev.Version != 13 || ev.RadioStatus != 'f' || ev.WindowSize != 2048 {
+		t.Fatalf("expected VersionEvent, got %#v", ev)
+	}
+	if ev, ok := nextEvent(t, events).(DebugEvent); !ok || ev.Level != RES_DEBUG_WARNING || ev.Message != "low battery" {
+		t.Fatalf("expected DebugEvent, got %#v", ev)
+	}
+	if ev, ok := nextEvent(t, events).(SMeterEvent); !ok || ev.Raw != 200 || ev.Value != smeterValue(200) {
+		t.Fatalf("expected SMeterEvent, got %#v", ev)
+	}
+	if ev, ok := nextEvent(t, events).(PhysPTTEvent); !ok || !ev.Down {
+		t.Fatalf("expected PhysPTTEvent, got %#v", ev)
+	}
+
+	if !p.Hello() {
+		t.Error("Hello() should be true")
+	}
+	if v, rs, _ := p.Version(); v != 13 || rs != 'f' {
+		t.Errorf("Version() = %v, %c", v, rs)
+	}
+	if s, count := p.SMeter(); s != smeterValue(200) || count != 1 {
+		t.Errorf("SMeter() = %v, %v", s, count)
+	}
+}
+
+func TestEventsOrder(t *testing.T) {
+	p, port := newTestProcessor(t)
+	events, cancel := p.Subscribe(300)
+	defer cancel()
+
+	var stream []byte
+	for i := range 256 {
+		stream = append(stream, frame(RES_SMETER_REPORT, []byte{byte(i)})...)
+	}
+	go port.w.Write(stream)
+
+	for i := range 256 {
+		ev, ok := nextEvent(t, events).(SMeterEvent)
+		if !ok || ev.Raw != i {
+			t.Fatalf("event %d: got %#v", i, ev)
+		}
+	}
+}
+
+func TestWindowFlowControl(t *testing.T) {
+	p, port := newTestProcessor(t)
+	p.WriteTimeout = 0
+
+	port.send(t, versionFrame(20))
+	for credits, _ := p.window.available(); credits != 20; credits, _ = p.window.available() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a FILTERS command is 8 bytes, so only two fit in the window
+	for range 2 {
+		if err := p.SendFilters(true, true, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := p.sendCommandContext(ctx, CMD_FILTERS, []byte{0}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	p.WriteTimeout = 100 * time.Millisecond
+	if err := p.SendFilters(true, true, true); err != ErrWindowTimeout {
+		t.Fatalf("expected ErrWindowTimeout, got %v", err)
+	}
+
+	p.WriteTimeout = 0
+	sent := make(chan error)
+	go func() { sent <- p.SendFilters(false, false, false) }()
+
+	select {
+	case err := <-sent:
+		t.Fatalf("command sent without window credits (%v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	port.send(t, windowUpdateFrame(8))
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("command not sent after window update")
+	}
+
+	filters := 0
+	for _, c := range port.commands() {
+		if c.cmd == CMD_FILTERS {
+			filters++
+		}
+	}
+	if filters != 3 {
+		t.Errorf("expected 3 FILTERS commands sent, got %d", filters)
+	}
+}
+
+func TestWindowOversized(t *testing.T) {
+	w := newWindow(20)
+
+	// larger than the window: granted when the window is free, leaving it negative
+	if err := w.acquire(context.Background(), 30); err != nil {
+		t.Fatal(err)
+	}
+	if credits, _ := w.available(); credits != -10 {
+		t.Fatalf("expected -10 credits, got %d", credits)
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- w.acquire(context.Background(), 8) }()
+
+	// the device returns the credits as it consumes the frame
+	for _, update := range []int{5, 10} {
+		w.add(update)
+		select {
+		case err := <-acquired:
+			t.Fatalf("acquired with %d credits (%v)", update, err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	w.add(15)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not acquired after the window update")
+	}
+	if credits, _ := w.available(); credits != 12 {
+		t.Errorf("expected 12 credits, got %d", credits)
+	}
+}
+
+func TestWindowOversizedCommand(t *testing.T) {
+	p, port := newTestProcessor(t)
+	p.WriteTimeout = 0
+
+	port.send(t, versionFrame(20))
+	for credits, _ := p.window.available(); credits != 20; credits, _ = p.window.available() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a 37 bytes command doesn't fit in the window, but it's sent when the window is free
+	if err := p.sendCommand(CMD_TX_AUDIO, make([]byte, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if credits, _ := p.window.available(); credits > 0 {
+		t.Fatalf("expected no credits left, got %d", credits)
+	}
+
+	sent := make(chan error)
+	go func() { sent <- p.SendFilters(false, false, false) }()
+
+	select {
+	case err := <-sent:
+		t.Fatalf("command sent without window credits (%v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	port.send(t, windowUpdateFrame(37))
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("command not sent after window update")
+	}
+}
+
+func TestWindowUpdateSent(t *testing.T) {
+	p, port := newTestProcessor(t)
+
+	port.send(t, versionFrame(64)) // 15 bytes
+	for range 5 {
+		port.send(t, frame(RES_SMETER_REPORT, []byte{100})) // 8 bytes
+	}
+
+	c := port.waitCommand(t, CMD_WINDOW_UPDATE)
+	if n := binary.LittleEndian.Uint32(c.params); n != 15+8*3 {
+		t.Errorf("expected window update of %d bytes, got %d", 15+8*3, n)
+	}
+
+	// the window update doesn't use credits
+	if credits, _ := p.window.available(); credits != 64 {
+		t.Errorf("expected 64 credits, got %d", credits)
+	}
+}
+
+func TestTxAudio(t *testing.T) {
+	p, port := newTestProcessor(t)
+
+	if _, err := p.WriteAudio(make([]int16, 10)); err != ErrNotTransmitting {
+		t.Fatalf("expected ErrNotTransmitting, got %v", err)
+	}
+
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Transmitting() {
+		t.Fatal("Transmitting() should be true")
+	}
+
+	samples := make([]int16, OPUS_FRAME_SIZE*3/2)
+	if n, err := p.WriteAudio(samples); err != nil || n != len(samples) {
+		t.Fatalf("WriteAudio: %v, %v", n, err)
+	}
+
+	if err := p.SendPTTUp(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Transmitting() {
+		t.Fatal("Transmitting() should be false")
+	}
+
+	var cmds []byte
+	for _, c := range port.commands() {
+		cmds = append(cmds, c.cmd)
+	}
+	if expected := []byte{CMD_PTT_DOWN, CMD_TX_AUDIO, CMD_TX_AUDIO, CMD_PTT_UP}; !bytes.Equal(cmds, expected) {
+		t.Errorf("expected commands %02x, got %02x", expected, cmds)
+	}
+}
+
+func TestTxAudioFraming(t *testing.T) {
+	p, port := newTestProcessor(t)
+
+	countAudio := func() (n int) {
+		for _, c := range port.commands() {
+			if c.cmd == CMD_TX_AUDIO {
+				n++
+			}
+		}
+		return
+	}
+
+	if err := p.FlushAudio(); err != ErrNotTransmitting {
+		t.Errorf("expected ErrNotTransmitting, got %v", err)
+	}
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a partial frame is kept until more samples are written
+	if _, err := p.WriteAudio(make([]int16, OPUS_FRAME_SIZE/2)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAudio(); n != 0 || len(p.txBuffer) != OPUS_FRAME_SIZE/2 {
+		t.Fatalf("expected no frames and %d pending samples, got %d and %d", OPUS_FRAME_SIZE/2, n, len(p.txBuffer))
+	}
+
+	// the second half completes the first frame, and leaves a new partial one
+	if _, err := p.WriteAudio(make([]int16, OPUS_FRAME_SIZE)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAudio(); n != 1 || len(p.txBuffer) != OPUS_FRAME_SIZE/2 {
+		t.Fatalf("expected 1 frame and %d pending samples, got %d and %d", OPUS_FRAME_SIZE/2, n, len(p.txBuffer))
+	}
+
+	// the partial frame is padded to a full frame
+	if err := p.FlushAudio(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAudio(); n != 2 || len(p.txBuffer) != 0 {
+		t.Fatalf("expected 2 frames and no pending samples, got %d and %d", n, len(p.txBuffer))
+	}
+	if err := p.FlushAudio(); err != nil || countAudio() != 2 {
+		t.Errorf("nothing to flush, got %v and %d frames", err, countAudio())
+	}
+
+	dec, err := opus.NewDecoder(AUDIO_SAMPLING_RATE, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range port.commands() {
+		if c.cmd != CMD_TX_AUDIO {
+			continue
+		}
+		var out [OPUS_FRAME_SIZE]int16
+		if n, err := dec.Decode(c.params, out[:]); err != nil || n != OPUS_FRAME_SIZE {
+			t.Errorf("expected a frame of %d samples, got %d (%v)", OPUS_FRAME_SIZE, n, err)
+		}
+	}
+
+	if err := p.SendPTTUp(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.WriteAudio(make([]int16, OPUS_FRAME_SIZE)); err != ErrNotTransmitting {
+		t.Errorf("expected ErrNotTransmitting after PTT up, got %v", err)
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	p, port := newTestProcessor(t)
+
+	port.send(t, versionFrame(1<<20))
+	for credits, _ := p.window.available(); credits != 1<<20; credits, _ = p.window.available() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 5 frames and a half, sent in real time after the first few
+	start := time.Now()
+	if err := p.Transmit(context.Background(), make([]int16, OPUS_FRAME_SIZE*11/2)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, min := time.Since(start), time.Duration(5*OPUS_FRAME_SIZE-txLead)*time.Second/AUDIO_SAMPLING_RATE; elapsed < min {
+		t.Errorf("audio not paced: sent in %v, expected at least %v", elapsed, min)
+	}
+
+	var cmds []byte
+	for _, c := range port.commands() {
+		cmds = append(cmds, c.cmd)
+	}
+	expected := []byte{CMD_PTT_DOWN, CMD_TX_AUDIO, CMD_TX_AUDIO, CMD_TX_AUDIO, CMD_TX_AUDIO, CMD_TX_AUDIO, CMD_TX_AUDIO, CMD_PTT_UP}
+	if !bytes.Equal(cmds, expected) {
+		t.Errorf("expected commands %02x, got %02x", expected, cmds)
+	}
+
+	// the transmitter is unkeyed when the context is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := p.Transmit(ctx, make([]int16, AUDIO_SAMPLING_RATE)); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if p.Transmitting() {
+		t.Error("still transmitting after the context was canceled")
+	}
+}
+
+func TestTxWatchdog(t *testing.T) {
+	p, port := newTestProcessor(t)
+	p.MaxTxTime = 100 * time.Millisecond
+
+	countPTTUp := func() (n int) {
+		for _, c := range port.commands() {
+			if c.cmd == CMD_PTT_UP {
+				n++
+			}
+		}
+		return
+	}
+
+	// timeout
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if p.Transmitting() || countPTTUp() != 1 {
+		t.Fatal("PTT not released after MaxTxTime")
+	}
+	if _, err := p.WriteAudio(make([]int16, OPUS_FRAME_SIZE)); err != ErrTxTimeout {
+		t.Errorf("expected ErrTxTimeout, got %v", err)
+	}
+
+	// context canceled
+	p.MaxTxTime = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.SendPTTDownContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for start := time.Now(); p.Transmitting(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("PTT not released when the context was canceled")
+		}
+	}
+
+	// panic
+	func() {
+		defer func() { recover() }()
+		p.WithPTT(context.Background(), func(context.Context) error { panic("oops") })
+	}()
+	if p.Transmitting() {
+		t.Fatal("PTT not released on panic")
+	}
+
+	// stop
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+	if p.Transmitting() || countPTTUp() != 4 {
+		t.Fatal("PTT not released on Stop")
+	}
+}
+
+func TestAlreadyTransmitting(t *testing.T) {
+	p, port := newTestProcessor(t)
+
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nested transmissions don't take over the current one
+	if err := p.SendPTTDown(); err != ErrAlreadyTransmitting {
+		t.Errorf("expected ErrAlreadyTransmitting, got %v", err)
+	}
+	called := false
+	err := p.WithPTT(context.Background(), func(context.Context) error { called = true; return nil })
+	if err != ErrAlreadyTransmitting || called {
+		t.Errorf("expected ErrAlreadyTransmitting without calling fn, got %v (called %v)", err, called)
+	}
+	if err := p.Transmit(context.Background(), make([]int16, OPUS_FRAME_SIZE)); err != ErrAlreadyTransmitting {
+		t.Errorf("expected ErrAlreadyTransmitting, got %v", err)
+	}
+	if !p.Transmitting() {
+		t.Fatal("the transmission was unkeyed by a nested one")
+	}
+
+	if err := p.SendPTTUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	var cmds []byte
+	for _, c := range port.commands() {
+		cmds = append(cmds, c.cmd)
+	}
+	if expected := []byte{CMD_PTT_DOWN, CMD_PTT_UP}; !bytes.Equal(cmds, expected) {
+		t.Errorf("expected commands %02x, got %02x", expected, cmds)
+	}
+}
+
+func TestParseTone(t *testing.T) {
+	for _, test := range []struct {
+		s      string
+		expect Tone
+		err    error
+	}{
+		{"", Tone{}, nil},
+		{"off", Tone{}, nil},
+		{"88.5", Tone{Type: ToneCTCSS, Freq: 88.5}, nil},
+		{" 100 ", Tone{Type: ToneCTCSS, Freq: 100}, nil},
+		{"D023N", Tone{Type: ToneDCS, DCS: 23}, nil},
+		{"023i", Tone{Type: ToneDCS, DCS: 23, Inverted: true}, nil},
+		{"D754", Tone{Type: ToneDCS, DCS: 754}, nil},
+		{"88.6", Tone{}, ErrInvalidTone},
+		{"D024N", Tone{}, ErrInvalidTone},
+		{"tone", Tone{}, ErrInvalidTone},
+	} {
+		tone, err := ParseTone(test.s)
+		if tone != test.expect || !errors.Is(err, test.err) {
+			t.Errorf("ParseTone(%q): expected %+v (%v), got %+v (%v)", test.s, test.expect, test.err, tone, err)
+		}
+		if err == nil {
+			if back, _ := ParseTone(tone.String()); back != tone {
+				t.Errorf("%q: %v doesn't parse back", test.s, tone)
+			}
+		}
+	}
+}
+
+func TestToneIndex(t *testing.T) {
+	if len(CTCSS_TONES) != 38 {
+		t.Fatalf("expected 38 CTCSS tones, got %d", len(CTCSS_TONES))
+	}
+
+	for _, test := range []struct {
+		tone   Tone
+		expect byte
+		err    error
+	}{
+		{Tone{}, 0, nil},
+		{Tone{Type: ToneCTCSS, Freq: 67.0}, 1, nil},
+		{Tone{Type: ToneCTCSS, Freq: 88.5}, 8, nil},
+		{Tone{Type: ToneCTCSS, Freq: 100.0}, 12, nil},
+		{Tone{Type: ToneCTCSS, Freq: 146.2}, 23, nil},
+		{Tone{Type: ToneCTCSS, Freq: 250.3}, 38, nil},
+		{Tone{Type: ToneCTCSS, Freq: 88.6}, 0, ErrInvalidTone},
+		{Tone{Type: ToneDCS, DCS: 23}, 0, ErrToneUnsupported}, // parsed, but not supported by the module
+	} {
+		index, err := test.tone.Index()
+		if index != test.expect || !errors.Is(err, test.err) {
+			t.Errorf("%v: expected %d (%v), got %d (%v)", test.tone, test.expect, test.err, index, err)
+		}
+	}
+
+	// every tone in the table maps to its position + 1
+	for i, f := range CTCSS_TONES {
+		tone, err := CTCSS(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index, err := tone.Index(); err != nil || int(index) != i+1 {
+			t.Errorf("%v: expected index %d, got %d (%v)", tone, i+1, index, err)
+		}
+	}
+
+	p, _ := newTestProcessor(t)
+	dcs, _ := DCS(23, false)
+	if err := p.SendGroupOptions(GroupOptions{RxFreq: 146.52, TxFreq: 146.52, TxTone: dcs}); !errors.Is(err, ErrToneUnsupported) {
+		t.Errorf("expected ErrToneUnsupported, got %v", err)
+	}
+}
+
+func TestCheckFrequency(t *testing.T) {
+	for _, test := range []struct {
+		module bandplan.Module
+		mode   int
+		freq   float64
+		expect error
+	}{
+		{bandplan.SA818, MODE_VHF, 146.52, nil},
+		{bandplan.SA818, MODE_UHF, 146.52, ErrFrequencyRange},
+		{bandplan.SA818, MODE_VHF, 440.0, ErrFrequencyRange},
+		{bandplan.SA818, MODE_UHF, 475.0, nil},
+		{bandplan.DRA818, MODE_UHF, 475.0, ErrFrequencyRange},
+		{bandplan.DRA818, MODE_UHF, 470.0, nil},
+	} {
+		if err := CheckFrequency(test.module, test.mode, test.freq); !errors.Is(err, test.expect) {
+			t.Errorf("CheckFrequency(%s, %d, %v): expected %v, got %v", test.module.Name, test.mode, test.freq, test.expect, err)
+		}
+	}
+}
+
+func TestRepeaterTxFrequency(t *testing.T) {
+	for _, test := range []struct {
+		rxfreq float64
+		shift  string
+		offset float64
+		expect float64
+	}{
+		{146.52, "", 0, 146.52},
+		{146.52, "auto", 0, 146.52},
+		{146.94, "auto", 0, 146.34},
+		{146.61, "auto", 0, 146.01},
+		{147.00, "auto", 0, 147.60},
+		{147.24, "auto", 0, 147.84},
+		{146.10, "auto", 0, 146.10}, // repeater input, not an output
+		{145.23, "auto", 0, 144.63},
+		{443.0, "auto", 0, 448.0},
+		{449.0, "auto", 0, 444.0},
+		{446.0, "auto", 0, 446.0},
+		{146.52, "+", 1.0, 147.52},
+		{449.0, "-", 0, 444.0},
+	} {
+		txfreq, err := RepeaterTxFrequency(test.rxfreq, test.shift, test.offset)
+		if err != nil || math.Abs(txfreq-test.expect) > 1e-6 {
+			t.Errorf("RepeaterTxFrequency(%v, %q, %v): expected %v, got %v (%v)", test.rxfreq, test.shift, test.offset, test.expect, txfreq, err)
+		}
+	}
+
+	if _, err := RepeaterTxFrequency(146.52, "up", 0); !errors.Is(err, ErrInvalidShift) {
+		t.Errorf("expected ErrInvalidShift, got %v", err)
+	}
+}
+
+func TestBandPlan(t *testing.T) {
+	p, _ := newTestProcessor(t)
+	p.BandPlan = bandplan.Plan{Module: bandplan.DRA818, License: bandplan.Technician}
+
+	if err := p.SendGroup(DRA818_25K, 475, 475, 0); !errors.Is(err, bandplan.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+
+	// receiving only is fine
+	if err := p.SendGroup(DRA818_25K, 162.4, 162.4, 0); err != nil {
+		t.Fatal(err)
+	}
+	err := p.SendPTTDown()
+	if !errors.Is(err, ErrTxNotAllowed) || !errors.Is(err, bandplan.ErrNotPermitted) {
+		t.Fatalf("expected ErrTxNotAllowed and ErrNotPermitted, got %v", err)
+	}
+	if p.Transmitting() {
+		t.Fatal("transmitting on a frequency not allowed")
+	}
+}
+
+func TestTxRanges(t *testing.T) {
+	p, _ := newTestProcessor(t)
+	p.TxRanges = []Range{{Min: 144, Max: 148}, {Min: 420, Max: 450}}
+
+	if err := p.SendPTTDown(); !errors.Is(err, ErrTxNotAllowed) {
+		t.Fatalf("expected ErrTxNotAllowed with no frequency, got %v", err)
+	}
+
+	if err := p.SendGroup(DRA818_25K, 150.0, 150.0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendPTTDown(); !errors.Is(err, ErrTxNotAllowed) {
+		t.Fatalf("expected ErrTxNotAllowed, got %v", err)
+	}
+
+	if err := p.SendGroup(DRA818_25K, 146.52, 146.52, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendGroup(DRA818_25K, 150.0, 150.0, 0); !errors.Is(err, ErrTxNotAllowed) {
+		t.Errorf("expected ErrTxNotAllowed changing frequency while transmitting, got %v", err)
+	}
+	if err := p.SendPTTUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRxAudio(t *testing.T) {
+	var pcm bytes.Buffer
+	wavFile := filepath.Join(t.TempDir(), "rx.wav")
+	wavSink, err := CreateWAV(wavFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oggFile := filepath.Join(t.TempDir(), "rx.ogg")
+	oggSink, err := CreateRecording(oggFile, "FREQUENCY=146.520")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, port := newTestProcessor(t, WithAudioSink(PCMSink(&pcm)), WithAudioSink(wavSink), WithAudioSink(oggSink))
+	events, cancel := p.Subscribe(16)
+	defer cancel()
+
+	var sinkPacket []byte
+	var sinkSamples int
+	remove := p.AddSink(PacketSinkFunc(func(packet []byte, samples []int16) {
+		sinkPacket, sinkSamples = append([]byte(nil), packet...), len(samples)
+	}))
+	defer remove()
+
+	var data [OPUS_MAX_PACKET]byte
+	n, err := p.audioEncoder.Encode(make([]int16, OPUS_FRAME_SIZE), data[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.send(t, frame(RES_RX_AUDIO, data[:n]))
+
+	ev, ok := nextEvent(t, events).(RxAudioEvent)
+	if !ok || len(ev.Samples) != OPUS_FRAME_SIZE || !bytes.Equal(ev.Packet, data[:n]) {
+		t.Fatalf("expected RxAudioEvent, got %#v", ev)
+	}
+
+	if !bytes.Equal(sinkPacket, data[:n]) || sinkSamples != OPUS_FRAME_SIZE {
+		t.Errorf("PacketSinkFunc: unexpected packet %02x and %d samples", sinkPacket, sinkSamples)
+	}
+
+	if pcm.Len() != OPUS_FRAME_SIZE*2 {
+		t.Errorf("expected %d bytes of audio, got %d", OPUS_FRAME_SIZE*2, pcm.Len())
+	}
+
+	if err := wavSink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(wavFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := wav.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples, err := r.ReadAll(); err != nil || len(samples) != OPUS_FRAME_SIZE || r.SampleRate != AUDIO_SAMPLING_RATE {
+		t.Errorf("expected %d samples at %d Hz, got %d at %d (%v)", OPUS_FRAME_SIZE, AUDIO_SAMPLING_RATE, len(samples), r.SampleRate, err)
+	}
+
+	if oggSink.Samples() != OPUS_FRAME_SIZE {
+		t.Errorf("expected %d samples recorded, got %d", OPUS_FRAME_SIZE, oggSink.Samples())
+	}
+	if err := oggSink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	of, err := os.Open(oggFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer of.Close()
+
+	or, err := oggopus.NewReader(of)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet, err := or.ReadPacket(); err != nil || !bytes.Equal(packet, data[:n]) {
+		t.Errorf("expected the received packet in the Ogg file, got %02x (%v)", packet, err)
+	}
+}
+
+// run with -race
+func TestConcurrentAccess(t *testing.T) {
+	p, port := newTestProcessor(t)
+	events := p.Events()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sink := NullSink()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				p.Hello()
+				p.Version()
+				p.SMeter()
+				p.Transmitting()
+				remove := p.AddSink(sink)
+				p.SetVolume(0.5)
+				remove()
+				if err := p.SendFilters(true, false, true); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for range events {
+		}
+	}()
+
+	var data [OPUS_MAX_PACKET]byte
+	n, _ := p.audioEncoder.Encode(make([]int16, OPUS_FRAME_SIZE), data[:])
+
+	port.send(t, frame(RES_HELLO, nil))
+	port.send(t, versionFrame(1<<20))
+	for i := range 200 {
+		port.send(t, frame(RES_SMETER_REPORT, []byte{byte(i)}))
+		if i%10 == 0 {
+			port.send(t, frame(RES_RX_AUDIO, data[:n]))
+			port.send(t, windowUpdateFrame(100))
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func testTransport(t *testing.T, host, device Transport) {
+	p, err := NewCommandProcessor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		p.mu.Lock()
+		p.quit = true
+		p.mu.Unlock()
+		host.Close()
+		device.Close()
+	}()
+
+	events, cancel := p.Subscribe(4)
+	defer cancel()
+
+	go device.Write(frame(RES_HELLO, nil))
+	if _, ok := nextEvent(t, events).(HelloEvent); !ok {
+		t.Fatal("expected HelloEvent")
+	}
+
+	sent := make(chan error)
+	go func() { sent <- p.SendConfig(MODE_UHF) }()
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(device, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, frame(CMD_CONFIG, []byte{MODE_UHF})) {
+		t.Errorf("unexpected command: %02x", buf)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipeTransport(t *testing.T) {
+	host, device := Pipe()
+	testTransport(t, host, device)
+}
+
+func TestTCPTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	host, err := OpenTransport("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+
+	testTransport(t, host, device)
+}
+
+// fakeDevice answers the handshake on port, like the firmware after a reset
+func fakeDevice(port *fakePort) {
+	port.w.Write(frame(RES_HELLO, nil))
+	for !slices.ContainsFunc(port.commands(), func(c command) bool { return c.cmd == CMD_CONFIG }) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	port.w.Write(versionFrame(2048))
+}
+
+// opener returns a ConnectOptions.Open function that returns the transports in order
+func opener(transports ...Transport) func() (Transport, error) {
+	var mu sync.Mutex
+	return func() (Transport, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(transports) == 0 {
+			return nil, ErrNoDevice
+		}
+		t := transports[0]
+		transports = transports[1:]
+		if t == nil {
+			return nil, ErrNoDevice
+		}
+		return t, nil
+	}
+}
+
+func TestConnect(t *testing.T) {
+	port := newFakePort()
+	go fakeDevice(port)
+
+	p, err := Connect(context.Background(), ConnectOptions{Open: opener(port), Mode: MODE_UHF, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.abort()
+
+	var cmds []byte
+	for _, c := range port.commands() {
+		cmds = append(cmds, c.cmd)
+	}
+	if !bytes.Equal(cmds, []byte{CMD_STOP, CMD_CONFIG}) {
+		t.Errorf("commands sent: %02x", cmds)
+	}
+	if c := port.waitCommand(t, CMD_CONFIG); !bytes.Equal(c.params, []byte{MODE_UHF}) {
+		t.Errorf("CONFIG %02x", c.params)
+	}
+	if v, _, _ := p.Version(); v != 13 {
+		t.Errorf("Version() = %v", v)
+	}
+	if _, size := p.window.available(); size != 2048 {
+		t.Errorf("window size %v", size)
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	// no HELLO (the board is reset and waited for again)
+	port := newFakePort()
+	start := time.Now()
+	_, err := Connect(context.Background(), ConnectOptions{Open: opener(port), Timeout: 50 * time.Millisecond})
+
+	var cerr *ConnectError
+	if !errors.As(err, &cerr) || cerr.Step != "hello" || !errors.Is(err, ErrNoHello) {
+		t.Errorf("expected ErrNoHello, got %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("Connect returned after %v", d)
+	}
+	if _, err := port.w.Write([]byte{0}); err == nil {
+		t.Error("the transport should be closed")
+	}
+
+	// no VERSION
+	port = newFakePort()
+	go port.w.Write(frame(RES_HELLO, nil))
+	_, err = Connect(context.Background(), ConnectOptions{Open: opener(port), Timeout: 50 * time.Millisecond})
+	if !errors.As(err, &cerr) || cerr.Step != "version" || !errors.Is(err, ErrNoVersion) {
+		t.Errorf("expected ErrNoVersion, got %v", err)
+	}
+
+	// canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Connect(ctx, ConnectOptions{Open: opener(newFakePort())})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// connection lost
+	port = newFakePort()
+	go func() {
+		port.w.Write(frame(RES_HELLO, nil))
+		port.w.CloseWithError(errors.New("device unplugged"))
+	}()
+	_, err = Connect(context.Background(), ConnectOptions{Open: opener(port)})
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("expected ErrTransportClosed, got %v", err)
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	p, port := newTestProcessor(t)
+	events, cancel := p.Subscribe(16)
+	defer cancel()
+
+	port.send(t, versionFrame(1024))
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Err() != nil {
+		t.Errorf("Err() = %v", p.Err())
+	}
+
+	port.w.CloseWithError(errors.New("device unplugged"))
+
+	select {
+	case <-p.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed")
+	}
+
+	if err := p.Err(); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("Err() = %v", err)
+	}
+	if p.Transmitting() {
+		t.Error("the transmitter should be off")
+	}
+	for range events {
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	first, second := newFakePort(), newFakePort()
+	go fakeDevice(first)
+
+	// the device is not found at the first attempt
+	p, err := Connect(context.Background(), ConnectOptions{
+		Open:          opener(first, nil, second),
+		Timeout:       2 * time.Second,
+		Reconnect:     true,
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.abort()
+
+	if err := p.SendFilters(true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendConfig(MODE_UHF); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendGroup(DRA818_12K5, 446.0, 446.0, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel := p.Subscribe(64)
+	defer cancel()
+
+	go fakeDevice(second)
+	first.w.CloseWithError(errors.New("device unplugged"))
+
+	var states []ConnectionState
+	for len(states) == 0 || states[len(states)-1] != ConnectionRestored {
+		if ev, ok := nextEvent(t, events).(ConnectionEvent); ok {
+			states = append(states, ev.State)
+			if ev.State == ConnectionRestored && ev.Attempt != 2 {
+				t.Errorf("restored at attempt %d", ev.Attempt)
+			}
+		}
+	}
+	if want := []ConnectionState{ConnectionLost, ConnectionRetrying, ConnectionRetrying, ConnectionRestored}; !slices.Equal(states, want) {
+		t.Errorf("states %v, expected %v", states, want)
+	}
+
+	var cmds []byte
+	for _, c := range second.commands() {
+		cmds = append(cmds, c.cmd)
+	}
+	if !bytes.Equal(cmds, []byte{CMD_STOP, CMD_CONFIG, CMD_FILTERS, CMD_GROUP}) {
+		t.Errorf("commands sent: %02x", cmds)
+	}
+	if c := second.waitCommand(t, CMD_CONFIG); !bytes.Equal(c.params, []byte{MODE_UHF}) {
+		t.Errorf("CONFIG %02x", c.params)
+	}
+	if c := second.waitCommand(t, CMD_FILTERS); !bytes.Equal(c.params, []byte{FILTERS_PRE | FILTERS_LOW}) {
+		t.Errorf("FILTERS %02x", c.params)
+	}
+	if g, _ := p.CurrentGroup(); g.RxFreq != 446.0 || g.Squelch != 3 {
+		t.Errorf("group %+v", g)
+	}
+
+	select {
+	case <-p.Done():
+		t.Fatalf("stopped: %v", p.Err())
+	default:
+	}
+
+	// stopped while reconnecting
+	second.w.CloseWithError(errors.New("device unplugged"))
+	for {
+		if ev, ok := nextEvent(t, events).(ConnectionEvent); ok && ev.State == ConnectionRetrying {
+			break
+		}
+	}
+
+	p.Stop()
+	select {
+	case <-p.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed")
+	}
+	if err := p.Err(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+	devices := []DeviceInfo{
+		{Port: "/dev/ttyUSB0", VID: "10C4", PID: "EA60", Serial: "0001", InUse: true},
+		{Port: "/dev/ttyUSB1", VID: "1A86", PID: "7523", Serial: "0002"},
+		{Port: "/dev/ttyUSB2", VID: "10C4", PID: "EA60", Serial: "0003"},
+	}
+
+	for _, test := range []struct {
+		serial string
+		port   string
+	}{
+		{"", "/dev/ttyUSB1"}, // the first one not in use
+		{"0001", "/dev/ttyUSB0"},
+		{"0003", "/dev/ttyUSB2"},
+		{"0004", ""},
+	} {
+		d, err := selectDevice(devices, test.serial)
+		if test.port == "" {
+			if !errors.Is(err, ErrNoDevice) {
+				t.Errorf("%q: expected ErrNoDevice, got %v", test.serial, err)
+			}
+			continue
+		}
+		if err != nil || d.Port != test.port {
+			t.Errorf("%q: got %v, %v, expected %v", test.serial, d.Port, err, test.port)
+		}
+	}
+
+	devices[1].InUse, devices[2].InUse = true, true
+	if _, err := selectDevice(devices, ""); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("expected ErrNoDevice, got %v", err)
+	}
+}
+
+func TestMultipleProcessors(t *testing.T) {
+	ports := []*fakePort{newFakePort(), newFakePort()}
+	radios := make([]*CommandProcessor, len(ports))
+
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		go fakeDevice(port)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			p, err := Connect(context.Background(), ConnectOptions{Open: opener(port), Mode: MODE_VHF + i, Timeout: 2 * time.Second})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			radios[i] = p
+		}()
+	}
+	wg.Wait()
+
+	for _, p := range radios {
+		if p == nil {
+			t.FailNow()
+		}
+		defer p.abort()
+	}
+
+	for i, port := range ports {
+		if c := port.waitCommand(t, CMD_CONFIG); c.params[0] != byte(MODE_VHF+i) {
+			t.Errorf("radio %d: CONFIG %02x", i, c.params)
+		}
+	}
+
+	for i, raw := range []byte{50, 200} {
+		events, cancel := radios[i].Subscribe(4)
+		defer cancel()
+
+		ports[i].send(t, frame(RES_SMETER_REPORT, []byte{raw}))
+		if ev, ok := nextEvent(t, events).(SMeterEvent); !ok || ev.Raw != int(raw) {
+			t.Errorf("radio %d: expected SMeterEvent, got %#v", i, ev)
+		}
+	}
+
+	for i, raw := range []int{50, 200} {
+		if s, count := radios[i].SMeter(); s != smeterValue(raw) || count != 1 {
+			t.Errorf("radio %d: SMeter() = %d, %d", i, s, count)
+		}
+	}
+}
+
+// scanDevice sends S-meter reports for the current channel: strong on the active frequencies, noise elsewhere
+func scanDevice(t *testing.T, p *CommandProcessor, port *fakePort) (setActive func(freqs ...float64)) {
+	var mu sync.Mutex
+	active := map[float64]bool{}
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			level := byte(20)
+			g, _ := p.CurrentGroup()
+			mu.Lock()
+			if active[g.RxFreq] {
+				level = 200
+			}
+			mu.Unlock()
+
+			if _, err := port.w.Write(frame(RES_SMETER_REPORT, []byte{level})); err != nil {
+				return
+			}
+		}
+	}()
+
+	return func(freqs ...float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		clear(active)
+		for _, f := range freqs {
+			active[f] = true
+		}
+	}
+}
+
+func TestScanner(t *testing.T) {
+	p, port := newTestProcessor(t)
+	setActive := scanDevice(t, p, port)
+	events, cancel := p.Subscribe(1024)
+	defer cancel()
+
+	scan := func(config ScanConfig) *ScanHitEvent {
+		t.Helper()
+		config.Dwell = 20 * time.Millisecond
+		s, err := NewScanner(p, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		hit, err := s.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hit
+	}
+
+	rangeScan := ScanConfig{Start: 146.4, End: 146.6, Step: 0.02, Resume: ResumeStop, Passes: 1}
+
+	// range scan
+	setActive(146.52)
+	if hit := scan(rangeScan); hit == nil || hit.Channel.RxFreq != 146.52 || hit.SMeter != 9 || hit.Priority {
+		t.Fatalf("unexpected hit %+v", hit)
+	}
+	if g, _ := p.CurrentGroup(); g.RxFreq != 146.52 {
+		t.Errorf("not tuned to the hit: %v", g.RxFreq)
+	}
+
+	for ev := nextEvent(t, events); ; ev = nextEvent(t, events) {
+		if hit, ok := ev.(ScanHitEvent); ok {
+			if hit.Channel.RxFreq != 146.52 {
+				t.Errorf("unexpected hit event %+v", hit)
+			}
+			break
+		}
+	}
+
+	// skip list
+	setActive(146.52, 146.56)
+	skipped := rangeScan
+	skipped.Skip = []float64{146.52}
+	if hit := scan(skipped); hit == nil || hit.Channel.RxFreq != 146.56 {
+		t.Fatalf("unexpected hit %+v", hit)
+	}
+
+	// no signal
+	setActive()
+	if hit := scan(rangeScan); hit != nil {
+		t.Fatalf("unexpected hit %+v", hit)
+	}
+
+	// memory scan with priority channel
+	setActive(162.4)
+	memories := ScanConfig{
+		Channels: []ScanChannel{
+			{Name: "A", GroupOptions: GroupOptions{RxFreq: 146.4, TxFreq: 146.4}},
+			{Name: "B", GroupOptions: GroupOptions{RxFreq: 146.42, TxFreq: 146.42}},
+		},
+		Priority:         &ScanChannel{Name: "WX", GroupOptions: GroupOptions{RxFreq: 162.4, TxFreq: 162.4}},
+		PriorityInterval: time.Nanosecond,
+		Resume:           ResumeStop,
+		Passes:           1,
+	}
+	if hit := scan(memories); hit == nil || hit.Channel.Name != "WX" || !hit.Priority {
+		t.Fatalf("unexpected hit %+v", hit)
+	}
+
+	// resume when the carrier drops
+	setActive(146.42)
+	memories.Priority = nil
+	memories.Resume = ResumeCarrier
+	memories.Hang = 50 * time.Millisecond
+
+	start := time.Now()
+	time.AfterFunc(300*time.Millisecond, func() { setActive() })
+	if hit := scan(memories); hit != nil {
+		t.Fatalf("unexpected hit %+v", hit)
+	}
+	if time.Since(start) < 300*time.Millisecond {
+		t.Error("scan resumed while the channel was active")
+	}
+}
+
+func TestSendScan(t *testing.T) {
+	p, port := newTestProcessor(t)
+	events, cancel := p.Subscribe(16)
+	defer cancel()
+
+	if p.Scanned() != SCAN_WAITING {
+		t.Fatalf("unexpected scan state %02x", p.Scanned())
+	}
+
+	scanResult := func(result byte, freq float64) []byte {
+		return frame(RES_SCAN_RESULT, binary.LittleEndian.AppendUint32([]byte{result}, math.Float32bits(float32(freq))))
+	}
+
+	if err := p.SendScan(146.52); err != nil {
+		t.Fatal(err)
+	}
+	if c := port.waitCommand(t, CMD_SCAN); math.Float32frombits(binary.LittleEndian.Uint32(c.params)) != float32(146.52) {
+		t.Errorf("unexpected SCAN params %02x", c.params)
+	}
+
+	if err := p.SendScan(146.54); err != nil {
+		t.Fatal(err)
+	}
+
+	// late result for the previous frequency
+	port.send(t, scanResult(SCAN_FOUND, 146.52))
+	if ev, ok := nextEvent(t, events).(ScanResultEvent); !ok || !ev.Found() || float32(ev.Freq) != float32(146.52) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if p.Scanned() != SCAN_WAITING {
+		t.Errorf("result for the wrong frequency: %02x", p.Scanned())
+	}
+
+	port.send(t, scanResult(SCAN_NOT_FOUND, 146.54))
+	if ev, ok := nextEvent(t, events).(ScanResultEvent); !ok || ev.Found() {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if p.Scanned() != SCAN_NOT_FOUND {
+		t.Errorf("unexpected scan state %02x", p.Scanned())
+	}
+
+	if err := p.SendScan(10); err == nil {
+		t.Error("scan of an unsupported frequency")
+	}
+}
+
+func TestScanResultFound(t *testing.T) {
+	for result, found := range map[byte]bool{
+		SCAN_WAITING:   false,
+		SCAN_NOT_FOUND: false,
+		SCAN_FOUND:     true,
+		0x03:           false, // unknown status with the SCAN_FOUND bit set
+		0x06:           false,
+	} {
+		if ev := (ScanResultEvent{Freq: 146.52, Result: result}); ev.Found() != found {
+			t.Errorf("result %02x: expected Found %v", result, found)
+		}
+	}
+}
+
+func TestDTMF(t *testing.T) {
+	if _, err := DTMFTones(AUDIO_SAMPLING_RATE, "12X", 0, 0); !errors.Is(err, ErrInvalidDTMF) {
+		t.Errorf("expected ErrInvalidDTMF, got %v", err)
+	}
+
+	samples, err := DTMFTones(AUDIO_SAMPLING_RATE, "123a456b789c*0#d", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples = append(make([]int16, AUDIO_SAMPLING_RATE/2), samples...)
+
+	// with noise and a 1 kHz tone (voice) between the digits
+	for i := range samples {
+		samples[i] += int16(0.02 * math.MaxInt16 * math.Sin(2*math.Pi*1000*float64(i)/AUDIO_SAMPLING_RATE))
+		samples[i] += int16((float64(i*7919%1000)/1000 - 0.5) * 0.02 * math.MaxInt16)
+	}
+
+	var events []DTMFEvent
+	d := NewDTMFDetector(AUDIO_SAMPLING_RATE, func(ev DTMFEvent) { events = append(events, ev) })
+	for i := 0; i < len(samples); i += OPUS_FRAME_SIZE {
+		d.WriteAudio(samples[i:min(len(samples), i+OPUS_FRAME_SIZE)])
+	}
+
+	var digits []byte
+	for i, ev := range events {
+		digits = append(digits, ev.Digit)
+
+		if ev.Duration < 75*time.Millisecond || ev.Duration > 125*time.Millisecond {
+			t.Errorf("%c: unexpected duration %v", ev.Digit, ev.Duration)
+		}
+		if i > 0 && (ev.Gap < 75*time.Millisecond || ev.Gap > 125*time.Millisecond) {
+			t.Errorf("%c: unexpected gap %v", ev.Digit, ev.Gap)
+		}
+	}
+	if string(digits) != DTMF_DIGITS {
+		t.Errorf("expected digits %s, got %s", DTMF_DIGITS, digits)
+	}
+
+	// a single tone is not a digit
+	events = nil
+	tone := make([]int16, AUDIO_SAMPLING_RATE)
+	for i := range tone {
+		tone[i] = int16(0.5 * math.MaxInt16 * math.Sin(2*math.Pi*DTMF_ROWS[0]*float64(i)/AUDIO_SAMPLING_RATE))
+	}
+	d.WriteAudio(tone)
+	d.WriteAudio(make([]int16, AUDIO_SAMPLING_RATE/10))
+	if len(events) != 0 {
+		t.Errorf("unexpected digits %+v", events)
+	}
+}
+
+func TestInjectDTMF(t *testing.T) {
+	p, port := newTestProcessor(t)
+	port.send(t, versionFrame(1<<20))
+
+	if err := p.InjectDTMF("1"); err != ErrNotTransmitting {
+		t.Fatalf("expected ErrNotTransmitting, got %v", err)
+	}
+
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the digits can't be sent in a transmission without audio, nor keying again
+	if err := p.InjectDTMF("12"); err != ErrNoTxAudio {
+		t.Fatalf("expected ErrNoTxAudio, got %v", err)
+	}
+	if err := p.SendDTMF(context.Background(), "12"); err != ErrAlreadyTransmitting {
+		t.Fatalf("expected ErrAlreadyTransmitting, got %v", err)
+	}
+	if !p.Transmitting() {
+		t.Fatal("the transmission was unkeyed by SendDTMF")
+	}
+
+	if _, err := p.WriteAudio(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.InjectDTMF("12"); err != nil {
+		t.Fatal(err)
+	}
+
+	tones, _ := DTMFTones(AUDIO_SAMPLING_RATE, "12", 0, 0)
+	if len(p.txInject) != len(tones) {
+		t.Fatalf("expected %d samples to inject, got %d", len(tones), len(p.txInject))
+	}
+
+	// the injected audio replaces the written one
+	samples := make([]int16, OPUS_FRAME_SIZE/2)
+	for i := range samples {
+		samples[i] = 1
+	}
+	if n, err := p.WriteAudio(samples); err != nil || n != len(samples) {
+		t.Fatalf("WriteAudio: %v, %v", n, err)
+	}
+	if !slices.Equal(p.txBuffer, tones[:len(samples)]) {
+		t.Error("the written audio was not replaced")
+	}
+
+	p.WriteAudio(make([]int16, len(tones)))
+	if len(p.txInject) != 0 {
+		t.Errorf("%d samples not injected", len(p.txInject))
+	}
+
+	p.InjectDTMF("3")
+	if err := p.SendPTTUp(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.txInject) != 0 {
+		t.Error("injected audio not dropped on PTT up")
+	}
+}
+
+func TestDCSCodeword(t *testing.T) {
+	// D023N
+	if cw := dcsCodeword(23, false); cw&0xFFF != 0x813 {
+		t.Errorf("unexpected data bits %03x", cw&0xFFF)
+	}
+
+	// all the codewords are different, even with inverted polarity
+	if len(dcsCodewords) != 2*len(DCS_CODES) {
+		t.Errorf("expected %d codewords, got %d", 2*len(DCS_CODES), len(dcsCodewords))
+	}
+}
+
+// subtoneAudio returns 4 seconds of a transmission with the tone, with a voice-like tone and noise
+func subtoneAudio(tone Tone) []int16 {
+	samples := make([]int16, 4*AUDIO_SAMPLING_RATE)
+
+	cw := dcsCodeword(tone.DCS, tone.Inverted)
+	level := 0.0
+	for i := range samples {
+		t := float64(i) / AUDIO_SAMPLING_RATE
+
+		var x float64
+		switch tone.Type {
+		case ToneCTCSS:
+			x = 0.1 * math.Sin(2*math.Pi*tone.Freq*t)
+		case ToneDCS:
+			bit := int(t*DCS_BAUD) % dcsBits
+			target := -0.1
+			if cw>>bit&1 == 1 {
+				target = 0.1
+			}
+			level += (target - level) * 0.02 // smooth the transitions
+			x = level
+		}
+
+		x += 0.3 * math.Sin(2*math.Pi*800*t)
+		x += (float64(i*7919%1000)/1000 - 0.5) * 0.05
+		samples[i] = int16(x * math.MaxInt16)
+	}
+	return samples
+}
+
+func TestToneDetector(t *testing.T) {
+	for _, c := range [][2]string{
+		{"67.0", "67.0"}, {"71.9", "71.9"}, {"74.4", "74.4"}, {"100.0", "100.0"}, {"250.3", "250.3"},
+		{"D023N", "D023N"}, {"D047I", "D023N"}, // same bits: the normal polarity is reported
+		{"D754I", "D116N"}, {"D411N", "D411N"}, {"D152I", "D115N"},
+		{"none", "none"},
+	} {
+		s := c[0]
+		tone, _ := ParseTone(s)
+		expected, _ := ParseTone(c[1])
+
+		var events []ToneEvent
+		d := NewToneDetector(AUDIO_SAMPLING_RATE, func(ev ToneEvent) { events = append(events, ev) })
+
+		audio := subtoneAudio(tone)
+		for i := 0; i < len(audio); i += OPUS_FRAME_SIZE {
+			d.WriteAudio(audio[i:min(len(audio), i+OPUS_FRAME_SIZE)])
+		}
+
+		if tone.Type == ToneNone {
+			if len(events) != 0 {
+				t.Errorf("none: unexpected events %+v", events)
+			}
+			continue
+		}
+
+		if len(events) != 1 || events[0].Tone != expected || d.Tone() != expected {
+			t.Errorf("%s: unexpected events %+v", s, events)
+		}
+
+		// the tone goes away
+		d.WriteAudio(subtoneAudio(Tone{}))
+		if d.Tone() != (Tone{}) {
+			t.Errorf("%s: still detected", s)
+		}
+	}
+}
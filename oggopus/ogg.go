@@ -0,0 +1,85 @@
+// Package oggopus reads and writes Opus packets in an Ogg container (RFC 3533, RFC 7845)
+package oggopus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	ErrInvalidFormat = fmt.Errorf("Invalid Ogg Opus stream")
+	ErrChecksum      = fmt.Errorf("Invalid Ogg page checksum")
+)
+
+const (
+	// SampleRate is the rate of the granule positions, independent of the rate of the encoded audio
+	SampleRate = 48000
+
+	// DefaultPreSkip is the lookahead of the libopus encoder at 48kHz, used by the kv4p firmware
+	DefaultPreSkip = 312
+
+	// page header flags
+	flagContinued = 0x01
+	flagBOS       = 0x02
+	flagEOS       = 0x04
+
+	pageHeaderSize = 27
+	maxSegments    = 255
+)
+
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return
+}()
+
+// crc computes the Ogg page checksum (CRC-32, polynomial 0x04C11DB7, not reflected)
+func crc(crc uint32, b []byte) uint32 {
+	for _, c := range b {
+		crc = (crc << 8) ^ crcTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+type pageHeader struct {
+	flags    byte
+	granule  int64
+	serial   uint32
+	sequence uint32
+}
+
+// page returns the encoded page, with its checksum
+func (h pageHeader) page(segments, data []byte) []byte {
+	b := make([]byte, pageHeaderSize, pageHeaderSize+len(segments)+len(data))
+	copy(b, "OggS")
+	b[4] = 0 // version
+	b[5] = h.flags
+	binary.LittleEndian.PutUint64(b[6:], uint64(h.granule))
+	binary.LittleEndian.PutUint32(b[14:], h.serial)
+	binary.LittleEndian.PutUint32(b[18:], h.sequence)
+	b[26] = byte(len(segments))
+	b = append(b, segments...)
+	b = append(b, data...)
+
+	binary.LittleEndian.PutUint32(b[22:], crc(0, b))
+	return b
+}
+
+// lacing returns the segment table entries for a packet of size n
+func lacing(n int) []byte {
+	segments := make([]byte, n/255+1)
+	for i := range segments[:len(segments)-1] {
+		segments[i] = 255
+	}
+	segments[len(segments)-1] = byte(n % 255)
+	return segments
+}
@@ -0,0 +1,93 @@
+package oggopus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	// CRC-32/MPEG-2 without the initial and final inversion
+	if c := crc(0, []byte("123456789")); c != 0x89A1897F {
+		t.Errorf("expected checksum 89a1897f, got %08x", c)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, 1, "FREQUENCY=146.520")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets [][]byte
+	for i := range 100 {
+		// include packets that need multiple lacing values, and an empty one
+		packet := bytes.Repeat([]byte{byte(i)}, (i*37)%600)
+		packets = append(packets, packet)
+		if err := w.WritePacket(packet, 1920); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Granule() != 100*1920 {
+		t.Errorf("expected granule %d, got %d", 100*1920, w.Granule())
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Channels != 1 || r.PreSkip != DefaultPreSkip || r.Vendor != vendor {
+		t.Errorf("unexpected header: %d channels, pre-skip %d, vendor %q", r.Channels, r.PreSkip, r.Vendor)
+	}
+	if f := r.Comment("frequency"); f != "146.520" {
+		t.Errorf("expected frequency comment, got %q", f)
+	}
+
+	for i, expected := range packets {
+		packet, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(packet, expected) {
+			t.Fatalf("packet %d: expected %d bytes, got %d", i, len(expected), len(packet))
+		}
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// corrupt the last packet (the last page is an empty EOS page)
+	data := buf.Bytes()
+	data[len(data)-pageHeaderSize-1] ^= 0xFF
+	r, _ = NewReader(bytes.NewReader(data))
+	for err = nil; err == nil; _, err = r.ReadPacket() {
+	}
+	if err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
+
+func TestLongTags(t *testing.T) {
+	var buf bytes.Buffer
+
+	comment := fmt.Sprintf("DESCRIPTION=%s", bytes.Repeat([]byte("x"), 70000))
+	w, err := NewWriter(&buf, 1, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Comments) != 1 || r.Comments[0] != comment {
+		t.Error("long comment not read back")
+	}
+}
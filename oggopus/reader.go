@@ -0,0 +1,185 @@
+package oggopus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// Reader reads the Opus packets from an Ogg Opus stream.
+// Only the first logical stream is read, the pages of other streams are skipped.
+type Reader struct {
+	Channels int
+	PreSkip  int
+	Vendor   string
+	Comments []string
+
+	r       io.Reader
+	started bool
+	serial  uint32
+	packets [][]byte
+	partial []byte // packet continued on the next page
+	eos     bool
+}
+
+// NewReader parses the Ogg Opus headers and returns a Reader positioned at the first audio packet
+func NewReader(r io.Reader) (*Reader, error) {
+	or := &Reader{r: r}
+
+	head, err := or.ReadPacket()
+	if err == io.EOF {
+		err = ErrInvalidFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(head) < 19 || string(head[:8]) != "OpusHead" || head[8]>>4 != 0 {
+		return nil, ErrInvalidFormat
+	}
+
+	or.Channels = int(head[9])
+	or.PreSkip = int(binary.LittleEndian.Uint16(head[10:12]))
+
+	tags, err := or.ReadPacket()
+	if err == io.EOF {
+		err = ErrInvalidFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := or.parseTags(tags); err != nil {
+		return nil, err
+	}
+
+	return or, nil
+}
+
+func (r *Reader) parseTags(tags []byte) error {
+	if !bytes.HasPrefix(tags, []byte("OpusTags")) {
+		return ErrInvalidFormat
+	}
+	tags = tags[8:]
+
+	next := func() (string, bool) {
+		if len(tags) < 4 {
+			return "", false
+		}
+		l := int(binary.LittleEndian.Uint32(tags))
+		if len(tags)-4 < l {
+			return "", false
+		}
+		s := string(tags[4 : 4+l])
+		tags = tags[4+l:]
+		return s, true
+	}
+
+	var ok bool
+	if r.Vendor, ok = next(); !ok || len(tags) < 4 {
+		return ErrInvalidFormat
+	}
+
+	n := int(binary.LittleEndian.Uint32(tags))
+	tags = tags[4:]
+	for range n {
+		c, ok := next()
+		if !ok {
+			return ErrInvalidFormat
+		}
+		r.Comments = append(r.Comments, c)
+	}
+
+	return nil
+}
+
+// Comment returns the value of the first comment with the specified name (case insensitive)
+func (r *Reader) Comment(name string) string {
+	for _, c := range r.Comments {
+		if k, v, ok := strings.Cut(c, "="); ok && strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// ReadPacket returns the next packet, or io.EOF at the end of the stream
+func (r *Reader) ReadPacket() ([]byte, error) {
+	for len(r.packets) == 0 {
+		if r.eos {
+			return nil, io.EOF
+		}
+		if err := r.readPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	packet := r.packets[0]
+	r.packets = r.packets[1:]
+	return packet, nil
+}
+
+func (r *Reader) readPage() error {
+	var header [pageHeaderSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrInvalidFormat
+		}
+		return err
+	}
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return ErrInvalidFormat
+	}
+
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(r.r, segments); err != nil {
+		return ErrInvalidFormat
+	}
+
+	size := 0
+	for _, s := range segments {
+		size += int(s)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return ErrInvalidFormat
+	}
+
+	checksum := binary.LittleEndian.Uint32(header[22:])
+	binary.LittleEndian.PutUint32(header[22:], 0)
+	if crc(crc(crc(0, header[:]), segments), data) != checksum {
+		return ErrChecksum
+	}
+
+	flags := header[5]
+	serial := binary.LittleEndian.Uint32(header[14:])
+
+	if !r.started {
+		if flags&flagBOS == 0 {
+			return ErrInvalidFormat
+		}
+		r.started = true
+		r.serial = serial
+	} else if serial != r.serial {
+		return nil // another logical stream
+	}
+
+	if flags&flagContinued == 0 {
+		r.partial = nil
+	}
+
+	for _, s := range segments {
+		r.partial = append(r.partial, data[:s]...)
+		data = data[s:]
+
+		if s < 255 {
+			r.packets = append(r.packets, r.partial)
+			r.partial = nil
+		}
+	}
+
+	if flags&flagEOS != 0 {
+		r.eos = true
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package oggopus
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"os"
+)
+
+const (
+	vendor = "kv4p-go"
+
+	// pages are flushed after this many samples (1 second), to limit the data lost if the stream is truncated
+	pageDuration = SampleRate
+)
+
+// Writer writes Opus packets to an Ogg Opus stream, without re-encoding them
+type Writer struct {
+	w      io.Writer
+	closer io.Closer // set by Create
+	header pageHeader
+
+	segments []byte
+	data     []byte
+	pending  int // samples in the pending page
+
+	closed bool
+}
+
+// NewWriter writes the Ogg Opus headers to w and returns a Writer for the packets.
+// The comments are added to the OpusTags header, in the "NAME=value" form.
+func NewWriter(w io.Writer, channels int, comments ...string) (*Writer, error) {
+	ow := &Writer{
+		w:      w,
+		header: pageHeader{serial: rand.Uint32()},
+	}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:], DefaultPreSkip)
+	binary.LittleEndian.PutUint32(head[12:], SampleRate) // input sample rate
+	binary.LittleEndian.PutUint16(head[16:], 0)          // output gain
+	head[18] = 0                                         // channel mapping family
+
+	ow.header.flags = flagBOS
+	if err := ow.writePage(lacing(len(head)), head); err != nil {
+		return nil, err
+	}
+
+	tags := []byte("OpusTags")
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(vendor)))
+	tags = append(tags, vendor...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(comments)))
+	for _, c := range comments {
+		tags = binary.LittleEndian.AppendUint32(tags, uint32(len(c)))
+		tags = append(tags, c...)
+	}
+
+	// the tags must start on a new page, and can span multiple pages
+	const fullPage = maxSegments * 255
+	ow.header.flags = 0
+	for len(tags) >= fullPage {
+		if err := ow.writePage(lacing(fullPage)[:maxSegments], tags[:fullPage]); err != nil {
+			return nil, err
+		}
+		ow.header.flags = flagContinued
+		tags = tags[fullPage:]
+	}
+	if err := ow.writePage(lacing(len(tags)), tags); err != nil {
+		return nil, err
+	}
+
+	return ow, nil
+}
+
+// Create creates an Ogg Opus file (see NewWriter).
+// Closing the returned Writer also closes the file.
+func Create(path string, channels int, comments ...string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f, channels, comments...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w.closer = f
+	return w, nil
+}
+
+// WritePacket writes an Opus packet that decodes to the specified number of samples (per channel, at 48kHz)
+func (w *Writer) WritePacket(packet []byte, samples int) error {
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	segments := lacing(len(packet))
+	if len(w.segments)+len(segments) > maxSegments {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	w.segments = append(w.segments, segments...)
+	w.data = append(w.data, packet...)
+	w.header.granule += int64(samples)
+	w.pending += samples
+
+	if w.pending >= pageDuration {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Granule returns the number of samples written so far, including the pre-skip samples
+func (w *Writer) Granule() int64 {
+	return w.header.granule
+}
+
+// Flush writes the pending packets as a new page
+func (w *Writer) Flush() error {
+	if len(w.segments) == 0 {
+		return nil
+	}
+
+	return w.flush(0)
+}
+
+func (w *Writer) flush(flags byte) error {
+	w.header.flags = flags
+	err := w.writePage(w.segments, w.data)
+	w.segments = w.segments[:0]
+	w.data = w.data[:0]
+	w.pending = 0
+	return err
+}
+
+// Close writes the pending packets in the last page of the stream.
+// If the Writer was created with Create, it also closes the file.
+func (w *Writer) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	err := w.flush(flagEOS)
+	w.closed = true
+
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (w *Writer) writePage(segments, data []byte) error {
+	_, err := w.w.Write(w.header.page(segments, data))
+	w.header.sequence++
+	return err
+}
@@ -0,0 +1,103 @@
+// Package otosink plays the audio received by a kv4pht.CommandProcessor through oto
+package otosink
+
+import (
+	"sync"
+
+	"github.com/ebitengine/oto/v3"
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+var (
+	ctxOnce    sync.Once
+	otoContext *oto.Context
+	ctxErr     error
+)
+
+// Context returns the oto context shared by all the players.
+// oto only allows one context per process, created on first use.
+func Context() (*oto.Context, error) {
+	ctxOnce.Do(func() {
+		op := &oto.NewContextOptions{
+			SampleRate:   kv4pht.AUDIO_SAMPLING_RATE,
+			ChannelCount: 1,
+			Format:       oto.FormatSignedInt16LE,
+		}
+
+		var ready chan struct{}
+		otoContext, ready, ctxErr = oto.NewContext(op)
+		if ctxErr == nil {
+			<-ready
+		}
+	})
+
+	return otoContext, ctxErr
+}
+
+// Player is a kv4pht.AudioSink that plays the received audio.
+// It starts paused: call SetVolume (or CommandProcessor.SetVolume) with a volume greater than 0 to start playing.
+type Player struct {
+	mu     sync.Mutex
+	buffer []int16
+
+	player *oto.Player
+}
+
+// New returns a Player using the shared oto context
+func New() (*Player, error) {
+	c, err := Context()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Player{}
+	p.player = c.NewPlayer(p)
+	return p, nil
+}
+
+// WriteAudio implements kv4pht.AudioSink. The audio is discarded while the player is paused.
+func (p *Player) WriteAudio(samples []int16) error {
+	if !p.player.IsPlaying() {
+		return nil
+	}
+
+	p.mu.Lock()
+	p.buffer = append(p.buffer, samples...)
+	p.mu.Unlock()
+	return nil
+}
+
+// Read implements the io.Reader interface for oto.Player
+func (p *Player) Read(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.buffer) == 0 {
+		clear(buf)
+		return len(buf), nil
+	}
+
+	la := min(len(p.buffer), len(buf)/2)
+	for i, s := range p.buffer[:la] {
+		buf[i*2+0] = byte(s)
+		buf[i*2+1] = byte(s >> 8)
+	}
+
+	p.buffer = p.buffer[la:]
+	return la * 2, nil
+}
+
+// SetVolume sets the volume (0-1). A volume of 0 pauses the player.
+func (p *Player) SetVolume(volume float64) {
+	p.player.SetVolume(volume)
+	if volume > 0 && !p.player.IsPlaying() {
+		p.player.Play()
+	} else if volume == 0 && p.player.IsPlaying() {
+		p.player.Pause()
+	}
+}
+
+// Close stops the player. The shared context is not closed.
+func (p *Player) Close() error {
+	return p.player.Close()
+}
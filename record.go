@@ -0,0 +1,97 @@
+package kv4pht
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/raff/kv4p-go/oggopus"
+)
+
+var ErrRecordingFormat = fmt.Errorf("Unsupported recording format (use .wav, .ogg or .opus)")
+
+// PacketSink is implemented by sinks that want the Opus packets received from the device,
+// together with the number of samples they decode to.
+// WritePacket is called right before WriteAudio, with the packet of the samples passed to it.
+type PacketSink interface {
+	WritePacket(packet []byte, samples int) error
+}
+
+// OggSink writes the received Opus packets to an Ogg Opus file, as they are (no transcoding)
+type OggSink struct {
+	mu      sync.Mutex
+	w       *oggopus.Writer
+	samples int
+}
+
+// CreateOgg creates an Ogg Opus file and returns a sink that writes the received packets to it.
+// The comments are added to the file metadata, in the "NAME=value" form.
+// The file is finalized when the sink is closed.
+func CreateOgg(path string, comments ...string) (*OggSink, error) {
+	w, err := oggopus.Create(path, 1, comments...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OggSink{w: w}, nil
+}
+
+// WriteAudio implements AudioSink. The decoded audio is ignored.
+func (s *OggSink) WriteAudio([]int16) error {
+	return nil
+}
+
+func (s *OggSink) WritePacket(packet []byte, samples int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.w.WritePacket(packet, samples); err != nil {
+		return err
+	}
+
+	s.samples += samples
+	return nil
+}
+
+// Samples returns the number of samples written so far
+func (s *OggSink) Samples() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.samples
+}
+
+func (s *OggSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Close()
+}
+
+// Recorder is a sink that writes the received audio to a file
+type Recorder interface {
+	AudioSink
+	Samples() int
+	Close() error
+}
+
+// CreateRecording creates a WAV (.wav) or Ogg Opus (.ogg, .opus) file, depending on the extension,
+// and returns a sink that records the received audio.
+// The comments ("NAME=value") are only stored in Ogg Opus files.
+func CreateRecording(path string, comments ...string) (Recorder, error) {
+	var r Recorder
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		r, err = CreateWAV(path)
+	case ".ogg", ".opus":
+		r, err = CreateOgg(path, comments...)
+	default:
+		err = ErrRecordingFormat
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
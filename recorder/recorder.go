@@ -0,0 +1,318 @@
+// Package recorder records each received transmission to its own file,
+// and keeps an index of the recordings.
+//
+// A transmission starts when the S-meter (or, if enabled, the energy of the received audio) goes above a threshold,
+// and ends when both stay below the threshold for the hang time.
+//
+// The energy trigger is off by default: with the squelch open the receiver outputs FM noise,
+// that would keep a recording going forever. Only enable it with a squelch level above 0.
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+// IndexFile is the name of the index, in the recordings directory
+const IndexFile = "index.json"
+
+// Config contains the Recorder settings. Zero values select the defaults.
+type Config struct {
+	Dir    string // directory for the recordings and the index (default: current directory)
+	Format string // "ogg" (default) or "wav"
+
+	SMeterThreshold int     // S-meter value (1-9) that starts a transmission (default: 4)
+	EnergyThreshold float64 // RMS level of the audio (0-1) that starts a transmission (default: 0, disabled)
+
+	Hang        time.Duration // silence before a transmission is considered over (default: 2s)
+	MinDuration time.Duration // shorter recordings are discarded (default: 500ms)
+
+	// OnRecording, if set, is called for every recording added to the index
+	OnRecording func(Entry)
+}
+
+// Entry describes a recorded transmission
+type Entry struct {
+	File       string    `json:"file"` // relative to the recordings directory
+	Start      time.Time `json:"start"`
+	Duration   float64   `json:"duration"` // seconds of recorded audio
+	Frequency  float64   `json:"frequency"`
+	PeakSMeter int       `json:"peak_smeter"`
+	PeakRaw    int       `json:"peak_smeter_raw"`
+}
+
+// Recorder detects the received transmissions and records them
+type Recorder struct {
+	config Config
+
+	frequency func() float64 // frequency of the current transmission
+
+	// current transmission, protected by recMu:
+	// the audio is written from the reader goroutine of the CommandProcessor (see Run)
+	recMu      sync.Mutex
+	rec        kv4pht.Recorder
+	entry      Entry
+	lastActive time.Time
+
+	mu    sync.Mutex // protects index
+	index []Entry
+}
+
+// New returns a Recorder with the specified configuration, loading the existing index (if any)
+func New(config Config) (*Recorder, error) {
+	if config.Dir == "" {
+		config.Dir = "."
+	}
+	if config.Format == "" {
+		config.Format = "ogg"
+	}
+	if config.Format != "ogg" && config.Format != "wav" {
+		return nil, fmt.Errorf("Invalid recording format %q", config.Format)
+	}
+	if config.SMeterThreshold == 0 {
+		config.SMeterThreshold = 4
+	}
+	if config.Hang == 0 {
+		config.Hang = 2 * time.Second
+	}
+	if config.MinDuration == 0 {
+		config.MinDuration = 500 * time.Millisecond
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	index, err := LoadIndex(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		config:    config,
+		frequency: func() float64 { return 0 },
+		index:     index,
+	}, nil
+}
+
+// LoadIndex returns the recordings listed in the index of the specified directory
+func LoadIndex(dir string) ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IndexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index []Entry
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("%s: %w", IndexFile, err)
+	}
+	return index, nil
+}
+
+// Index returns the recordings in the index
+func (r *Recorder) Index() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), r.index...)
+}
+
+// Run records the transmissions received by p until the context is done or p is stopped.
+// The audio is received through a sink; only the S-meter is read from the events, where a dropped one is harmless.
+func (r *Recorder) Run(ctx context.Context, p *kv4pht.CommandProcessor) error {
+	r.frequency = func() float64 {
+		g, _ := p.CurrentGroup()
+		return g.RxFreq
+	}
+
+	events, cancel := p.Subscribe(256)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	defer r.tick(time.Time{}, true)
+
+	remove := p.AddSink(kv4pht.PacketSinkFunc(func(packet []byte, samples []int16) {
+		r.audio(packet, samples, time.Now())
+	}))
+	defer remove()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			r.handle(ev, time.Now())
+
+		case now := <-ticker.C:
+			r.tick(now, false)
+		}
+	}
+}
+
+// handle processes the S-meter events
+func (r *Recorder) handle(ev kv4pht.Event, now time.Time) {
+	sm, ok := ev.(kv4pht.SMeterEvent)
+	if !ok {
+		return
+	}
+
+	r.recMu.Lock()
+	defer r.recMu.Unlock()
+
+	if sm.Value >= r.config.SMeterThreshold {
+		r.active(now)
+	}
+	if r.rec != nil {
+		r.entry.PeakSMeter = max(r.entry.PeakSMeter, sm.Value)
+		r.entry.PeakRaw = max(r.entry.PeakRaw, sm.Raw)
+	}
+
+	r.check(now)
+}
+
+// audio records the received audio (and its Opus packet, if not nil)
+func (r *Recorder) audio(packet []byte, samples []int16, now time.Time) {
+	r.recMu.Lock()
+	defer r.recMu.Unlock()
+
+	if r.config.EnergyThreshold > 0 && rms(samples) >= r.config.EnergyThreshold {
+		r.active(now)
+	}
+
+	if r.rec != nil {
+		if ps, ok := r.rec.(kv4pht.PacketSink); ok && packet != nil {
+			if err := ps.WritePacket(packet, len(samples)); err != nil {
+				log.Printf("Record: %v", err)
+			}
+		}
+		if err := r.rec.WriteAudio(samples); err != nil {
+			log.Printf("Record: %v", err)
+		}
+	}
+
+	r.check(now)
+}
+
+// active marks activity on the channel, starting a new recording if needed. Must be called with recMu held.
+func (r *Recorder) active(now time.Time) {
+	r.lastActive = now
+	if r.rec != nil {
+		return
+	}
+
+	freq := r.frequency()
+	name := fmt.Sprintf("%s_%.4f.%s", now.Format("20060102-150405"), freq, r.config.Format)
+
+	rec, err := kv4pht.CreateRecording(filepath.Join(r.config.Dir, name),
+		"DATE="+now.Format(time.RFC3339),
+		fmt.Sprintf("FREQUENCY=%.4f", freq))
+	if err != nil {
+		log.Printf("Record: %v", err)
+		return
+	}
+
+	log.Printf("Recording %s", name)
+	r.rec = rec
+	r.entry = Entry{File: name, Start: now, Frequency: freq}
+}
+
+// tick ends the current recording after the hang time, or in any case if force is true
+func (r *Recorder) tick(now time.Time, force bool) {
+	r.recMu.Lock()
+	defer r.recMu.Unlock()
+
+	if force {
+		r.finish()
+	} else {
+		r.check(now)
+	}
+}
+
+// check ends the current recording after the hang time. Must be called with recMu held.
+func (r *Recorder) check(now time.Time) {
+	if r.rec != nil && now.Sub(r.lastActive) >= r.config.Hang {
+		r.finish()
+	}
+}
+
+// finish closes the current recording and adds it to the index. Must be called with recMu held.
+func (r *Recorder) finish() {
+	if r.rec == nil {
+		return
+	}
+
+	rec, entry := r.rec, r.entry
+	r.rec = nil
+
+	path := filepath.Join(r.config.Dir, entry.File)
+	samples := rec.Samples()
+	if err := rec.Close(); err != nil {
+		log.Printf("Record: %v", err)
+	}
+
+	entry.Duration = float64(samples) / kv4pht.AUDIO_SAMPLING_RATE
+	if time.Duration(entry.Duration*float64(time.Second)) < r.config.MinDuration {
+		os.Remove(path)
+		return
+	}
+
+	r.mu.Lock()
+	r.index = append(r.index, entry)
+	err := r.saveIndex()
+	r.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Save index: %v", err)
+	}
+
+	log.Printf("Recorded %s (%.1fs, S%d)", entry.File, entry.Duration, entry.PeakSMeter)
+
+	if r.config.OnRecording != nil {
+		r.config.OnRecording(entry)
+	}
+}
+
+// must be called with mu held
+func (r *Recorder) saveIndex() error {
+	data, err := json.MarshalIndent(r.index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write a new file and rename it, so that the index is never left truncated
+	tmp := filepath.Join(r.config.Dir, IndexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.config.Dir, IndexFile))
+}
+
+// rms returns the RMS level of the samples (0-1)
+func rms(samples []int16) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, s := range samples {
+		v := float64(s) / math.MaxInt16
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
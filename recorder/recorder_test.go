@@ -0,0 +1,145 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/oggopus"
+)
+
+// audio sends a frame of audio to r, as the sink added by Run does
+func audio(r *Recorder, amplitude int16, now time.Time) {
+	samples := make([]int16, kv4pht.OPUS_FRAME_SIZE)
+	for i := range samples {
+		if i%2 == 0 {
+			samples[i] = amplitude
+		} else {
+			samples[i] = -amplitude
+		}
+	}
+	r.audio([]byte{0xF8, byte(amplitude)}, samples, now)
+}
+
+func TestTransmissions(t *testing.T) {
+	dir := t.TempDir()
+
+	var recorded []Entry
+	r, err := New(Config{
+		Dir:             dir,
+		Hang:            time.Second,
+		EnergyThreshold: 0.01,
+		OnRecording:     func(e Entry) { recorded = append(recorded, e) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.frequency = func() float64 { return 146.52 }
+
+	now := time.Date(2025, 5, 1, 22, 30, 0, 0, time.UTC)
+	step := 40 * time.Millisecond
+
+	// noise below the thresholds doesn't start a recording
+	for range 10 {
+		r.handle(kv4pht.SMeterEvent{Value: 1, Raw: 20}, now)
+		audio(r, 100, now)
+		now = now.Add(step)
+	}
+	if r.rec != nil {
+		t.Fatal("recording started on noise")
+	}
+
+	// a 2 seconds transmission, followed by the hang time
+	r.handle(kv4pht.SMeterEvent{Value: 9, Raw: 180}, now)
+	for i := range 50 {
+		if i == 10 {
+			r.handle(kv4pht.SMeterEvent{Value: 9, Raw: 200}, now)
+		}
+		audio(r, 10000, now)
+		now = now.Add(step)
+	}
+	for range 30 {
+		audio(r, 100, now)
+		now = now.Add(step)
+	}
+	if r.rec != nil {
+		t.Fatal("recording not finished after the hang time")
+	}
+
+	// a short blip is discarded
+	r.handle(kv4pht.SMeterEvent{Value: 6, Raw: 100}, now)
+	r.tick(now.Add(2*time.Second), false)
+
+	if len(recorded) != 1 {
+		t.Fatalf("expected 1 recording, got %d", len(recorded))
+	}
+
+	e := recorded[0]
+	if e.File != "20250501-223000_146.5200.ogg" || e.Frequency != 146.52 || e.PeakSMeter != 9 || e.PeakRaw != 200 {
+		t.Errorf("unexpected entry %#v", e)
+	}
+	// the transmission, and the hang time before it ends
+	if expected := float64(50+25) * step.Seconds(); e.Duration < expected-0.1 || e.Duration > expected+0.1 {
+		t.Errorf("expected duration %.2f, got %.2f", expected, e.Duration)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ogg"))
+	if len(files) != 1 {
+		t.Errorf("expected 1 recording, found %v", files)
+	}
+
+	f, err := os.Open(filepath.Join(dir, e.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	or, err := oggopus.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if or.Comment("FREQUENCY") != "146.5200" {
+		t.Errorf("unexpected comments %q", or.Comments)
+	}
+
+	index, err := LoadIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 1 || index[0].File != e.File || !index[0].Start.Equal(e.Start) {
+		t.Errorf("unexpected index %#v", index)
+	}
+
+	// the index is extended by a new Recorder
+	r, err = New(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Index()) != 1 {
+		t.Errorf("existing index not loaded")
+	}
+}
+
+func TestSquelchNoise(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the squelch open the receiver outputs loud noise, even with no signal
+	now := time.Date(2025, 5, 1, 22, 30, 0, 0, time.UTC)
+	for range 100 {
+		r.handle(kv4pht.SMeterEvent{Value: 1, Raw: 20}, now)
+		audio(r, 10000, now)
+		now = now.Add(40 * time.Millisecond)
+	}
+	r.tick(now, true)
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.ogg")); len(files) != 0 || len(r.Index()) != 0 {
+		t.Errorf("noise recorded: %v", files)
+	}
+}
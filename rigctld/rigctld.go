@@ -0,0 +1,509 @@
+// Package rigctld implements a subset of the Hamlib rigctld network protocol,
+// so that programs like WSJT-X, fldigi and Gpredict can control the radio.
+//
+// The supported commands are get/set frequency (f, F), mode and passband (m, M), PTT (t, T), VFO (v, V),
+// split (s, S, i, I), level (l STRENGTH), \chk_vfo, \get_powerstat, \dump_state and quit (q, Q),
+// with their long names and the extended response protocol (i.e. "+f").
+package rigctld
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/bandplan"
+)
+
+// DefaultAddress is the address rigctld listens on by default
+const DefaultAddress = "localhost:4532"
+
+// Hamlib error codes, returned as "RPRT -code"
+const (
+	RIG_OK      = 0
+	RIG_EINVAL  = 1 // invalid parameter
+	RIG_ENIMPL  = 4 // function not implemented
+	RIG_EIO     = 6 // I/O error
+	RIG_ERJCTED = 9 // command rejected by the rig
+)
+
+// Hamlib capabilities reported by \dump_state
+const (
+	rigModelNet      = 2          // RIG_MODEL_NETRIGCTL
+	rigModeFM        = 0x40       // RIG_MODE_FM
+	rigModePKTFM     = 0x400      // RIG_MODE_PKTFM
+	rigVFOAB         = 0x3        // RIG_VFO_A | RIG_VFO_B
+	rigAnt1          = 0x1        // RIG_ANT_1
+	rigLevelStrength = 0x40000000 // RIG_LEVEL_STRENGTH
+)
+
+// passbands, in Hz
+const (
+	passbandWide   = 25000
+	passbandNarrow = 12500
+)
+
+// Error is a Hamlib error code
+type Error int
+
+func (e Error) Error() string {
+	return fmt.Sprintf("RPRT -%d", int(e))
+}
+
+// Server executes the rigctld commands received from the clients
+type Server struct {
+	p *kv4pht.CommandProcessor
+
+	mu        sync.Mutex // serializes the commands from multiple clients
+	mode      int        // MODE_VHF or MODE_UHF, as sent with SendConfig
+	bandwidth int        // set before the frequency
+	split     bool
+	txFreq    float64 // with split, in MHz
+}
+
+// New returns a Server for a radio configured for mode (MODE_VHF or MODE_UHF).
+// The current frequency is the one set with SendGroup, if any.
+func New(p *kv4pht.CommandProcessor, mode int) *Server {
+	s := &Server{p: p, mode: mode, bandwidth: kv4pht.DRA818_25K}
+	if g, ok := p.CurrentGroup(); ok && g.TxFreq != g.RxFreq {
+		s.split, s.txFreq = true, g.TxFreq
+	}
+	return s
+}
+
+// Serve accepts connections from the listener and handles them, until the listener is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			if kv4pht.Debug {
+				log.Println("rigctld: connection from", conn.RemoteAddr())
+			}
+			if err := s.ServeConn(conn); err != nil {
+				log.Println("rigctld:", err)
+			}
+		}()
+	}
+}
+
+// ServeConn reads commands from conn and writes the responses, until the client quits or disconnects
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		response, quit := s.Execute(line)
+		if _, err := io.WriteString(conn, response); err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// command describes a rigctld command
+type command struct {
+	short  string
+	long   string
+	args   int
+	labels []string // names of the returned values, for the extended response protocol
+	run    func(s *Server, args []string) ([]string, error)
+}
+
+var commands = []command{
+	{"f", "get_freq", 0, []string{"Frequency"}, (*Server).getFreq},
+	{"F", "set_freq", 1, nil, (*Server).setFreq},
+	{"m", "get_mode", 0, []string{"Mode", "Passband"}, (*Server).getMode},
+	{"M", "set_mode", 2, nil, (*Server).setMode},
+	{"t", "get_ptt", 0, []string{"PTT"}, (*Server).getPTT},
+	{"T", "set_ptt", 1, nil, (*Server).setPTT},
+	{"v", "get_vfo", 0, []string{"VFO"}, (*Server).getVFO},
+	{"V", "set_vfo", 1, nil, (*Server).setVFO},
+	{"s", "get_split_vfo", 0, []string{"Split", "TX VFO"}, (*Server).getSplit},
+	{"S", "set_split_vfo", 2, nil, (*Server).setSplit},
+	{"i", "get_split_freq", 0, []string{"TX Frequency"}, (*Server).getSplitFreq},
+	{"I", "set_split_freq", 1, nil, (*Server).setSplitFreq},
+	{"l", "get_level", 1, []string{"Level Value"}, (*Server).getLevel},
+	{"", "chk_vfo", 0, []string{"ChkVFO"}, func(*Server, []string) ([]string, error) { return []string{"0"}, nil }},
+	{"", "get_powerstat", 0, []string{"Power Status"}, func(*Server, []string) ([]string, error) { return []string{"1"}, nil }},
+	{"", "dump_state", 0, nil, (*Server).dumpState},
+}
+
+// Execute runs a command line and returns the response, and true if the client asked to quit
+func (s *Server) Execute(line string) (response string, quit bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", false
+	}
+
+	// extended response protocol: the command is prefixed with the separator for the response values
+	var sep byte
+	if strings.IndexByte("+;|,", line[0]) >= 0 {
+		sep, line = line[0], strings.TrimSpace(line[1:])
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	name := fields[0]
+	if name == "q" || name == "Q" || name == `\quit` {
+		return "", true
+	}
+
+	for _, c := range commands {
+		if name != c.short && name != `\`+c.long {
+			continue
+		}
+
+		args := fields[1:]
+		if len(args) < c.args {
+			return s.report(c, sep, args, nil, Error(RIG_EINVAL)), false
+		}
+
+		s.mu.Lock()
+		values, err := c.run(s, args[:c.args])
+		s.mu.Unlock()
+
+		if err != nil {
+			log.Printf("rigctld: %s: %v", line, err)
+		}
+		return s.report(c, sep, args[:c.args], values, err), false
+	}
+
+	return fmt.Sprintf("%v\n", Error(RIG_ENIMPL)), false
+}
+
+// report formats the response of a command
+func (s *Server) report(c command, sep byte, args, values []string, err error) string {
+	code := RIG_OK
+	if err != nil {
+		code = RIG_EIO
+		if e, ok := err.(Error); ok {
+			code = int(e)
+		}
+	}
+
+	var b strings.Builder
+
+	if sep == 0 {
+		// plain responses: the values for get commands, RPRT for set commands (that return no values) and errors
+		if err != nil || values == nil {
+			fmt.Fprintf(&b, "RPRT %d\n", -code)
+			return b.String()
+		}
+		for _, v := range values {
+			b.WriteString(v)
+			b.WriteByte('\n')
+		}
+		return b.String()
+	}
+
+	if sep == '+' {
+		sep = '\n'
+	}
+
+	b.WriteString(c.long + ":")
+	for _, a := range args {
+		b.WriteString(" " + a)
+	}
+	b.WriteByte(sep)
+
+	for i, v := range values {
+		if i < len(c.labels) {
+			b.WriteString(c.labels[i] + ": ")
+		}
+		b.WriteString(v)
+		b.WriteByte(sep)
+	}
+
+	fmt.Fprintf(&b, "RPRT %d\n", -code)
+	return b.String()
+}
+
+// group returns the current radio settings
+func (s *Server) group() kv4pht.GroupOptions {
+	g, ok := s.p.CurrentGroup()
+	if !ok {
+		g = kv4pht.GroupOptions{Bandwidth: s.bandwidth}
+	}
+	return g
+}
+
+// sendGroup sends the settings, switching band if needed
+func (s *Server) sendGroup(g kv4pht.GroupOptions) error {
+	if err := s.p.BandPlan.CheckRX(g.RxFreq); err != nil {
+		return Error(RIG_EINVAL)
+	}
+
+	mode := kv4pht.MODE_VHF
+	if s.p.BandPlan.Module.IsUHF(g.RxFreq) {
+		mode = kv4pht.MODE_UHF
+	}
+	if mode != s.mode {
+		if err := s.p.SendConfig(mode); err != nil {
+			return err
+		}
+		s.mode = mode
+	}
+
+	var ferr *bandplan.FrequencyError
+	switch err := s.p.SendGroupOptions(g); {
+	case errors.Is(err, kv4pht.ErrTxNotAllowed):
+		return Error(RIG_ERJCTED)
+	case errors.As(err, &ferr):
+		return Error(RIG_EINVAL)
+	default:
+		return err
+	}
+}
+
+// parseFreq parses a frequency in Hz and returns it in MHz
+func parseFreq(arg string) (float64, error) {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil || f <= 0 {
+		return 0, Error(RIG_EINVAL)
+	}
+	return f / 1e6, nil
+}
+
+func formatFreq(f float64) string {
+	return strconv.FormatInt(int64(math.Round(f*1e6)), 10)
+}
+
+func (s *Server) getFreq([]string) ([]string, error) {
+	return []string{formatFreq(s.group().RxFreq)}, nil
+}
+
+func (s *Server) setFreq(args []string) ([]string, error) {
+	f, err := parseFreq(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	g := s.group()
+	g.RxFreq = f
+	if s.split {
+		g.TxFreq = s.txFreq
+	} else {
+		g.TxFreq = f
+	}
+	return nil, s.sendGroup(g)
+}
+
+func (s *Server) getMode([]string) ([]string, error) {
+	passband := passbandWide
+	if s.group().Bandwidth == kv4pht.DRA818_12K5 {
+		passband = passbandNarrow
+	}
+	return []string{"FM", strconv.Itoa(passband)}, nil
+}
+
+func (s *Server) setMode(args []string) ([]string, error) {
+	passband, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, Error(RIG_EINVAL)
+	}
+
+	g := s.group()
+
+	switch strings.ToUpper(args[0]) {
+	case "FM", "PKTFM":
+		switch {
+		case passband < 0: // no change
+		case passband == 0 || passband > passbandNarrow:
+			g.Bandwidth = kv4pht.DRA818_25K
+		default:
+			g.Bandwidth = kv4pht.DRA818_12K5
+		}
+	case "FMN":
+		g.Bandwidth = kv4pht.DRA818_12K5
+	default:
+		return nil, Error(RIG_EINVAL)
+	}
+
+	if _, ok := s.p.CurrentGroup(); !ok {
+		s.bandwidth = g.Bandwidth // no frequency yet: the bandwidth is sent with set_freq
+		return nil, nil
+	}
+	return nil, s.sendGroup(g)
+}
+
+func (s *Server) getPTT([]string) ([]string, error) {
+	if s.p.Transmitting() {
+		return []string{"1"}, nil
+	}
+	return []string{"0"}, nil
+}
+
+func (s *Server) setPTT(args []string) ([]string, error) {
+	switch args[0] {
+	case "0":
+		if !s.p.Transmitting() {
+			return nil, nil
+		}
+		return nil, s.p.SendPTTUp()
+	case "1", "2", "3": // PTT on, mic, data
+		if s.p.Transmitting() {
+			return nil, nil
+		}
+		if err := s.p.SendPTTDown(); err != nil && !errors.Is(err, kv4pht.ErrAlreadyTransmitting) {
+			log.Printf("rigctld: PTT: %v", err)
+			return nil, Error(RIG_ERJCTED)
+		}
+		return nil, nil
+	default:
+		return nil, Error(RIG_EINVAL)
+	}
+}
+
+func (s *Server) getVFO([]string) ([]string, error) {
+	return []string{"VFOA"}, nil
+}
+
+func (s *Server) setVFO(args []string) ([]string, error) {
+	switch args[0] {
+	case "VFOA", "currVFO", "Main":
+		return nil, nil
+	}
+	return nil, Error(RIG_EINVAL)
+}
+
+func (s *Server) getSplit([]string) ([]string, error) {
+	if s.split {
+		return []string{"1", "VFOB"}, nil
+	}
+	return []string{"0", "VFOA"}, nil
+}
+
+func (s *Server) setSplit(args []string) ([]string, error) {
+	switch args[0] {
+	case "0":
+		s.split = false
+	case "1":
+		if !s.split {
+			s.split, s.txFreq = true, s.group().TxFreq
+		}
+	default:
+		return nil, Error(RIG_EINVAL)
+	}
+
+	g := s.group()
+	if !s.split && g.TxFreq != g.RxFreq {
+		g.TxFreq = g.RxFreq
+		return nil, s.sendGroup(g)
+	}
+	return nil, nil
+}
+
+func (s *Server) getSplitFreq([]string) ([]string, error) {
+	if s.split {
+		return []string{formatFreq(s.txFreq)}, nil
+	}
+	return []string{formatFreq(s.group().TxFreq)}, nil
+}
+
+func (s *Server) setSplitFreq(args []string) ([]string, error) {
+	f, err := parseFreq(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	s.split, s.txFreq = true, f
+
+	g, ok := s.p.CurrentGroup()
+	if !ok {
+		return nil, nil // sent with set_freq
+	}
+	g.TxFreq = f
+	return nil, s.sendGroup(g)
+}
+
+func (s *Server) getLevel(args []string) ([]string, error) {
+	switch args[0] {
+	case "STRENGTH":
+		// dB relative to S9, 6 dB per S unit
+		smeter, _ := s.p.SMeter()
+		return []string{strconv.Itoa((smeter - 9) * 6)}, nil
+	case "?":
+		return []string{"STRENGTH"}, nil
+	}
+	return nil, Error(RIG_EINVAL)
+}
+
+// dumpState returns the rig capabilities, in the format expected by the Hamlib NET rigctl backend (protocol 0)
+func (s *Server) dumpState([]string) ([]string, error) {
+	modes := rigModeFM | rigModePKTFM
+	module := s.p.BandPlan.Module
+
+	var ranges []string
+	for _, r := range module.Ranges() {
+		ranges = append(ranges, fmt.Sprintf("%.0f.000000 %.0f.000000 0x%x -1 -1 0x%x 0x%x", r.Min*1e6, r.Max*1e6, modes, rigVFOAB, rigAnt1))
+	}
+
+	lines := []string{
+		"0",                       // protocol version
+		strconv.Itoa(rigModelNet), // rig model
+		strconv.Itoa(s.region()),  // ITU region
+	}
+
+	lines = append(lines, ranges...) // RX ranges
+	lines = append(lines, "0 0 0 0 0 0 0")
+	for _, r := range ranges { // TX ranges (power in mW)
+		lines = append(lines, strings.Replace(r, "-1 -1", "500 1000", 1))
+	}
+	lines = append(lines, "0 0 0 0 0 0 0")
+
+	lines = append(lines,
+		fmt.Sprintf("0x%x 5000", modes), // tuning steps
+		fmt.Sprintf("0x%x 6250", modes),
+		fmt.Sprintf("0x%x 12500", modes),
+		fmt.Sprintf("0x%x 25000", modes),
+		"0 0",
+		fmt.Sprintf("0x%x %d", modes, passbandWide), // filters
+		fmt.Sprintf("0x%x %d", modes, passbandNarrow),
+		"0 0",
+		"0",                                   // max RIT
+		"0",                                   // max XIT
+		"0",                                   // max IF shift
+		"0",                                   // announces
+		"",                                    // preamp
+		"",                                    // attenuator
+		"0x0",                                 // get functions
+		"0x0",                                 // set functions
+		fmt.Sprintf("0x%x", rigLevelStrength), // get levels
+		"0x0",                                 // set levels
+		"0x0",                                 // get parameters
+		"0x0",                                 // set parameters
+	)
+
+	return lines, nil
+}
+
+func (s *Server) region() int {
+	if r := int(s.p.BandPlan.Region); r > 0 {
+		return r
+	}
+	return 2
+}
@@ -0,0 +1,174 @@
+package rigctld
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/simulator"
+)
+
+func startServer(t *testing.T) (*simulator.Device, *kv4pht.CommandProcessor, *bufio.ReadWriter) {
+	t.Helper()
+
+	d := simulator.New()
+	d.SMeterInterval = 20 * time.Millisecond
+	d.Carriers = []simulator.Carrier{{Freq: 146.52, Level: 200, Source: simulator.Tone(1000, 0.5)}}
+
+	host, device := kv4pht.Pipe()
+	go d.Run(device)
+
+	p, err := kv4pht.NewCommandProcessor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.Stop()
+		device.Close()
+	})
+
+	if err := p.SendConfig(kv4pht.MODE_VHF); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go New(p, kv4pht.MODE_VHF).ServeConn(server)
+	return d, p, bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client))
+}
+
+// exchange sends a command and reads the specified number of response lines
+func exchange(t *testing.T, rw *bufio.ReadWriter, cmd string, lines int) []string {
+	t.Helper()
+
+	rw.WriteString(cmd + "\n")
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var response []string
+	for i := 0; i < lines; i++ {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		response = append(response, strings.TrimSuffix(line, "\n"))
+	}
+	return response
+}
+
+func expect(t *testing.T, rw *bufio.ReadWriter, cmd string, expected ...string) {
+	t.Helper()
+
+	if response := exchange(t, rw, cmd, len(expected)); strings.Join(response, "|") != strings.Join(expected, "|") {
+		t.Errorf("%s: expected %q, got %q", cmd, expected, response)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	d, p, rw := startServer(t)
+
+	expect(t, rw, "M FM 12500", "RPRT 0")
+	expect(t, rw, "F 146520000", "RPRT 0")
+	expect(t, rw, "f", "146520000")
+	expect(t, rw, "m", "FM", "12500")
+	if g, _ := p.CurrentGroup(); g.RxFreq != 146.52 || g.TxFreq != 146.52 || g.Bandwidth != kv4pht.DRA818_12K5 {
+		t.Errorf("unexpected group %+v", g)
+	}
+
+	expect(t, rw, `\set_mode FM 0`, "RPRT 0")
+	expect(t, rw, "+m", "get_mode:", "Mode: FM", "Passband: 25000", "RPRT 0")
+
+	// band change
+	expect(t, rw, "F 446000000.000000", "RPRT 0")
+	for start := time.Now(); d.Mode() != kv4pht.MODE_UHF; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("band not changed: %02x", d.Mode())
+		}
+	}
+	expect(t, rw, "F 1000000", "RPRT -1")
+
+	// split
+	expect(t, rw, "F 146940000", "RPRT 0")
+	expect(t, rw, "S 1 VFOB", "RPRT 0")
+	expect(t, rw, "I 146340000", "RPRT 0")
+	expect(t, rw, "s", "1", "VFOB")
+	expect(t, rw, "i", "146340000")
+	if g, _ := p.CurrentGroup(); g.TxFreq != 146.34 || g.RxFreq != 146.94 {
+		t.Errorf("unexpected group %+v", g)
+	}
+	expect(t, rw, "S 0 VFOA", "RPRT 0")
+	if g, _ := p.CurrentGroup(); g.TxFreq != g.RxFreq {
+		t.Errorf("split not disabled: %+v", g)
+	}
+
+	// PTT
+	expect(t, rw, "T 1", "RPRT 0")
+	expect(t, rw, "t", "1")
+	if !p.Transmitting() {
+		t.Error("not transmitting")
+	}
+	expect(t, rw, `\set_ptt 0`, "RPRT 0")
+	expect(t, rw, "t", "0")
+
+	// S-meter
+	expect(t, rw, "F 146520000", "RPRT 0")
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if s, _ := p.SMeter(); s == 9 {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("no S-meter report")
+		}
+	}
+	expect(t, rw, "l STRENGTH", "0")
+	expect(t, rw, "l AF", "RPRT -1")
+
+	expect(t, rw, "v", "VFOA")
+	expect(t, rw, `\chk_vfo`, "0")
+	expect(t, rw, "x", "RPRT -4")
+	expect(t, rw, "F", "RPRT -1")
+}
+
+func TestEmptyLine(t *testing.T) {
+	_, p, rw := startServer(t)
+
+	s := New(p, kv4pht.MODE_VHF)
+	for _, line := range []string{"", " ", "+", "\t"} {
+		if response, quit := s.Execute(line); response != "" || quit {
+			t.Errorf("%q: unexpected response %q (quit %v)", line, response, quit)
+		}
+	}
+
+	// blank lines are ignored
+	expect(t, rw, "\n\nt", "0")
+}
+
+func TestDumpState(t *testing.T) {
+	_, _, rw := startServer(t)
+
+	state := exchange(t, rw, `\dump_state`, 29)
+	expected := map[int]string{
+		0:  "0",
+		1:  "2",
+		3:  "134000000.000000 174000000.000000 0x440 -1 -1 0x3 0x1",
+		4:  "400000000.000000 480000000.000000 0x440 -1 -1 0x3 0x1",
+		5:  "0 0 0 0 0 0 0",
+		9:  "0x440 5000",
+		25: "0x40000000",
+		28: "0x0",
+	}
+	for i, s := range expected {
+		if state[i] != s {
+			t.Errorf("line %d: expected %q, got %q", i, s, state[i])
+		}
+	}
+
+	// the connection is still in sync
+	expect(t, rw, "t", "0")
+	expect(t, rw, "q")
+}
@@ -0,0 +1,209 @@
+package same
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/afsk"
+)
+
+// AlertEvent is sent when a SAME header is received
+type AlertEvent struct {
+	Header *Header
+}
+
+func (e AlertEvent) Code() byte { return 0 }
+
+// AttentionToneEvent is sent at the end of the attention tone that follows the header
+type AttentionToneEvent struct {
+	Duration time.Duration
+}
+
+func (e AttentionToneEvent) Code() byte { return 0 }
+
+// EndOfMessageEvent is sent when the end of message is received.
+// A Monitor also sends it when it stops recording without it (after MaxMessage, or when stopped), with Timeout set.
+type EndOfMessageEvent struct {
+	Recording string // path of the recorded message, if any
+	Timeout   bool
+}
+
+func (e EndOfMessageEvent) Code() byte { return 0 }
+
+const (
+	toneBlock    = 20 * time.Millisecond
+	minTone      = time.Second // shorter tones are ignored (the attention tone lasts 8-25 seconds)
+	toneMisses   = 5           // blocks without the tone before it's considered over
+	toneLevel    = 0.01        // minimum RMS level of the tone
+	toneRatio    = 0.5         // minimum fraction of the energy at AttentionFreq
+	sequenceTime = 3 * time.Second
+)
+
+// Decoder decodes the SAME headers, the attention tone and the end of message from the received audio
+type Decoder struct {
+	mu sync.Mutex
+
+	sampleRate int
+	samples    int64 // received so far, to measure time
+
+	// header bursts
+	bits   *afsk.BitDemodulator
+	last   uint16 // last 16 bits received, the most recent in the MSB
+	synced bool
+	cur    byte
+	nbits  int
+	text   []byte
+
+	// bursts of the current sequence: the header is accepted when two bursts match,
+	// or when the sequence ends with a single valid one
+	headers   []*Header
+	alerted   bool
+	eom       bool
+	lastBurst int64
+
+	// attention tone (Goertzel)
+	blockSize        int
+	coeff            float64
+	s1, s2, energy   float64
+	blockPos         int
+	toneBlocks, miss int
+
+	onEvent func(kv4pht.Event)
+}
+
+// NewDecoder returns a Decoder for audio at sampleRate, that calls onEvent with AlertEvent, AttentionToneEvent
+// and EndOfMessageEvent. onEvent is called from WriteAudio, so it should not block.
+func NewDecoder(sampleRate int, onEvent func(kv4pht.Event)) *Decoder {
+	d := &Decoder{
+		sampleRate: sampleRate,
+		blockSize:  int(toneBlock.Seconds() * float64(sampleRate)),
+		onEvent:    onEvent,
+	}
+	d.coeff = 2 * math.Cos(2*math.Pi*AttentionFreq/float64(sampleRate))
+	d.bits = afsk.NewBitDemodulator(sampleRate, Baud, MarkFreq, SpaceFreq, d.bit)
+	return d
+}
+
+// WriteAudio decodes the samples. It implements kv4pht.AudioSink.
+func (d *Decoder) WriteAudio(samples []int16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range samples {
+		d.tone(float64(s) / math.MaxInt16)
+	}
+
+	d.bits.WriteAudio(samples)
+	d.samples += int64(len(samples))
+
+	if len(d.headers) > 0 && d.since(d.lastBurst) > sequenceTime {
+		if !d.alerted {
+			d.onEvent(AlertEvent{Header: d.headers[0]})
+		}
+		d.headers, d.alerted = nil, false
+	}
+	if d.eom && d.since(d.lastBurst) > sequenceTime {
+		d.eom = false
+	}
+
+	return nil
+}
+
+func (d *Decoder) since(samples int64) time.Duration {
+	return time.Duration(d.samples-samples) * time.Second / time.Duration(d.sampleRate)
+}
+
+// bit is called by the BitDemodulator, with mu held
+func (d *Decoder) bit(mark bool) {
+	bit := uint16(0)
+	if mark {
+		bit = 1
+	}
+
+	d.last = d.last>>1 | bit<<15
+	if d.last == preamble<<8|preamble && len(d.text) == 0 {
+		// (re)align to the preamble
+		d.synced, d.nbits, d.cur = true, 0, 0
+		return
+	}
+	if !d.synced {
+		return
+	}
+
+	d.cur = d.cur>>1 | byte(bit)<<7 // LSB first
+	if d.nbits++; d.nbits < 8 {
+		return
+	}
+	c := d.cur
+	d.nbits, d.cur = 0, 0
+
+	switch {
+	case c == preamble && len(d.text) == 0:
+	case c >= 0x20 && c < 0x7F && len(d.text) < maxHeader:
+		d.text = append(d.text, c)
+	default: // end of the burst
+		d.burst(string(d.text))
+		d.synced = false
+		d.text = d.text[:0]
+	}
+}
+
+// burst handles a received burst
+func (d *Decoder) burst(text string) {
+	if len(text) >= len(EOM) && text[:len(EOM)] == EOM {
+		if !d.eom {
+			d.onEvent(EndOfMessageEvent{})
+		}
+		d.eom = true
+		d.lastBurst = d.samples
+		return
+	}
+
+	h, err := ParseHeader(text)
+	if err != nil {
+		return
+	}
+	d.lastBurst = d.samples
+
+	for _, prev := range d.headers {
+		if !d.alerted && prev.String() == h.String() {
+			d.onEvent(AlertEvent{Header: h})
+			d.alerted = true
+		}
+	}
+	d.headers = append(d.headers, h)
+}
+
+// tone detects the attention tone, in blocks of toneBlock
+func (d *Decoder) tone(s float64) {
+	s0 := s + d.coeff*d.s1 - d.s2
+	d.s2, d.s1 = d.s1, s0
+	d.energy += s * s
+
+	if d.blockPos++; d.blockPos < d.blockSize {
+		return
+	}
+
+	n := float64(d.blockSize)
+	power := d.s1*d.s1 + d.s2*d.s2 - d.coeff*d.s1*d.s2
+	present := d.energy/n > toneLevel*toneLevel && 2*power/(n*d.energy) > toneRatio
+	d.s1, d.s2, d.energy, d.blockPos = 0, 0, 0, 0
+
+	switch {
+	case present:
+		d.toneBlocks++
+		d.miss = 0
+	case d.toneBlocks > 0:
+		if d.miss++; d.miss < toneMisses {
+			break
+		}
+
+		duration := time.Duration(d.toneBlocks) * toneBlock
+		if duration >= minTone {
+			d.onEvent(AttentionToneEvent{Duration: duration})
+		}
+		d.toneBlocks, d.miss = 0, 0
+	}
+}
@@ -0,0 +1,175 @@
+package same
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+// Config contains the Monitor settings. Zero values select the defaults.
+type Config struct {
+	Dir        string        // directory for the recordings of the messages (default: no recordings)
+	Format     string        // "ogg" (default) or "wav"
+	MaxMessage time.Duration // the recording stops if the end of message is not received (default: 2m)
+}
+
+// Monitor decodes the SAME alerts received by a CommandProcessor, sends the events to its subscribers
+// and optionally records the message that follows each header.
+type Monitor struct {
+	config Config
+
+	// the audio is decoded and recorded from the reader goroutine of the CommandProcessor (see Run)
+	mu      sync.Mutex
+	decoder *Decoder
+	pending []kv4pht.Event // events from the decoder, for the current audio
+
+	rec     kv4pht.Recorder
+	recFile string
+	recEnd  time.Time
+}
+
+// New returns a Monitor with the specified configuration
+func New(config Config) (*Monitor, error) {
+	if config.Format == "" {
+		config.Format = "ogg"
+	}
+	if config.Format != "ogg" && config.Format != "wav" {
+		return nil, fmt.Errorf("Invalid recording format %q", config.Format)
+	}
+	if config.MaxMessage == 0 {
+		config.MaxMessage = 2 * time.Minute
+	}
+	if config.Dir != "" {
+		if err := os.MkdirAll(config.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Monitor{config: config}
+	m.decoder = NewDecoder(kv4pht.AUDIO_SAMPLING_RATE, func(ev kv4pht.Event) {
+		m.pending = append(m.pending, ev)
+	})
+	return m, nil
+}
+
+// Run decodes the audio received by p until the context is done or p is stopped.
+// The events (AlertEvent, AttentionToneEvent, EndOfMessageEvent) are sent with p.Emit.
+func (m *Monitor) Run(ctx context.Context, p *kv4pht.CommandProcessor) error {
+	defer func() {
+		m.mu.Lock()
+		m.finish(p, true)
+		m.mu.Unlock()
+	}()
+
+	remove := p.AddSink(kv4pht.PacketSinkFunc(func(packet []byte, samples []int16) {
+		m.audio(p, packet, samples)
+	}))
+	defer remove()
+
+	// the events channel is closed when p is stopped
+	events, cancel := p.Subscribe(1)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+		}
+	}
+}
+
+// audio decodes and records the received audio (and its Opus packet, if not nil)
+func (m *Monitor) audio(p *kv4pht.CommandProcessor, packet []byte, samples []int16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.decoder.WriteAudio(samples)
+
+	pending := m.pending
+	m.pending = nil
+
+	for _, e := range pending {
+		switch e := e.(type) {
+		case AlertEvent:
+			log.Printf("SAME: %s", e.Header)
+			m.record(p, e.Header)
+			p.Emit(e)
+
+		case EndOfMessageEvent:
+			if !m.finish(p, false) {
+				p.Emit(e)
+			}
+
+		default:
+			p.Emit(e)
+		}
+	}
+
+	if m.rec == nil {
+		return
+	}
+
+	if ps, ok := m.rec.(kv4pht.PacketSink); ok && packet != nil {
+		if err := ps.WritePacket(packet, len(samples)); err != nil {
+			log.Printf("SAME: record: %v", err)
+		}
+	}
+	if err := m.rec.WriteAudio(samples); err != nil {
+		log.Printf("SAME: record: %v", err)
+	}
+
+	if time.Now().After(m.recEnd) {
+		m.finish(p, true)
+	}
+}
+
+// record starts recording the message. Must be called with mu held.
+func (m *Monitor) record(p *kv4pht.CommandProcessor, h *Header) {
+	if m.config.Dir == "" || m.rec != nil {
+		return
+	}
+
+	g, _ := p.CurrentGroup()
+	now := time.Now()
+	name := fmt.Sprintf("%s_%s.%s", now.Format("20060102-150405"), h.Event, m.config.Format)
+	path := filepath.Join(m.config.Dir, name)
+
+	rec, err := kv4pht.CreateRecording(path,
+		"DATE="+now.Format(time.RFC3339),
+		fmt.Sprintf("FREQUENCY=%.4f", g.RxFreq),
+		"SAME="+h.String())
+	if err != nil {
+		log.Printf("SAME: record: %v", err)
+		return
+	}
+
+	log.Printf("SAME: recording %s", name)
+	m.rec, m.recFile, m.recEnd = rec, path, now.Add(m.config.MaxMessage)
+}
+
+// finish closes the current recording, and sends the EndOfMessageEvent with its path.
+// It returns false if there was no recording. Must be called with mu held.
+func (m *Monitor) finish(p *kv4pht.CommandProcessor, timeout bool) bool {
+	if m.rec == nil {
+		return false
+	}
+
+	if err := m.rec.Close(); err != nil {
+		log.Printf("SAME: record: %v", err)
+	}
+	log.Printf("SAME: recorded %s", m.recFile)
+
+	p.Emit(EndOfMessageEvent{Recording: m.recFile, Timeout: timeout})
+	m.rec, m.recFile = nil, ""
+	return true
+}
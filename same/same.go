@@ -0,0 +1,219 @@
+// Package same decodes the Specific Area Message Encoding (SAME) alerts sent by NOAA Weather Radio
+// and the Emergency Alert System: the 520.83 baud AFSK header bursts, the 1050 Hz attention tone
+// and the end of message, optionally recording the message that follows the header.
+package same
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raff/kv4p-go/afsk"
+)
+
+var ErrInvalidHeader = fmt.Errorf("Invalid SAME header")
+
+const (
+	Baud      = 520.83
+	MarkFreq  = 2083.3 // Hz, binary 1
+	SpaceFreq = 1562.5 // Hz, binary 0
+
+	AttentionFreq = 1050 // Hz, NOAA Weather Radio attention tone
+
+	// EOM is the end of message, sent in place of the header after the message
+	EOM = "NNNN"
+
+	preamble    = 0xAB // sent 16 times before each burst
+	maxHeader   = 268  // characters, with 31 locations
+	burstGap    = time.Second
+	burstRepeat = 3
+)
+
+// Originators are the names of the originator codes
+var Originators = map[string]string{
+	"EAS": "Broadcast station or cable system",
+	"CIV": "Civil authorities",
+	"WXR": "National Weather Service",
+	"PEP": "Primary Entry Point System",
+}
+
+// Events are the names of the event codes
+var Events = map[string]string{
+	"ADR": "Administrative Message",
+	"AVA": "Avalanche Watch",
+	"AVW": "Avalanche Warning",
+	"BHW": "Biological Hazard Warning",
+	"BLU": "Blue Alert",
+	"BZW": "Blizzard Warning",
+	"CAE": "Child Abduction Emergency",
+	"CDW": "Civil Danger Warning",
+	"CEM": "Civil Emergency Message",
+	"CFA": "Coastal Flood Watch",
+	"CFW": "Coastal Flood Warning",
+	"CHW": "Chemical Hazard Warning",
+	"CWW": "Contaminated Water Warning",
+	"DBA": "Dam Watch",
+	"DBW": "Dam Break Warning",
+	"DEW": "Contagious Disease Warning",
+	"DMO": "Practice/Demo Warning",
+	"DSW": "Dust Storm Warning",
+	"EAN": "Emergency Action Notification",
+	"EAT": "Emergency Action Termination",
+	"EQW": "Earthquake Warning",
+	"EVA": "Evacuation Watch",
+	"EVI": "Evacuation Immediate",
+	"EWW": "Extreme Wind Warning",
+	"FCW": "Food Contamination Warning",
+	"FFA": "Flash Flood Watch",
+	"FFS": "Flash Flood Statement",
+	"FFW": "Flash Flood Warning",
+	"FLA": "Flood Watch",
+	"FLS": "Flood Statement",
+	"FLW": "Flood Warning",
+	"FRW": "Fire Warning",
+	"FSW": "Flash Freeze Warning",
+	"FZW": "Freeze Warning",
+	"HLS": "Hurricane Local Statement",
+	"HMW": "Hazardous Materials Warning",
+	"HUA": "Hurricane Watch",
+	"HUW": "Hurricane Warning",
+	"HWA": "High Wind Watch",
+	"HWW": "High Wind Warning",
+	"IBW": "Iceberg Warning",
+	"IFW": "Industrial Fire Warning",
+	"LAE": "Local Area Emergency",
+	"LEW": "Law Enforcement Warning",
+	"LSW": "Land Slide Warning",
+	"NAT": "National Audible Test",
+	"NIC": "National Information Center",
+	"NMN": "Network Message Notification",
+	"NPT": "National Periodic Test",
+	"NST": "National Silent Test",
+	"NUW": "Nuclear Power Plant Warning",
+	"POS": "Power Outage Statement",
+	"RHW": "Radiological Hazard Warning",
+	"RMT": "Required Monthly Test",
+	"RWT": "Required Weekly Test",
+	"SMW": "Special Marine Warning",
+	"SPS": "Special Weather Statement",
+	"SPW": "Shelter in Place Warning",
+	"SQW": "Snow Squall Warning",
+	"SSA": "Storm Surge Watch",
+	"SSW": "Storm Surge Warning",
+	"SVA": "Severe Thunderstorm Watch",
+	"SVR": "Severe Thunderstorm Warning",
+	"SVS": "Severe Weather Statement",
+	"TOA": "Tornado Watch",
+	"TOE": "911 Telephone Outage Emergency",
+	"TOR": "Tornado Warning",
+	"TRA": "Tropical Storm Watch",
+	"TRW": "Tropical Storm Warning",
+	"TSA": "Tsunami Watch",
+	"TSW": "Tsunami Warning",
+	"VOW": "Volcano Warning",
+	"WFA": "Wild Fire Watch",
+	"WFW": "Wild Fire Warning",
+	"WSA": "Winter Storm Watch",
+	"WSW": "Winter Storm Warning",
+}
+
+// Header is a SAME header: ZCZC-ORG-EEE-PSSCCC-PSSCCC+TTTT-JJJHHMM-LLLLLLLL-
+type Header struct {
+	Originator string        // ORG (see Originators)
+	Event      string        // EEE (see Events)
+	Locations  []string      // PSSCCC: county subdivision (P), state (SS) and county (CCC) FIPS codes
+	Duration   time.Duration // TTTT: how long the alert is valid
+	Issued     time.Time     // JJJHHMM: day of the year, hour and minute, UTC
+	Sender     string        // LLLLLLLL: station or office, i.e. "KEC61/NWS"
+}
+
+var headerRE = regexp.MustCompile(`^ZCZC-([A-Z]{3})-([A-Z0-9?]{3})-(\d{6}(?:-\d{6}){0,30})\+(\d{4})-(\d{7})-([^-+]{1,8})-`)
+
+// ParseHeader parses a header. Characters following the header are ignored.
+// The year of Issued is the current one (or the previous one, for headers from the end of the year received in January).
+func ParseHeader(s string) (*Header, error) {
+	return parseHeader(s, time.Now().UTC())
+}
+
+func parseHeader(s string, now time.Time) (*Header, error) {
+	m := headerRE.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrInvalidHeader
+	}
+
+	hours, _ := strconv.Atoi(m[4][:2])
+	minutes, _ := strconv.Atoi(m[4][2:])
+	day, _ := strconv.Atoi(m[5][:3])
+	hour, _ := strconv.Atoi(m[5][3:5])
+	minute, _ := strconv.Atoi(m[5][5:])
+	if minutes > 59 || day < 1 || day > 366 || hour > 23 || minute > 59 {
+		return nil, ErrInvalidHeader
+	}
+
+	issued := time.Date(now.Year(), 1, day, hour, minute, 0, 0, time.UTC)
+	if issued.Sub(now) > 24*time.Hour {
+		issued = time.Date(now.Year()-1, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	return &Header{
+		Originator: m[1],
+		Event:      m[2],
+		Locations:  strings.Split(m[3], "-"),
+		Duration:   time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute,
+		Issued:     issued,
+		Sender:     m[6],
+	}, nil
+}
+
+// String returns the header as it's transmitted
+func (h *Header) String() string {
+	minutes := int(h.Duration / time.Minute)
+	return fmt.Sprintf("ZCZC-%s-%s-%s+%02d%02d-%03d%s-%s-",
+		h.Originator, h.Event, strings.Join(h.Locations, "-"),
+		minutes/60, minutes%60, h.Issued.YearDay(), h.Issued.Format("1504"), h.Sender)
+}
+
+// OriginatorName returns the name of the originator, or its code if unknown
+func (h *Header) OriginatorName() string {
+	if name, ok := Originators[h.Originator]; ok {
+		return name
+	}
+	return h.Originator
+}
+
+// EventName returns the name of the event, or its code if unknown
+func (h *Header) EventName() string {
+	if name, ok := Events[h.Event]; ok {
+		return name
+	}
+	return h.Event
+}
+
+// Expires returns the time when the alert expires
+func (h *Header) Expires() time.Time {
+	return h.Issued.Add(h.Duration)
+}
+
+// Modulate returns the audio of a header (or EOM) transmission: three bursts, each followed by one second of silence.
+// It's meant for testing: the receivers also expect the attention tone and the message after the header.
+func Modulate(sampleRate int, header string) []int16 {
+	var marks []bool
+	for _, b := range append(bytes.Repeat([]byte{preamble}, 16), header...) {
+		for i := range 8 { // LSB first
+			marks = append(marks, b>>i&1 == 1)
+		}
+	}
+
+	burst := afsk.FSK(sampleRate, Baud, MarkFreq, SpaceFreq, afsk.DefaultAmplitude, marks)
+	silence := make([]int16, int(burstGap.Seconds()*float64(sampleRate)))
+
+	var out []int16
+	for range burstRepeat {
+		out = append(out, burst...)
+		out = append(out, silence...)
+	}
+	return out
+}
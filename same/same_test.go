@@ -0,0 +1,119 @@
+package same
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+const testHeader = "ZCZC-WXR-TOR-029037-029165+0030-2891745-KEAX/NWS-"
+
+func TestParseHeader(t *testing.T) {
+	now := time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)
+
+	h, err := parseHeader(testHeader+"garbage", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Originator != "WXR" || h.Event != "TOR" || h.Sender != "KEAX/NWS" || len(h.Locations) != 2 || h.Locations[1] != "029165" {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if h.EventName() != "Tornado Warning" || h.OriginatorName() != "National Weather Service" {
+		t.Errorf("unexpected names %q %q", h.EventName(), h.OriginatorName())
+	}
+	if expected := time.Date(2026, 10, 16, 17, 45, 0, 0, time.UTC); !h.Issued.Equal(expected) || h.Duration != 30*time.Minute {
+		t.Errorf("unexpected time %v %v", h.Issued, h.Duration)
+	}
+	if h.String() != testHeader {
+		t.Errorf("unexpected string %q", h)
+	}
+
+	// issued at the end of the previous year
+	h, err = parseHeader("ZCZC-CIV-RWT-000000+0015-3652359-WXL58   -", time.Date(2027, 1, 1, 0, 5, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Issued.Year() != 2026 || h.Sender != "WXL58   " {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	for _, s := range []string{
+		"",
+		"NNNN",
+		"ZCZC-WXR-TOR-02903+0030-2891745-KEAX/NWS-",
+		"ZCZC-WXR-TOR-029037+0090-2891745-KEAX/NWS-",
+		"ZCZC-WXR-TOR-029037+0030-2892545-KEAX/NWS-",
+		"ZCZC-WXR-TOR-029037+0030-2891745-KEAX/NWS",
+	} {
+		if _, err := parseHeader(s, now); err != ErrInvalidHeader {
+			t.Errorf("%q: expected error, got %v", s, err)
+		}
+	}
+}
+
+func tone(freq float64, d time.Duration) []int16 {
+	samples := make([]int16, int(d.Seconds()*kv4pht.AUDIO_SAMPLING_RATE))
+	for i := range samples {
+		samples[i] = int16(0.5 * math.MaxInt16 * math.Sin(2*math.Pi*freq*float64(i)/kv4pht.AUDIO_SAMPLING_RATE))
+	}
+	return samples
+}
+
+func TestDecoder(t *testing.T) {
+	var events []kv4pht.Event
+	d := NewDecoder(kv4pht.AUDIO_SAMPLING_RATE, func(ev kv4pht.Event) { events = append(events, ev) })
+
+	// header, attention tone, message, end of message
+	var audio []int16
+	audio = append(audio, Modulate(kv4pht.AUDIO_SAMPLING_RATE, testHeader)...)
+	audio = append(audio, tone(AttentionFreq, 8*time.Second)...)
+	audio = append(audio, tone(400, 5*time.Second)...)
+	audio = append(audio, Modulate(kv4pht.AUDIO_SAMPLING_RATE, EOM)...)
+	audio = append(audio, make([]int16, 4*kv4pht.AUDIO_SAMPLING_RATE)...)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := range audio {
+		audio[i] += int16(rnd.NormFloat64() * 0.05 * math.MaxInt16)
+	}
+
+	for i := 0; i < len(audio); i += kv4pht.OPUS_FRAME_SIZE {
+		d.WriteAudio(audio[i:min(len(audio), i+kv4pht.OPUS_FRAME_SIZE)])
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if ev, ok := events[0].(AlertEvent); !ok || ev.Header.String() != testHeader {
+		t.Errorf("unexpected alert %+v", events[0])
+	}
+	if ev, ok := events[1].(AttentionToneEvent); !ok || ev.Duration < 7*time.Second || ev.Duration > 9*time.Second {
+		t.Errorf("unexpected attention tone %+v", events[1])
+	}
+	if _, ok := events[2].(EndOfMessageEvent); !ok {
+		t.Errorf("unexpected end of message %+v", events[2])
+	}
+}
+
+func TestSingleBurst(t *testing.T) {
+	var events []kv4pht.Event
+	d := NewDecoder(kv4pht.AUDIO_SAMPLING_RATE, func(ev kv4pht.Event) { events = append(events, ev) })
+
+	// only the first burst: the alert is sent when the sequence is over
+	audio := Modulate(kv4pht.AUDIO_SAMPLING_RATE, testHeader)
+	d.WriteAudio(audio[:len(audio)/3])
+	if len(events) != 0 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	d.WriteAudio(make([]int16, 4*kv4pht.AUDIO_SAMPLING_RATE))
+	if len(events) != 1 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if ev, ok := events[0].(AlertEvent); !ok || ev.Header.Event != "TOR" {
+		t.Errorf("unexpected alert %+v", events[0])
+	}
+}
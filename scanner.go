@@ -0,0 +1,416 @@
+package kv4pht
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNothingToScan = fmt.Errorf("No channels to scan")
+	ErrInvalidScan   = fmt.Errorf("Invalid scan range")
+	ErrInvalidResume = fmt.Errorf("Invalid resume mode")
+	ErrScanTimeout   = fmt.Errorf("Timeout waiting for scan result")
+)
+
+const (
+	scanReportTimeout = 2 * time.Second        // how long to wait for an S-meter report after the dwell time
+	scanHoldInterval  = 100 * time.Millisecond // how often the hang time is checked while holding
+)
+
+// ResumeMode selects what the Scanner does after a hit
+type ResumeMode int
+
+const (
+	ResumeCarrier ResumeMode = iota // resume when the signal has been gone for Hang
+	ResumeTimed                     // resume after Hold, or when the signal has been gone for Hang
+	ResumeStop                      // stop scanning at the first hit
+)
+
+func (m ResumeMode) String() string {
+	switch m {
+	case ResumeCarrier:
+		return "carrier"
+	case ResumeTimed:
+		return "timed"
+	case ResumeStop:
+		return "stop"
+	}
+	return fmt.Sprintf("ResumeMode(%d)", int(m))
+}
+
+// ParseResumeMode parses a resume mode name (carrier, timed, stop)
+func ParseResumeMode(s string) (ResumeMode, error) {
+	for _, m := range []ResumeMode{ResumeCarrier, ResumeTimed, ResumeStop} {
+		if strings.EqualFold(s, m.String()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidResume, s)
+}
+
+// ScanChannel is a channel visited by the Scanner
+type ScanChannel struct {
+	Name string // optional
+	GroupOptions
+}
+
+// ScanConfig configures a Scanner.
+// Zero values are replaced by the defaults.
+type ScanConfig struct {
+	// Range scan, from Start to End (MHz) in increments of Step (default 25 kHz)
+	Start, End, Step float64
+	Bandwidth        int // bandwidth of the range channels (DRA818_25K or DRA818_12K5)
+	Squelch          int // squelch of the range channels
+
+	// Memory scan: if not empty these channels are scanned instead of the range
+	Channels []ScanChannel
+
+	// Priority, if set, is checked every PriorityInterval (default 2s), also while holding on a hit
+	Priority         *ScanChannel
+	PriorityInterval time.Duration
+
+	// CarrierDetect uses the carrier detection of the radio module (SendScan) instead of the S-meter:
+	// the channels are only tuned to when a carrier is found. The squelch must be > 0.
+	CarrierDetect bool
+
+	Threshold int           // S-meter value (1-9) that counts as a hit (default 4)
+	Dwell     time.Duration // time spent listening on each channel (default 250ms)
+	Hang      time.Duration // time without signal before resuming (default 2s)
+	Hold      time.Duration // maximum time on a hit with ResumeTimed (default 5s)
+	Resume    ResumeMode
+	Passes    int       // number of passes (0: scan until the context is done)
+	Skip      []float64 // frequencies (MHz) to skip
+}
+
+// Scanner looks for active channels, by tuning to each channel and checking the S-meter,
+// or with the carrier detection of the radio module.
+// Hits are reported as ScanHitEvent.
+type Scanner struct {
+	ScanConfig
+
+	p        *CommandProcessor
+	channels []ScanChannel
+
+	mu   sync.Mutex
+	skip map[int64]bool
+}
+
+// NewScanner returns a Scanner for the CommandProcessor. The radio should be configured (SendConfig) before scanning.
+func NewScanner(p *CommandProcessor, config ScanConfig) (*Scanner, error) {
+	if config.Step == 0 {
+		config.Step = 0.025
+	}
+	if config.Threshold == 0 {
+		config.Threshold = 4
+	}
+	if config.Dwell == 0 {
+		config.Dwell = 250 * time.Millisecond
+	}
+	if config.Hang == 0 {
+		config.Hang = 2 * time.Second
+	}
+	if config.Hold == 0 {
+		config.Hold = 5 * time.Second
+	}
+	if config.PriorityInterval == 0 {
+		config.PriorityInterval = 2 * time.Second
+	}
+
+	s := &Scanner{ScanConfig: config, p: p, skip: map[int64]bool{}}
+
+	if len(config.Channels) > 0 {
+		s.channels = config.Channels
+	} else {
+		if config.Start <= 0 || config.End < config.Start || config.Step < 0 {
+			return nil, fmt.Errorf("%w: %.4f-%.4f step %.4f", ErrInvalidScan, config.Start, config.End, config.Step)
+		}
+
+		n := int(math.Round((config.End - config.Start) / config.Step))
+		for i := 0; i <= n; i++ {
+			f := math.Round((config.Start+float64(i)*config.Step)*1e6) / 1e6
+			if f > config.End {
+				break
+			}
+
+			s.channels = append(s.channels, ScanChannel{GroupOptions: GroupOptions{
+				Bandwidth: config.Bandwidth,
+				TxFreq:    f,
+				RxFreq:    f,
+				Squelch:   config.Squelch,
+			}})
+		}
+	}
+
+	for _, f := range config.Skip {
+		s.SkipFrequency(f)
+	}
+
+	return s, nil
+}
+
+// Channels returns the channels scanned, in order
+func (s *Scanner) Channels() []ScanChannel {
+	return s.channels
+}
+
+func skipKey(f float64) int64 {
+	return int64(math.Round(f * 1e6))
+}
+
+// SkipFrequency adds a frequency to the skip list. If the scanner is holding on it, it resumes scanning.
+func (s *Scanner) SkipFrequency(f float64) {
+	s.mu.Lock()
+	s.skip[skipKey(f)] = true
+	s.mu.Unlock()
+}
+
+// UnskipFrequency removes a frequency from the skip list
+func (s *Scanner) UnskipFrequency(f float64) {
+	s.mu.Lock()
+	delete(s.skip, skipKey(f))
+	s.mu.Unlock()
+}
+
+// Skipped returns true if the frequency is in the skip list
+func (s *Scanner) Skipped(f float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skip[skipKey(f)]
+}
+
+// Run scans until the context is done, the requested number of passes is completed
+// or, with ResumeStop, there is a hit. In that case the hit is returned and the radio stays tuned to its channel.
+func (s *Scanner) Run(ctx context.Context) (*ScanHitEvent, error) {
+	events, cancel := s.p.Subscribe(256)
+	defer cancel()
+
+	lastPriority := time.Now()
+
+	for pass := 0; s.Passes == 0 || pass < s.Passes; pass++ {
+		scanned := 0
+
+		for _, ch := range s.channels {
+			if s.Skipped(ch.RxFreq) {
+				continue
+			}
+
+			scanned++
+
+			if s.Priority != nil && time.Since(lastPriority) >= s.PriorityInterval {
+				lastPriority = time.Now()
+
+				hit, err := s.visit(ctx, events, *s.Priority, true)
+				if hit != nil || err != nil {
+					return hit, err
+				}
+			}
+
+			hit, err := s.visit(ctx, events, ch, false)
+			if hit != nil || err != nil {
+				return hit, err
+			}
+		}
+
+		if scanned == 0 {
+			return nil, ErrNothingToScan
+		}
+	}
+
+	return nil, nil
+}
+
+// visit checks a channel and holds on it if active.
+// It only returns a hit if the scan should stop.
+func (s *Scanner) visit(ctx context.Context, events <-chan Event, ch ScanChannel, priority bool) (*ScanHitEvent, error) {
+	hit, err := s.check(ctx, events, ch, priority)
+	if hit == nil || err != nil {
+		return nil, err
+	}
+
+	if s.Resume == ResumeStop {
+		return hit, nil
+	}
+
+	return nil, s.hold(ctx, events, ch, priority)
+}
+
+// check tunes to a channel and listens for Dwell
+func (s *Scanner) check(ctx context.Context, events <-chan Event, ch ScanChannel, priority bool) (*ScanHitEvent, error) {
+	var active bool
+	var smeter int
+	var err error
+
+	if s.CarrierDetect {
+		if active, err = s.detect(ctx, events, ch.RxFreq, s.Dwell); active && err == nil {
+			err = s.tune(ch, events)
+		}
+	} else {
+		if err := s.tune(ch, events); err != nil {
+			return nil, err
+		}
+		active, smeter, err = s.listen(ctx, events, s.Dwell, true)
+	}
+	if !active || err != nil {
+		return nil, err
+	}
+
+	hit := &ScanHitEvent{Channel: ch, SMeter: smeter, Priority: priority}
+	if Debug {
+		log.Printf("Scan hit: %.4f MHz S%d", ch.RxFreq, smeter)
+	}
+	s.p.emit(*hit)
+	return hit, nil
+}
+
+// hold stays on an active channel until it's time to resume, checking the priority channel
+func (s *Scanner) hold(ctx context.Context, events <-chan Event, ch ScanChannel, priority bool) error {
+	start := time.Now()
+	last, lastPriority := start, start
+
+	for {
+		now := time.Now()
+		if now.Sub(last) >= s.Hang || (s.Resume == ResumeTimed && now.Sub(start) >= s.Hold) || s.Skipped(ch.RxFreq) {
+			return nil
+		}
+
+		if !priority && s.Priority != nil && now.Sub(lastPriority) >= s.PriorityInterval {
+			lastPriority = now
+
+			hit, err := s.check(ctx, events, *s.Priority, true)
+			if err != nil {
+				return err
+			}
+			if hit != nil {
+				ch, priority = *s.Priority, true
+				start, last = time.Now(), time.Now()
+				continue
+			}
+			if err := s.tune(ch, events); err != nil {
+				return err
+			}
+		}
+
+		var active bool
+		var err error
+
+		if s.CarrierDetect {
+			if active, err = s.detect(ctx, events, ch.RxFreq, scanHoldInterval); err == nil {
+				err = sleepContext(ctx, scanHoldInterval)
+			}
+		} else {
+			active, _, err = s.listen(ctx, events, scanHoldInterval, false)
+		}
+		if err != nil {
+			return err
+		}
+		if active {
+			last = time.Now()
+		}
+	}
+}
+
+// tune sends the channel settings and discards the pending events
+func (s *Scanner) tune(ch ScanChannel, events <-chan Event) error {
+	if err := s.p.SendGroupOptions(ch.GroupOptions); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-events:
+		default:
+			return nil
+		}
+	}
+}
+
+// listen waits for the S-meter to reach the threshold, for the specified time.
+// If waitReport is true and no S-meter report was received, it waits a little longer for one.
+func (s *Scanner) listen(ctx context.Context, events <-chan Event, d time.Duration, waitReport bool) (active bool, peak int, err error) {
+	deadline := time.NewTimer(d)
+	defer deadline.Stop()
+
+	timeout := time.NewTimer(d + scanReportTimeout)
+	defer timeout.Stop()
+
+	reports := 0
+	elapsed := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, peak, ctx.Err()
+
+		case ev, ok := <-events:
+			if !ok {
+				return false, peak, ErrStopped
+			}
+
+			if ev, ok := ev.(SMeterEvent); ok {
+				reports++
+				peak = max(peak, ev.Value)
+				if ev.Value >= s.Threshold {
+					return true, peak, nil
+				}
+				if elapsed {
+					return false, peak, nil
+				}
+			}
+
+		case <-deadline.C:
+			if reports > 0 || !waitReport {
+				return false, peak, nil
+			}
+			elapsed = true
+
+		case <-timeout.C:
+			return false, peak, nil
+		}
+	}
+}
+
+// detect asks the radio module to check for a carrier on freq
+func (s *Scanner) detect(ctx context.Context, events <-chan Event, freq float64, d time.Duration) (bool, error) {
+	if err := s.p.SendScan(freq); err != nil {
+		return false, err
+	}
+
+	timeout := time.NewTimer(d + scanReportTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+
+		case ev, ok := <-events:
+			if !ok {
+				return false, ErrStopped
+			}
+
+			if ev, ok := ev.(ScanResultEvent); ok && float32(ev.Freq) == float32(freq) {
+				return ev.Found(), nil
+			}
+
+		case <-timeout.C:
+			return false, fmt.Errorf("%w: %.4f MHz", ErrScanTimeout, freq)
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
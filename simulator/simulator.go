@@ -0,0 +1,485 @@
+// Package simulator implements a software kv4p HT device, speaking the device side of the protocol.
+//
+// It can be connected to a CommandProcessor with kv4pht.Pipe, or served over TCP and used
+// with the "tcp://host:port" device name.
+package simulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"gopkg.in/hraban/opus.v2"
+)
+
+var cmd_prefix = []byte{0xDE, 0xAD, 0xBE, 0xEF}
+
+// Carrier is a simulated station transmitting on a frequency
+type Carrier struct {
+	Freq   float64 // MHz
+	Level  int     // raw S-meter value (0-255)
+	Source Source  // transmitted audio (nil for silence)
+}
+
+// Group are the radio settings received with CMD_GROUP
+type Group struct {
+	Bandwidth byte
+	TxFreq    float64
+	RxFreq    float64
+	TxTone    byte
+	Squelch   byte
+	RxTone    byte
+}
+
+// Device is a simulated kv4p HT board.
+// The configuration fields should be set before calling Run or Serve.
+type Device struct {
+	Version    uint16
+	RadioFound bool // if false the device reports the radio module as missing
+	HwVersion  byte
+	WindowSize int
+
+	NoiseLevel     int           // raw S-meter value when no carrier is received
+	Noise          Source        // audio received when no carrier is present and the squelch is open (nil for silence)
+	Carriers       []Carrier     // the simulated stations
+	SMeterInterval time.Duration // how often S-meter reports are sent
+
+	// TxAudio, if set, is called with the decoded audio transmitted by the host
+	TxAudio func(samples []int16)
+
+	mu        sync.Mutex
+	out       chan []byte   // frames to send to the host
+	done      chan struct{} // closed when Run returns
+	running   bool          // receiving, between CMD_GROUP and CMD_STOP
+	mode      byte
+	filters   byte
+	group     Group
+	ptt       bool
+	credits   int // bytes we can send to the host
+	received  int // bytes received and not acknowledged yet
+	txFrames  int
+	dropped   int
+	commands  []byte
+	encoder   *opus.Encoder
+	decoder   *opus.Decoder
+	lastMeter time.Time
+}
+
+// New returns a Device with default settings
+func New() *Device {
+	return &Device{
+		Version:        13,
+		RadioFound:     true,
+		HwVersion:      0x01,
+		WindowSize:     1024,
+		NoiseLevel:     20,
+		SMeterInterval: 500 * time.Millisecond,
+	}
+}
+
+// Group returns the last settings received with CMD_GROUP
+func (d *Device) Group() Group {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.group
+}
+
+// Mode returns the mode received with CMD_CONFIG
+func (d *Device) Mode() byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mode
+}
+
+// Filters returns the filters received with CMD_FILTERS
+func (d *Device) Filters() byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.filters
+}
+
+// Transmitting returns true between CMD_PTT_DOWN and CMD_PTT_UP
+func (d *Device) Transmitting() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ptt
+}
+
+// TxFrames returns the number of CMD_TX_AUDIO frames received
+func (d *Device) TxFrames() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.txFrames
+}
+
+// Commands returns the codes of the commands received so far, in order
+func (d *Device) Commands() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return bytes.Clone(d.commands)
+}
+
+// Serve accepts connections from the listener and runs the device on each one of them, in sequence.
+func (d *Device) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		log.Println("Connection from", conn.RemoteAddr())
+		if err := d.Run(conn); err != nil {
+			log.Println("Run:", err)
+		}
+		conn.Close()
+	}
+}
+
+// Run sends RES_HELLO and processes the commands received from conn, until the connection is closed.
+func (d *Device) Run(conn io.ReadWriter) error {
+	var err error
+
+	done := make(chan struct{})
+	defer close(done)
+
+	d.mu.Lock()
+	d.out = make(chan []byte, 256)
+	d.done = done
+	d.running = false
+	d.ptt = false
+	d.credits = d.WindowSize
+	d.received = 0
+	if d.encoder == nil {
+		if d.encoder, err = opus.NewEncoder(kv4pht.AUDIO_SAMPLING_RATE, 1, opus.AppVoIP); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+		if d.decoder, err = opus.NewDecoder(kv4pht.AUDIO_SAMPLING_RATE, 1); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+	}
+	d.mu.Unlock()
+
+	// the frames are written by a separate goroutine, so that we never stop reading
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case frame := <-d.out:
+				if _, err := conn.Write(frame); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	d.send(kv4pht.RES_HELLO, nil, true)
+
+	go d.rxLoop(done)
+
+	r := bufio.NewReader(conn)
+	for {
+		cmd, params, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		d.processCommand(cmd, params)
+	}
+}
+
+// readFrame reads the next command, skipping anything before the prefix
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	for state := 0; state < len(cmd_prefix); {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch {
+		case b == cmd_prefix[state]:
+			state++
+		case b == cmd_prefix[0]:
+			state = 1
+		default:
+			state = 0
+		}
+	}
+
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	params := make([]byte, binary.LittleEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, params); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], params, nil
+}
+
+func (d *Device) processCommand(cmd byte, params []byte) {
+	d.mu.Lock()
+	d.commands = append(d.commands, cmd)
+	d.received += len(cmd_prefix) + 3 + len(params)
+	d.mu.Unlock()
+
+	switch cmd {
+	case kv4pht.CMD_STOP:
+		d.mu.Lock()
+		d.running = false
+		d.ptt = false
+		d.mu.Unlock()
+
+	case kv4pht.CMD_CONFIG:
+		if len(params) != 1 {
+			d.debug(kv4pht.RES_DEBUG_ERROR, "invalid CONFIG")
+			break
+		}
+
+		d.mu.Lock()
+		d.mode = params[0]
+		d.mu.Unlock()
+
+		var version [8]byte
+		binary.LittleEndian.PutUint16(version[0:], d.Version)
+		version[2] = 'x'
+		if d.RadioFound {
+			version[2] = 'f'
+		}
+		version[3] = d.HwVersion
+		binary.LittleEndian.PutUint32(version[4:], uint32(d.WindowSize))
+		d.send(kv4pht.RES_VERSION, version[:], true)
+
+	case kv4pht.CMD_FILTERS:
+		if len(params) != 1 {
+			d.debug(kv4pht.RES_DEBUG_ERROR, "invalid FILTERS")
+			break
+		}
+
+		d.mu.Lock()
+		d.filters = params[0]
+		d.mu.Unlock()
+
+	case kv4pht.CMD_GROUP:
+		if len(params) != 12 {
+			d.debug(kv4pht.RES_DEBUG_ERROR, "invalid GROUP")
+			break
+		}
+
+		d.mu.Lock()
+		d.group = Group{
+			Bandwidth: params[0],
+			TxFreq:    float64(math.Float32frombits(binary.LittleEndian.Uint32(params[1:]))),
+			RxFreq:    float64(math.Float32frombits(binary.LittleEndian.Uint32(params[5:]))),
+			TxTone:    params[9],
+			Squelch:   params[10],
+			RxTone:    params[11],
+		}
+		d.running = true
+		d.mu.Unlock()
+
+	case kv4pht.CMD_SCAN:
+		if len(params) != 4 {
+			d.debug(kv4pht.RES_DEBUG_ERROR, "invalid SCAN")
+			break
+		}
+
+		freq := math.Float32frombits(binary.LittleEndian.Uint32(params))
+
+		result := []byte{kv4pht.SCAN_NOT_FOUND}
+		d.mu.Lock()
+		if d.carrierAt(float64(freq)) != nil {
+			result[0] = kv4pht.SCAN_FOUND
+		}
+		d.mu.Unlock()
+
+		d.send(kv4pht.RES_SCAN_RESULT, append(result, params...), true)
+
+	case kv4pht.CMD_PTT_DOWN:
+		d.mu.Lock()
+		d.ptt = true
+		d.mu.Unlock()
+
+	case kv4pht.CMD_PTT_UP:
+		d.mu.Lock()
+		d.ptt = false
+		d.mu.Unlock()
+
+	case kv4pht.CMD_TX_AUDIO:
+		d.mu.Lock()
+		if !d.ptt {
+			d.mu.Unlock()
+			d.debug(kv4pht.RES_DEBUG_WARNING, "TX audio while not transmitting")
+			break
+		}
+
+		var out [kv4pht.OPUS_FRAME_SIZE]int16
+		n, err := d.decoder.Decode(params, out[:])
+		d.txFrames++
+		d.mu.Unlock()
+
+		if err != nil {
+			d.debug(kv4pht.RES_DEBUG_ERROR, "TX audio: "+err.Error())
+		} else if d.TxAudio != nil {
+			d.TxAudio(out[:n])
+		}
+
+	case kv4pht.CMD_WINDOW_UPDATE:
+		if len(params) != 4 {
+			d.debug(kv4pht.RES_DEBUG_ERROR, "invalid WINDOW_UPDATE")
+			break
+		}
+
+		d.mu.Lock()
+		d.credits += int(binary.LittleEndian.Uint32(params))
+		d.mu.Unlock()
+
+	default:
+		d.debug(kv4pht.RES_DEBUG_WARNING, "unknown command")
+	}
+
+	d.acknowledge(false)
+}
+
+// acknowledge returns the received bytes to the host as window credits,
+// when more than half the window has been used or when forced (and there is something to return).
+func (d *Device) acknowledge(force bool) {
+	d.mu.Lock()
+	n := d.received
+	if n == 0 || (!force && n < d.WindowSize/2) {
+		d.mu.Unlock()
+		return
+	}
+	d.received = 0
+	d.mu.Unlock()
+
+	var params [4]byte
+	binary.LittleEndian.PutUint32(params[:], uint32(n))
+	d.send(kv4pht.RES_WINDOW_UPDATE, params[:], true)
+}
+
+// carrier returns the carrier received on the current frequency, if any
+// (must be called with the lock held)
+func (d *Device) carrier() *Carrier {
+	return d.carrierAt(d.group.RxFreq)
+}
+
+// carrierAt returns the carrier received on freq, with the current bandwidth
+func (d *Device) carrierAt(freq float64) *Carrier {
+	bw := 0.00625
+	if d.group.Bandwidth == kv4pht.DRA818_25K {
+		bw = 0.0125
+	}
+
+	for i, c := range d.Carriers {
+		if math.Abs(c.Freq-freq) < bw {
+			return &d.Carriers[i]
+		}
+	}
+
+	return nil
+}
+
+// rxLoop generates S-meter reports and received audio while the radio is in receive mode
+func (d *Device) rxLoop(done chan struct{}) {
+	frameTime := time.Second * kv4pht.OPUS_FRAME_SIZE / kv4pht.AUDIO_SAMPLING_RATE
+	ticker := time.NewTicker(frameTime)
+	defer ticker.Stop()
+
+	var samples [kv4pht.OPUS_FRAME_SIZE]int16
+	var packet [kv4pht.OPUS_MAX_PACKET]byte
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		d.acknowledge(true)
+
+		d.mu.Lock()
+		if !d.running || d.ptt {
+			d.mu.Unlock()
+			continue
+		}
+
+		level, source := d.NoiseLevel, d.Noise
+		if c := d.carrier(); c != nil {
+			level, source = c.Level, c.Source
+		} else if d.group.Squelch > 0 {
+			source = nil
+		}
+
+		sendMeter := time.Since(d.lastMeter) >= d.SMeterInterval
+		if sendMeter {
+			d.lastMeter = time.Now()
+		}
+
+		open := source != nil || d.group.Squelch == 0
+		n := 0
+		if open {
+			if source != nil {
+				source.Read(samples[:])
+			} else {
+				clear(samples[:])
+			}
+
+			var err error
+			if n, err = d.encoder.Encode(samples[:], packet[:]); err != nil {
+				log.Println("Encode:", err)
+				open = false
+			}
+		}
+		d.mu.Unlock()
+
+		if sendMeter {
+			d.send(kv4pht.RES_SMETER_REPORT, []byte{byte(max(0, min(255, level)))}, true)
+		}
+		if open {
+			d.send(kv4pht.RES_RX_AUDIO, packet[:n], false)
+		}
+	}
+}
+
+// debug sends a debug message to the host
+func (d *Device) debug(level byte, msg string) {
+	d.send(level, []byte(msg), true)
+}
+
+// send queues a response for the host, if there are enough window credits.
+// Frames that are not required are dropped when the window is full, like the firmware does with audio.
+func (d *Device) send(cmd byte, params []byte, required bool) {
+	frame := append([]byte{}, cmd_prefix...)
+	frame = append(frame, cmd, byte(len(params)), byte(len(params)>>8))
+	frame = append(frame, params...)
+
+	d.mu.Lock()
+	if !required && d.credits < len(frame) {
+		d.dropped++
+		d.mu.Unlock()
+		return
+	}
+	d.credits -= len(frame)
+	out, done := d.out, d.done
+	d.mu.Unlock()
+
+	select {
+	case out <- frame:
+	case <-done:
+	}
+}
@@ -0,0 +1,199 @@
+package simulator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+func startDevice(t *testing.T, d *Device) *kv4pht.CommandProcessor {
+	t.Helper()
+
+	host, device := kv4pht.Pipe()
+	go d.Run(device)
+
+	p, err := kv4pht.NewCommandProcessor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.Stop()
+		device.Close()
+	})
+
+	waitFor(t, "HELLO", p.Hello)
+	return p
+}
+
+func TestSession(t *testing.T) {
+	d := New()
+	d.SMeterInterval = 40 * time.Millisecond
+	d.Carriers = []Carrier{
+		{Freq: 146.52, Level: 200, Source: Tone(1000, 0.5)},
+	}
+
+	var mu sync.Mutex
+	var txSamples int
+	d.TxAudio = func(samples []int16) {
+		mu.Lock()
+		txSamples += len(samples)
+		mu.Unlock()
+	}
+
+	p := startDevice(t, d)
+	events, cancel := p.Subscribe(64)
+	defer cancel()
+
+	if err := p.SendConfig(kv4pht.MODE_VHF); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "VERSION", func() bool { v, _, _ := p.Version(); return v == d.Version })
+
+	if err := p.SendFilters(true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendGroup(kv4pht.DRA818_25K, 146.52, 146.52, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotMeter, gotAudio bool
+	for timeout := time.After(3 * time.Second); !gotMeter || !gotAudio; {
+		select {
+		case ev := <-events:
+			switch ev := ev.(type) {
+			case kv4pht.SMeterEvent:
+				if ev.Raw != 200 {
+					t.Fatalf("expected S-meter 200, got %d", ev.Raw)
+				}
+				gotMeter = true
+			case kv4pht.RxAudioEvent:
+				gotAudio = len(ev.Samples) == kv4pht.OPUS_FRAME_SIZE
+			}
+		case <-timeout:
+			t.Fatal("no S-meter or audio received")
+		}
+	}
+
+	if d.Mode() != kv4pht.MODE_VHF {
+		t.Errorf("expected VHF mode, got %02x", d.Mode())
+	}
+	if d.Filters() != kv4pht.FILTERS_PRE|kv4pht.FILTERS_LOW {
+		t.Errorf("unexpected filters %02x", d.Filters())
+	}
+	if g := d.Group(); g.RxFreq != float64(float32(146.52)) || g.Squelch != 1 {
+		t.Errorf("unexpected group %#v", g)
+	}
+
+	if err := p.SendPTTDown(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "PTT", d.Transmitting)
+
+	for range 5 {
+		if _, err := p.WriteAudio(make([]int16, kv4pht.OPUS_FRAME_SIZE)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.SendPTTUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "TX audio", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return txSamples == 5*kv4pht.OPUS_FRAME_SIZE
+	})
+	waitFor(t, "PTT up", func() bool { return !d.Transmitting() })
+}
+
+func TestSquelch(t *testing.T) {
+	d := New()
+	d.SMeterInterval = 40 * time.Millisecond
+
+	p := startDevice(t, d)
+	events, cancel := p.Subscribe(64)
+	defer cancel()
+
+	if err := p.SendGroup(kv4pht.DRA818_25K, 146.52, 146.52, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	meters := 0
+	for timeout := time.After(500 * time.Millisecond); meters < 3; {
+		select {
+		case ev := <-events:
+			switch ev := ev.(type) {
+			case kv4pht.SMeterEvent:
+				if ev.Raw != d.NoiseLevel {
+					t.Fatalf("expected S-meter %d, got %d", d.NoiseLevel, ev.Raw)
+				}
+				meters++
+			case kv4pht.RxAudioEvent:
+				t.Fatal("audio received with closed squelch")
+			}
+		case <-timeout:
+			t.Fatal("no S-meter received")
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	d := New()
+	d.Carriers = []Carrier{
+		{Freq: 146.52, Level: 200, Source: Tone(1000, 0.5)},
+	}
+
+	p := startDevice(t, d)
+
+	if err := p.SendGroup(kv4pht.DRA818_25K, 146.4, 146.4, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []float64{146.5, 146.52} {
+		if err := p.SendScan(f); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "SCAN result", func() bool { return p.Scanned() != kv4pht.SCAN_WAITING })
+		if found := p.Scanned()&kv4pht.SCAN_FOUND != 0; found != (f == 146.52) {
+			t.Errorf("%v: unexpected scan result %02x", f, p.Scanned())
+		}
+	}
+
+	s, err := kv4pht.NewScanner(p, kv4pht.ScanConfig{
+		Start:         146.4,
+		End:           146.6,
+		Squelch:       4,
+		CarrierDetect: true,
+		Resume:        kv4pht.ResumeStop,
+		Passes:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hit, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit == nil || hit.Channel.RxFreq != 146.525 {
+		t.Fatalf("unexpected hit %+v", hit)
+	}
+	if g := d.Group(); g.RxFreq != float64(float32(hit.Channel.RxFreq)) {
+		t.Errorf("not tuned to the hit: %v", g.RxFreq)
+	}
+}
@@ -0,0 +1,111 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"os"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/dsp"
+	"github.com/raff/kv4p-go/wav"
+)
+
+// Source generates the received audio, as 16-bit mono samples at kv4pht.AUDIO_SAMPLING_RATE
+type Source interface {
+	Read(samples []int16)
+}
+
+type toneSource struct {
+	step      float64
+	amplitude float64
+	phase     float64
+}
+
+// Tone returns a sine wave at the specified frequency (in Hz) and amplitude (0-1)
+func Tone(freq, amplitude float64) Source {
+	return &toneSource{
+		step:      2 * math.Pi * freq / kv4pht.AUDIO_SAMPLING_RATE,
+		amplitude: amplitude * math.MaxInt16,
+	}
+}
+
+func (s *toneSource) Read(samples []int16) {
+	for i := range samples {
+		samples[i] = int16(s.amplitude * math.Sin(s.phase))
+		s.phase = math.Mod(s.phase+s.step, 2*math.Pi)
+	}
+}
+
+type noiseSource struct {
+	amplitude float64
+	rnd       *rand.Rand
+}
+
+// Noise returns white noise with the specified amplitude (0-1)
+func Noise(amplitude float64) Source {
+	return &noiseSource{
+		amplitude: amplitude * math.MaxInt16,
+		rnd:       rand.New(rand.NewSource(1)),
+	}
+}
+
+func (s *noiseSource) Read(samples []int16) {
+	for i := range samples {
+		samples[i] = int16(s.amplitude * (2*s.rnd.Float64() - 1))
+	}
+}
+
+// Silence returns a source of silence
+func Silence() Source {
+	return silenceSource{}
+}
+
+type silenceSource struct{}
+
+func (silenceSource) Read(samples []int16) {
+	clear(samples)
+}
+
+type samplesSource struct {
+	samples []int16
+	pos     int
+}
+
+// Samples returns a source that plays the specified samples in a loop
+func Samples(samples []int16) Source {
+	if len(samples) == 0 {
+		return Silence()
+	}
+
+	return &samplesSource{samples: samples}
+}
+
+func (s *samplesSource) Read(samples []int16) {
+	for i := range samples {
+		samples[i] = s.samples[s.pos]
+		s.pos = (s.pos + 1) % len(s.samples)
+	}
+}
+
+// WAVFile returns a source that plays the content of a 16-bit PCM WAV file in a loop.
+// The audio is resampled to kv4pht.AUDIO_SAMPLING_RATE and multiple channels are mixed down to mono.
+func WAVFile(path string) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := wav.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	samples = dsp.Mono(samples, r.Channels)
+	return Samples(dsp.Resample(samples, r.SampleRate, kv4pht.AUDIO_SAMPLING_RATE)), nil
+}
@@ -0,0 +1,191 @@
+package kv4pht
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/raff/kv4p-go/wav"
+)
+
+// AudioSink receives the decoded RX audio, as 16-bit mono samples at AUDIO_SAMPLING_RATE.
+//
+// WriteAudio is called from the reader goroutine, so it should not block.
+// Sinks that also implement io.Closer are closed by Stop.
+type AudioSink interface {
+	WriteAudio(samples []int16) error
+}
+
+// VolumeSetter is implemented by sinks that support volume control (see CommandProcessor.SetVolume)
+type VolumeSetter interface {
+	SetVolume(volume float64)
+}
+
+// Option configures a CommandProcessor created with Start or NewCommandProcessor
+type Option func(*CommandProcessor)
+
+// WithAudioSink adds a sink for the received audio. It can be specified multiple times.
+func WithAudioSink(s AudioSink) Option {
+	return func(p *CommandProcessor) {
+		p.sinks = append(p.sinks, &sinkEntry{s})
+	}
+}
+
+// sinkEntry wraps an AudioSink so that it can be removed even if its type is not comparable (i.e. SinkFunc)
+type sinkEntry struct {
+	AudioSink
+}
+
+// AddSink adds a sink for the received audio to a running CommandProcessor.
+// Call the returned function to remove it (the sink is not closed).
+//
+// Unlike the RxAudioEvents sent to the subscribers, that are dropped when a subscriber falls behind,
+// the sinks receive all the audio.
+func (p *CommandProcessor) AddSink(s AudioSink) (remove func()) {
+	entry := &sinkEntry{s}
+
+	p.mu.Lock()
+	p.sinks = append(p.sinks, entry)
+	p.mu.Unlock()
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		for i, e := range p.sinks {
+			if e == entry {
+				p.sinks = append(p.sinks[:i:i], p.sinks[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// SetVolume sets the volume (0-1) of all the sinks that support it
+func (p *CommandProcessor) SetVolume(volume float64) {
+	for _, s := range p.audioSinks() {
+		if v, ok := s.AudioSink.(VolumeSetter); ok {
+			v.SetVolume(volume)
+		}
+	}
+}
+
+func (p *CommandProcessor) audioSinks() []*sinkEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sinks
+}
+
+func (p *CommandProcessor) closeSinks() {
+	p.mu.Lock()
+	sinks := p.sinks
+	p.sinks = nil
+	p.mu.Unlock()
+
+	for _, s := range sinks {
+		if c, ok := s.AudioSink.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+type nullSink struct{}
+
+func (nullSink) WriteAudio([]int16) error { return nil }
+
+// NullSink returns a sink that discards the audio
+func NullSink() AudioSink {
+	return nullSink{}
+}
+
+// SinkFunc is a function called with the received audio
+type SinkFunc func(samples []int16)
+
+func (f SinkFunc) WriteAudio(samples []int16) error {
+	f(samples)
+	return nil
+}
+
+// PacketSinkFunc is a function called with the received audio and the Opus packet it was decoded from.
+// When used as a plain AudioSink (outside of a CommandProcessor) the packet is nil.
+type PacketSinkFunc func(packet []byte, samples []int16)
+
+func (f PacketSinkFunc) WriteAudio(samples []int16) error {
+	f(nil, samples)
+	return nil
+}
+
+type pcmSink struct {
+	w io.Writer
+}
+
+// PCMSink returns a sink that writes the audio to w as raw 16-bit little endian samples
+func PCMSink(w io.Writer) AudioSink {
+	return pcmSink{w: w}
+}
+
+func (s pcmSink) WriteAudio(samples []int16) error {
+	return binary.Write(s.w, binary.LittleEndian, samples)
+}
+
+// WAVSink writes the audio to a WAV file
+type WAVSink struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *wav.Writer
+	samples int
+}
+
+// CreateWAV creates a WAV file and returns a sink that writes the audio to it.
+// The file is finalized when the sink is closed.
+func CreateWAV(path string) (*WAVSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := wav.NewWriter(f, AUDIO_SAMPLING_RATE, 1)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &WAVSink{f: f, w: w}, nil
+}
+
+func (s *WAVSink) WriteAudio(samples []int16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w == nil {
+		return os.ErrClosed
+	}
+
+	n, err := s.w.Write(samples)
+	s.samples += n
+	return err
+}
+
+// Samples returns the number of samples written so far
+func (s *WAVSink) Samples() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.samples
+}
+
+func (s *WAVSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w == nil {
+		return os.ErrClosed
+	}
+
+	err := s.w.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.w = nil
+	return err
+}
@@ -0,0 +1,321 @@
+package kv4pht
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/raff/kv4p-go/dsp"
+)
+
+// sub-audible tone detection
+const (
+	subtoneRate   = 1000            // Hz, the audio is low-pass filtered and decimated to this rate
+	subtoneCutoff = 300             // Hz
+	ctcssWindow   = 2 * subtoneRate // samples analyzed (2 seconds, for a 0.5 Hz resolution)
+	ctcssHop      = subtoneRate / 4 // one analysis every 250ms
+	ctcssLevel    = 0.005           // minimum amplitude of the tone (0-1)
+	ctcssMargin   = 10.0            // the tone must be stronger than any other by this factor
+	ctcssFraction = 0.1             // minimum fraction of the low-pass filtered energy in the tone
+	ctcssMisses   = 4               // analyses without the tone before it's considered gone
+
+	DCS_BAUD   = 134.4
+	dcsBits    = 23
+	dcsMisses  = 3 // codewords without a match before the code is considered gone
+	dcsInertia = 0.5
+)
+
+// dcsCodeword returns the 23 bits DCS codeword for the code (the digits of the octal code, i.e. 23 for "023"):
+// the 9 bits of the code, 100 and the 11 bits of the Golay (23,12) parity, transmitted from the LSB.
+func dcsCodeword(code int, inverted bool) uint32 {
+	octal, _ := strconv.ParseInt(strconv.Itoa(code), 8, 32)
+
+	data := uint32(octal) | 0x800
+	word := data
+	for range 12 {
+		word <<= 1
+		if word&0x1000 != 0 {
+			word ^= 0x08EA
+		}
+	}
+
+	cw := data | (word&0x0FFE)<<11
+	if inverted {
+		cw ^= 1<<dcsBits - 1
+	}
+	return cw
+}
+
+// dcsCodewords maps the codewords to the DCS tones, in both polarities
+var dcsCodewords = func() map[uint32]Tone {
+	m := map[uint32]Tone{}
+	for _, code := range DCS_CODES {
+		m[dcsCodeword(code, false)] = Tone{Type: ToneDCS, DCS: code}
+		m[dcsCodeword(code, true)] = Tone{Type: ToneDCS, DCS: code, Inverted: true}
+	}
+	return m
+}()
+
+// ToneDetector identifies the CTCSS tone or DCS code in the received audio.
+//
+// The radio module removes the sub-audible tones with its high-pass filter, and with an RX tone set:
+// disable the filter with SendFilters, and use no RX tone, for the detection to work.
+type ToneDetector struct {
+	mu sync.Mutex
+
+	// low-pass filter (4th order Butterworth) and decimation
+	filters  [2]*dsp.Biquad
+	decimate int
+	phase    int
+	rate     float64
+
+	// CTCSS: Goertzel on the last ctcssWindow samples
+	samples []float64
+	pos     int
+	filled  bool
+	hop     int
+	window  []float64 // Hann
+	coeffs  []float64
+	ctcss   Tone // candidate of the last analysis
+	found   Tone // confirmed tone
+	misses  int
+
+	// DCS: clock recovery and codeword matching
+	dc         float64
+	last       bool
+	pll        float64
+	word       uint32
+	bits       int
+	dcs        Tone         // confirmed code
+	dcsSeen    map[Tone]int // bit count when each code was last seen
+	candidates []Tone       // codes seen twice in a row, in the current codeword period
+	confirming bool         // candidates were seen in the previous period
+	dcsMisses  int
+
+	current Tone
+	onTone  func(ToneEvent)
+}
+
+// NewToneDetector returns a ToneDetector for audio at sampleRate, that calls onTone when the detected tone changes.
+// onTone is called from WriteAudio, so it should not block.
+func NewToneDetector(sampleRate int, onTone func(ToneEvent)) *ToneDetector {
+	decimate := max(1, sampleRate/subtoneRate)
+	d := &ToneDetector{
+		filters: [2]*dsp.Biquad{
+			dsp.LowPass(sampleRate, subtoneCutoff, 0.5412),
+			dsp.LowPass(sampleRate, subtoneCutoff, 1.3066),
+		},
+		decimate: decimate,
+		rate:     float64(sampleRate) / float64(decimate),
+		samples:  make([]float64, ctcssWindow),
+		window:   make([]float64, ctcssWindow),
+		dcsSeen:  map[Tone]int{},
+		onTone:   onTone,
+	}
+
+	for i := range d.window {
+		d.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(ctcssWindow-1))
+	}
+	for _, f := range CTCSS_TONES {
+		d.coeffs = append(d.coeffs, 2*math.Cos(2*math.Pi*f/d.rate))
+	}
+	return d
+}
+
+// WriteAudio analyzes the samples. It implements AudioSink.
+func (d *ToneDetector) WriteAudio(samples []int16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range samples {
+		x := float64(s) / math.MaxInt16
+		for _, f := range d.filters {
+			x = f.Filter(x)
+		}
+
+		if d.phase++; d.phase < d.decimate {
+			continue
+		}
+		d.phase = 0
+
+		d.sample(x)
+	}
+
+	return nil
+}
+
+// sample processes a decimated sample
+func (d *ToneDetector) sample(x float64) {
+	d.samples[d.pos] = x
+	if d.pos = (d.pos + 1) % len(d.samples); d.pos == 0 {
+		d.filled = true
+	}
+
+	if d.hop++; d.hop == ctcssHop {
+		d.hop = 0
+		if d.filled {
+			d.analyze()
+		}
+	}
+
+	d.dcsSample(x)
+}
+
+// analyze looks for a CTCSS tone in the last window
+func (d *ToneDetector) analyze() {
+	var energy, sumw float64
+	power := make([]float64, len(d.coeffs))
+	s1 := make([]float64, len(d.coeffs))
+	s2 := make([]float64, len(d.coeffs))
+
+	for i := range d.samples {
+		x := d.samples[(d.pos+i)%len(d.samples)] * d.window[i]
+		energy += x * x
+		sumw += d.window[i]
+
+		for j, c := range d.coeffs {
+			s0 := x + c*s1[j] - s2[j]
+			s2[j], s1[j] = s1[j], s0
+		}
+	}
+
+	best, second := 0, -1
+	for j, c := range d.coeffs {
+		power[j] = s1[j]*s1[j] + s2[j]*s2[j] - c*s1[j]*s2[j]
+		if power[j] > power[best] {
+			best = j
+		}
+	}
+	for j := range power {
+		if j != best && (second < 0 || power[j] > power[second]) {
+			second = j
+		}
+	}
+
+	// amplitude of the tone, and fraction of the energy in it (1 for a pure tone)
+	var sumw2 float64
+	for _, w := range d.window {
+		sumw2 += w * w
+	}
+	amplitude := 2 * math.Sqrt(power[best]) / sumw
+	fraction := 0.0
+	if energy > 0 {
+		fraction = power[best] / (energy * sumw * sumw / (2 * sumw2))
+	}
+
+	tone := Tone{}
+	if amplitude > ctcssLevel && fraction > ctcssFraction && power[best] > ctcssMargin*power[second] {
+		tone = Tone{Type: ToneCTCSS, Freq: CTCSS_TONES[best]}
+	}
+
+	switch {
+	case tone.Type != ToneNone && tone == d.ctcss:
+		// the same tone in two consecutive analyses
+		d.found, d.misses = tone, 0
+	case d.found.Type != ToneNone && tone != d.found:
+		if d.misses++; d.misses >= ctcssMisses {
+			d.found, d.misses = Tone{}, 0
+		}
+	}
+	d.ctcss = tone
+
+	d.update()
+}
+
+// dcsSample recovers the DCS bits, and matches the last 23 with the codewords
+func (d *ToneDetector) dcsSample(x float64) {
+	d.dc += (x - d.dc) * 0.002
+	level := x > d.dc
+
+	// clock recovery: move the sampling point half a bit away from the transitions
+	if level != d.last {
+		d.pll *= dcsInertia
+	}
+	d.last = level
+
+	if d.pll += DCS_BAUD / d.rate; d.pll < 0.5 {
+		return
+	}
+	d.pll -= 1
+
+	bit := uint32(0)
+	if level {
+		bit = 1
+	}
+	d.word = d.word>>1 | bit<<(dcsBits-1)
+	d.bits++
+
+	if tone, ok := dcsCodewords[d.word]; ok {
+		if seen, ok := d.dcsSeen[tone]; ok && d.bits-seen == dcsBits {
+			// the same codeword twice in a row
+			d.candidates = append(d.candidates, tone)
+		}
+		d.dcsSeen[tone] = d.bits
+	}
+
+	if d.bits%dcsBits != 0 {
+		return
+	}
+
+	switch {
+	case d.dcs.Type != ToneNone && d.bits-d.dcsSeen[d.dcs] <= dcsBits:
+		d.dcsMisses = 0
+
+	case d.dcs.Type != ToneNone:
+		if d.dcsMisses++; d.dcsMisses >= dcsMisses {
+			d.dcs, d.dcsMisses = Tone{}, 0
+			clear(d.dcsSeen)
+		}
+
+	case len(d.candidates) > 0 && !d.confirming:
+		// wait another period: the codewords of some codes are rotations of the ones of others
+		// (i.e. D023N and D047I), and they may be seen twice in a row in different periods
+		d.confirming = true
+
+	case len(d.candidates) > 0:
+		// prefer the normal polarity, then the lowest code
+		d.dcs = slices.MinFunc(d.candidates, func(a, b Tone) int {
+			if a.Inverted != b.Inverted {
+				if a.Inverted {
+					return 1
+				}
+				return -1
+			}
+			return a.DCS - b.DCS
+		})
+		d.confirming = false
+
+	default:
+		d.confirming = false
+	}
+	d.candidates = d.candidates[:0]
+
+	d.update()
+}
+
+// update reports the detected tone, if it changed. DCS has precedence, because its spectrum may look like a CTCSS tone.
+func (d *ToneDetector) update() {
+	tone := d.found
+	if d.dcs.Type != ToneNone {
+		tone = d.dcs
+	}
+
+	if tone != d.current {
+		d.current = tone
+		d.onTone(ToneEvent{Tone: tone})
+	}
+}
+
+// Tone returns the tone currently detected (the zero Tone if none)
+func (d *ToneDetector) Tone() Tone {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current
+}
+
+// DetectTones adds a ToneDetector to the received audio, that sends a ToneEvent to the subscribers when the
+// detected CTCSS tone or DCS code changes. It returns a function to remove it.
+func (p *CommandProcessor) DetectTones() (remove func()) {
+	return p.AddSink(NewToneDetector(AUDIO_SAMPLING_RATE, func(ev ToneEvent) { p.emit(ev) }))
+}
@@ -0,0 +1,204 @@
+// Package tnc turns the radio into a packet TNC: the received audio is demodulated with the AFSK 1200 modem,
+// and the queued frames are modulated and transmitted, with CSMA channel access.
+//
+// Frames are AX.25 frames without the HDLC flags and FCS, as used by KISS (see packages ax25 and kiss).
+package tnc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/afsk"
+	"github.com/raff/kv4p-go/kiss"
+)
+
+var ErrQueueFull = fmt.Errorf("Transmit queue full")
+
+const (
+	queueSize    = 32
+	maxFrames    = 8 // frames sent in a single transmission
+	maxFrameSize = 1024
+)
+
+// Config contains the TNC settings. Zero values select the defaults.
+// The settings can be changed by the KISS clients, with SetParameter.
+type Config struct {
+	TxDelay    time.Duration // flags sent before the frames, to let the receivers open (default: 300ms)
+	TxTail     time.Duration // flags sent after the frames (default: 30ms)
+	SlotTime   time.Duration // wait between channel access attempts (default: 100ms)
+	Persist    int           // probability (0-255) of transmitting when the channel is clear (default: 63)
+	FullDuplex bool          // transmit without waiting for the channel to be clear
+
+	Amplitude float64 // of the transmitted audio, 0-1 (default: afsk.DefaultAmplitude)
+
+	// OnFrame, if set, is called with each received frame. It's called from the audio path, so it should not block.
+	OnFrame func(frame []byte)
+}
+
+// TNC receives and transmits frames with a CommandProcessor
+type TNC struct {
+	mu     sync.Mutex // protects config
+	config Config
+
+	demod *afsk.Demodulator
+	queue chan []byte
+}
+
+// New returns a TNC with the specified configuration
+func New(config Config) *TNC {
+	if config.TxDelay == 0 {
+		config.TxDelay = 300 * time.Millisecond
+	}
+	if config.TxTail == 0 {
+		config.TxTail = 30 * time.Millisecond
+	}
+	if config.SlotTime == 0 {
+		config.SlotTime = 100 * time.Millisecond
+	}
+	if config.Persist == 0 {
+		config.Persist = 63
+	}
+
+	t := &TNC{config: config, queue: make(chan []byte, queueSize)}
+	t.demod = afsk.NewDemodulator(kv4pht.AUDIO_SAMPLING_RATE, t.receive)
+	return t
+}
+
+func (t *TNC) receive(frame []byte) {
+	if t.config.OnFrame != nil {
+		t.config.OnFrame(frame)
+	}
+}
+
+// Send queues a frame for transmission
+func (t *TNC) Send(frame []byte) error {
+	if len(frame) == 0 || len(frame) > maxFrameSize {
+		return fmt.Errorf("Invalid frame size %d", len(frame))
+	}
+
+	select {
+	case t.queue <- append([]byte(nil), frame...):
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// SetParameter sets a KISS parameter (kiss.CMD_TXDELAY, kiss.CMD_PERSIST, ...). Times are in 10ms units.
+func (t *TNC) SetParameter(cmd byte, value byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	units := time.Duration(value) * 10 * time.Millisecond
+
+	switch cmd {
+	case kiss.CMD_TXDELAY:
+		t.config.TxDelay = units
+	case kiss.CMD_PERSIST:
+		t.config.Persist = int(value)
+	case kiss.CMD_SLOTTIME:
+		t.config.SlotTime = units
+	case kiss.CMD_TXTAIL:
+		t.config.TxTail = units
+	case kiss.CMD_FULLDUPLEX:
+		t.config.FullDuplex = value != 0
+	default:
+		return
+	}
+
+	log.Printf("TNC: set parameter %d to %d", cmd, value)
+}
+
+// Config returns the current settings
+func (t *TNC) Config() Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.config
+}
+
+// DCD returns true when a packet signal is being received
+func (t *TNC) DCD() bool {
+	return t.demod.DCD()
+}
+
+// Run receives frames from p and transmits the queued frames, until the context is done or p is stopped
+func (t *TNC) Run(ctx context.Context, p *kv4pht.CommandProcessor) error {
+	remove := p.AddSink(t.demod)
+	defer remove()
+
+	// the events channel is closed when p is stopped
+	events, cancel := p.Subscribe(1)
+	defer cancel()
+
+	for {
+		var frames [][]byte
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+			continue
+		case f := <-t.queue:
+			frames = append(frames, f)
+		}
+
+		if err := t.transmit(ctx, p, frames); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Println("TNC: transmit:", err)
+		}
+	}
+}
+
+// transmit waits for the channel to be clear and transmits the frames, and the ones queued in the meantime
+func (t *TNC) transmit(ctx context.Context, p *kv4pht.CommandProcessor, frames [][]byte) error {
+	config := t.Config()
+
+	for !config.FullDuplex {
+		if !t.demod.DCD() && rand.Intn(256) <= config.Persist {
+			break
+		}
+
+		timer := time.NewTimer(config.SlotTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+queued:
+	for len(frames) < maxFrames {
+		select {
+		case f := <-t.queue:
+			frames = append(frames, f)
+		default:
+			break queued
+		}
+	}
+
+	m := afsk.NewModulator(kv4pht.AUDIO_SAMPLING_RATE)
+	m.Preamble = flags(config.TxDelay)
+	m.Postamble = flags(config.TxTail)
+	if config.Amplitude > 0 {
+		m.Amplitude = config.Amplitude
+	}
+
+	log.Printf("TNC: transmitting %d frames", len(frames))
+	return p.Transmit(ctx, m.Modulate(frames...))
+}
+
+// flags returns the number of flags sent in d
+func flags(d time.Duration) int {
+	return max(1, int(d*afsk.Baud/8/time.Second))
+}
@@ -0,0 +1,97 @@
+package tnc
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kv4pht "github.com/raff/kv4p-go"
+	"github.com/raff/kv4p-go/afsk"
+	"github.com/raff/kv4p-go/ax25"
+	"github.com/raff/kv4p-go/kiss"
+	"github.com/raff/kv4p-go/simulator"
+)
+
+func TestTNC(t *testing.T) {
+	rxFrame, _ := ax25.Parse("N0CALL-1>APRS,WIDE1-1:>received")
+	txFrame, _ := ax25.Parse("N0CALL>APRS:>transmitted")
+
+	rx := rxFrame.Encode()
+	tx := txFrame.Encode()
+
+	// a station sending rx every second
+	m := afsk.NewModulator(kv4pht.AUDIO_SAMPLING_RATE)
+	packet := append(m.Modulate(rx), make([]int16, kv4pht.AUDIO_SAMPLING_RATE)...)
+
+	var mu sync.Mutex
+	var transmitted []byte
+	demod := afsk.NewDemodulator(kv4pht.AUDIO_SAMPLING_RATE, func(frame []byte) {
+		mu.Lock()
+		transmitted = frame
+		mu.Unlock()
+	})
+
+	d := simulator.New()
+	d.Carriers = []simulator.Carrier{{Freq: 144.39, Level: 200, Source: simulator.Samples(packet)}}
+	d.TxAudio = func(samples []int16) { demod.WriteAudio(samples) }
+
+	host, device := kv4pht.Pipe()
+	go d.Run(device)
+
+	p, err := kv4pht.NewCommandProcessor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		p.Stop()
+		device.Close()
+	}()
+
+	if err := p.SendGroup(0, 144.39, 144.39, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 10)
+	tnc := New(Config{OnFrame: func(frame []byte) { received <- frame }, TxDelay: 100 * time.Millisecond})
+
+	tnc.SetParameter(kiss.CMD_PERSIST, 255)
+	if c := tnc.Config(); c.Persist != 255 || c.TxDelay != 100*time.Millisecond {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go tnc.Run(ctx, p)
+
+	select {
+	case f := <-received:
+		if !bytes.Equal(f, rx) {
+			t.Errorf("unexpected frame received % x", f)
+		}
+	case <-ctx.Done():
+		t.Fatal("no frame received")
+	}
+
+	if err := tnc.Send(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	for ctx.Err() == nil {
+		mu.Lock()
+		done := transmitted != nil
+		mu.Unlock()
+
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !bytes.Equal(transmitted, tx) {
+		t.Errorf("unexpected frame transmitted % x", transmitted)
+	}
+}
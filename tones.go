@@ -0,0 +1,158 @@
+package kv4pht
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type ToneType byte
+
+const (
+	ToneNone  ToneType = iota // no tone (carrier squelch)
+	ToneCTCSS                 // sub-audible tone (CTCSS)
+	ToneDCS                   // digital coded squelch (DCS)
+)
+
+var (
+	// Standard CTCSS tones, in Hz.
+	// The position in the table + 1 is the code used by the DRA818/SA818 modules (0 means no tone).
+	CTCSS_TONES = []float64{
+		67.0, 71.9, 74.4, 77.0, 79.7, 82.5, 85.4, 88.5, 91.5, 94.8,
+		97.4, 100.0, 103.5, 107.2, 110.9, 114.8, 118.8, 123.0, 127.3, 131.8,
+		136.5, 141.3, 146.2, 151.4, 156.7, 162.2, 167.9, 173.8, 179.9, 186.2,
+		192.8, 203.5, 210.7, 218.1, 225.7, 233.6, 241.8, 250.3,
+	}
+
+	// Standard DCS codes, written as the digits of the octal code (i.e. 23 is "023")
+	DCS_CODES = []int{
+		23, 25, 26, 31, 32, 36, 43, 47, 51, 53, 54, 65, 71, 72, 73, 74,
+		114, 115, 116, 122, 125, 131, 132, 134, 143, 145, 152, 155, 156, 162, 165, 172, 174,
+		205, 212, 223, 225, 226, 243, 244, 245, 246, 251, 252, 255, 261, 263, 265, 266, 271, 274,
+		306, 311, 315, 325, 331, 332, 343, 346, 351, 356, 364, 365, 371,
+		411, 412, 413, 423, 431, 432, 445, 446, 452, 454, 455, 462, 464, 465, 466,
+		503, 506, 516, 523, 526, 532, 546, 565,
+		606, 612, 624, 627, 631, 632, 654, 662, 664,
+		703, 712, 723, 731, 732, 734, 743, 754,
+	}
+
+	ErrInvalidTone     = fmt.Errorf("Invalid tone")
+	ErrToneUnsupported = fmt.Errorf("Tone not supported by the radio module")
+)
+
+// Tone is a CTCSS or DCS squelch tone. The zero value means no tone.
+type Tone struct {
+	Type     ToneType
+	Freq     float64 // CTCSS frequency in Hz
+	DCS      int     // DCS code, as the digits of the octal code (i.e. 23 for "023")
+	Inverted bool    // DCS polarity
+}
+
+// CTCSS returns the CTCSS tone for the specified frequency (in Hz), that must be one of CTCSS_TONES
+func CTCSS(freq float64) (Tone, error) {
+	for _, f := range CTCSS_TONES {
+		if math.Abs(f-freq) < 0.05 {
+			return Tone{Type: ToneCTCSS, Freq: f}, nil
+		}
+	}
+
+	return Tone{}, fmt.Errorf("%w: CTCSS %.1f", ErrInvalidTone, freq)
+}
+
+// DCS returns the DCS tone for the specified code (one of DCS_CODES) and polarity
+func DCS(code int, inverted bool) (Tone, error) {
+	for _, c := range DCS_CODES {
+		if c == code {
+			return Tone{Type: ToneDCS, DCS: code, Inverted: inverted}, nil
+		}
+	}
+
+	return Tone{}, fmt.Errorf("%w: DCS %03d", ErrInvalidTone, code)
+}
+
+// ParseTone parses a tone in one of the following formats:
+//
+//	"", "none", "off" - no tone
+//	"88.5"            - CTCSS tone in Hz
+//	"D023N", "023N"   - DCS code, normal polarity ("D023" is also accepted)
+//	"D023I", "023I"   - DCS code, inverted polarity
+//
+// DCS codes are parsed (i.e. for the channel files and the tone detection),
+// but they can't be sent to the radio module: see Index.
+func ParseTone(s string) (Tone, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	switch {
+	case s == "" || s == "NONE" || s == "OFF":
+		return Tone{}, nil
+
+	case strings.HasPrefix(s, "D") || strings.HasSuffix(s, "N") || strings.HasSuffix(s, "I"):
+		inverted := strings.HasSuffix(s, "I")
+		code, err := strconv.Atoi(strings.TrimRight(strings.TrimPrefix(s, "D"), "NI"))
+		if err != nil {
+			return Tone{}, fmt.Errorf("%w: %q", ErrInvalidTone, s)
+		}
+		return DCS(code, inverted)
+
+	default:
+		freq, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Tone{}, fmt.Errorf("%w: %q", ErrInvalidTone, s)
+		}
+		return CTCSS(freq)
+	}
+}
+
+func (t Tone) String() string {
+	switch t.Type {
+	case ToneCTCSS:
+		return fmt.Sprintf("%.1f", t.Freq)
+	case ToneDCS:
+		if t.Inverted {
+			return fmt.Sprintf("D%03dI", t.DCS)
+		}
+		return fmt.Sprintf("D%03dN", t.DCS)
+	default:
+		return "none"
+	}
+}
+
+// MarshalText encodes the tone in the format accepted by ParseTone ("" for no tone)
+func (t Tone) MarshalText() ([]byte, error) {
+	if t.Type == ToneNone {
+		return []byte{}, nil
+	}
+	return []byte(t.String()), nil
+}
+
+func (t *Tone) UnmarshalText(text []byte) error {
+	tone, err := ParseTone(string(text))
+	if err != nil {
+		return err
+	}
+	*t = tone
+	return nil
+}
+
+// Index returns the DRA818/SA818 code for the tone, as sent in the GROUP command.
+//
+// The firmware formats the code as a 4 digits CTCSS index ("0000" to "0038"), so DCS tones,
+// that the modules only accept as "023N"/"023I" strings, are not supported.
+func (t Tone) Index() (byte, error) {
+	switch t.Type {
+	case ToneNone:
+		return 0, nil
+
+	case ToneCTCSS:
+		for i, f := range CTCSS_TONES {
+			if math.Abs(f-t.Freq) < 0.05 {
+				return byte(i + 1), nil
+			}
+		}
+		return 0, fmt.Errorf("%w: CTCSS %.1f", ErrInvalidTone, t.Freq)
+
+	default:
+		return 0, fmt.Errorf("%w: %v", ErrToneUnsupported, t)
+	}
+}
@@ -0,0 +1,194 @@
+package kv4pht
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// Transport is the connection to the device
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// Drainer is implemented by transports that can wait for the written data to be transmitted
+type Drainer interface {
+	Drain() error
+}
+
+// ModemControl is implemented by transports that can control the DTR and RTS lines (used to reset the board)
+type ModemControl interface {
+	SetDTR(dtr bool) error
+	SetRTS(rts bool) error
+}
+
+// USBID identifies a USB device model, with the vendor and product IDs in hex (i.e. "10C4", "EA60")
+type USBID struct {
+	VID, PID string
+}
+
+// DeviceIDs are the USB-serial adapters of the supported ESP32 boards, used to find the devices.
+// Add to it (or use ConnectOptions.DeviceIDs) for boards with other adapters.
+var DeviceIDs = []USBID{
+	{"10C4", "EA60"}, // CP210x, i.e. https://www.amazon.com/gp/product/B08D5ZD528
+	{"1A86", "7523"}, // CH340
+}
+
+// DeviceInfo describes a serial port with a supported device
+type DeviceInfo struct {
+	Port    string // serial port name, i.e. /dev/ttyUSB0 or COM3
+	VID     string
+	PID     string
+	Serial  string // USB serial number: unlike the port name, it doesn't change when the device is reconnected
+	Product string // description of the port, if available
+	InUse   bool   // the port is open in this process
+}
+
+// Discover returns the serial ports with a device matching one of ids (default: DeviceIDs)
+func Discover(ids ...USBID) ([]DeviceInfo, error) {
+	if len(ids) == 0 {
+		ids = DeviceIDs
+	}
+
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []DeviceInfo
+	for _, port := range ports {
+		if port.IsUSB && slices.ContainsFunc(ids, func(id USBID) bool {
+			return strings.EqualFold(port.VID, id.VID) && strings.EqualFold(port.PID, id.PID)
+		}) {
+			devices = append(devices, DeviceInfo{
+				Port:    port.Name,
+				VID:     strings.ToUpper(port.VID),
+				PID:     strings.ToUpper(port.PID),
+				Serial:  port.SerialNumber,
+				Product: port.Product,
+				InUse:   portInUse(port.Name),
+			})
+		}
+	}
+
+	return devices, nil
+}
+
+// OpenTransport opens the transport specified by name:
+//
+//	""                - the first serial port with an ESP32 device (see DeviceIDs), not already open in this process
+//	"usb:SERIAL"      - the serial port with the ESP32 device with the specified USB serial number
+//	"tcp://host:port" - a TCP connection (i.e. to a serial port server or a simulator)
+//	anything else     - a serial port (e.g. /dev/ttyUSB0)
+func OpenTransport(name string) (Transport, error) {
+	return openTransport(name, DeviceIDs)
+}
+
+func openTransport(name string, ids []USBID) (Transport, error) {
+	if addr, ok := strings.CutPrefix(name, "tcp://"); ok {
+		return DialTCP(addr)
+	}
+
+	if number, ok := strings.CutPrefix(name, "usb:"); ok || name == "" {
+		devices, err := Discover(ids...)
+		if err != nil {
+			return nil, err
+		}
+
+		device, err := selectDevice(devices, number)
+		if err != nil {
+			return nil, err
+		}
+
+		if Debug {
+			log.Printf("Found ESP32 device: %s (serial number %s)\n", device.Port, device.Serial)
+		}
+		name = device.Port
+	}
+
+	return OpenSerial(name)
+}
+
+// selectDevice returns the device with the specified serial number or, if empty, the first one not in use
+func selectDevice(devices []DeviceInfo, serial string) (DeviceInfo, error) {
+	for _, d := range devices {
+		if serial != "" && d.Serial == serial || serial == "" && !d.InUse {
+			return d, nil
+		}
+	}
+
+	if serial != "" {
+		return DeviceInfo{}, fmt.Errorf("%w with serial number %q", ErrNoDevice, serial)
+	}
+	return DeviceInfo{}, ErrNoDevice
+}
+
+// ports open in this process
+var openPorts = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+func portInUse(name string) bool {
+	openPorts.Lock()
+	defer openPorts.Unlock()
+	return openPorts.names[name]
+}
+
+// serialPort removes the port from openPorts when closed
+type serialPort struct {
+	serial.Port
+	name string
+	once sync.Once
+}
+
+func (p *serialPort) Close() error {
+	p.once.Do(func() {
+		openPorts.Lock()
+		delete(openPorts.names, p.name)
+		openPorts.Unlock()
+	})
+	return p.Port.Close()
+}
+
+// OpenSerial opens the named serial port, with the settings expected by the device.
+// The returned transport also implements Drainer and ModemControl.
+func OpenSerial(portName string) (Transport, error) {
+	smode := &serial.Mode{
+		BaudRate: 115200,
+		DataBits: 8,
+		StopBits: serial.OneStopBit,
+		Parity:   serial.NoParity,
+	}
+
+	port, err := serial.Open(portName, smode)
+	if err != nil {
+		return nil, err
+	}
+
+	openPorts.Lock()
+	openPorts.names[portName] = true
+	openPorts.Unlock()
+
+	return &serialPort{Port: port, name: portName}, nil
+}
+
+// DialTCP connects to a device exposed over TCP (host:port)
+func DialTCP(addr string) (Transport, error) {
+	return net.Dial("tcp", addr)
+}
+
+// Pipe returns the two ends of an in-memory, synchronous connection.
+// The first one is meant for the CommandProcessor and the second one for a (simulated) device.
+//
+// Writes block until the data is read from the other end, so both ends should be read continuously.
+func Pipe() (Transport, Transport) {
+	return net.Pipe()
+}
@@ -0,0 +1,206 @@
+// Package wav reads and writes 16-bit PCM WAV files
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidFormat     = fmt.Errorf("Invalid WAV file")
+	ErrUnsupportedFormat = fmt.Errorf("Unsupported WAV format (only 16-bit PCM)")
+)
+
+const formatPCM = 1
+
+// Reader reads the samples from a 16-bit PCM WAV file
+type Reader struct {
+	SampleRate int
+	Channels   int
+
+	r         io.Reader
+	remaining int // bytes left in the data chunk
+}
+
+// NewReader parses the WAV header and returns a Reader positioned at the start of the samples
+func NewReader(r io.Reader) (*Reader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrInvalidFormat
+	}
+
+	wr := &Reader{r: r}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if err == io.EOF {
+				err = ErrInvalidFormat
+			}
+			return nil, err
+		}
+
+		id := string(chunk[0:4])
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, ErrInvalidFormat
+			}
+
+			fmtChunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, err
+			}
+
+			format := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			bits := binary.LittleEndian.Uint16(fmtChunk[14:16])
+			if format != formatPCM || bits != 16 {
+				return nil, ErrUnsupportedFormat
+			}
+
+			wr.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			wr.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+
+		case "data":
+			if wr.SampleRate == 0 {
+				return nil, ErrInvalidFormat
+			}
+
+			wr.remaining = size
+			return wr, nil
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Read reads interleaved samples (one per channel for each frame)
+func (r *Reader) Read(samples []int16) (int, error) {
+	if r.remaining < 2 {
+		return 0, io.EOF
+	}
+
+	n := min(len(samples), r.remaining/2)
+	buf := make([]byte, n*2)
+	n, err := io.ReadFull(r.r, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	n /= 2
+	for i := 0; i < n; i++ {
+		samples[i] = int16(binary.LittleEndian.Uint16(buf[i*2:]))
+	}
+
+	r.remaining -= n * 2
+	return n, err
+}
+
+// ReadAll reads all the remaining samples
+func (r *Reader) ReadAll() ([]int16, error) {
+	samples := make([]int16, r.remaining/2)
+
+	n := 0
+	for n < len(samples) {
+		l, err := r.Read(samples[n:])
+		n += l
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return samples[:n], err
+		}
+	}
+
+	return samples[:n], nil
+}
+
+// Writer writes samples to a 16-bit PCM WAV file.
+// The header is updated with the final sizes when the Writer is closed.
+type Writer struct {
+	w        io.WriteSeeker
+	channels int
+	size     int // bytes of samples written
+}
+
+// NewWriter writes the WAV header to w and returns a Writer for the samples
+func NewWriter(w io.WriteSeeker, sampleRate, channels int) (*Writer, error) {
+	var header [44]byte
+
+	copy(header[0:], "RIFF")
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], formatPCM)
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*channels*2)) // byte rate
+	binary.LittleEndian.PutUint16(header[32:], uint16(channels*2))            // block align
+	binary.LittleEndian.PutUint16(header[34:], 16)                            // bits per sample
+	copy(header[36:], "data")
+
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+
+	wr := &Writer{w: w, channels: channels}
+	if err := wr.updateHeader(); err != nil {
+		return nil, err
+	}
+
+	return wr, nil
+}
+
+// Write writes interleaved samples (one per channel for each frame)
+func (w *Writer) Write(samples []int16) (int, error) {
+	buf := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(s))
+	}
+
+	n, err := w.w.Write(buf)
+	w.size += n
+	return n / 2, err
+}
+
+// Frames returns the number of frames (samples per channel) written so far
+func (w *Writer) Frames() int {
+	return w.size / 2 / w.channels
+}
+
+// Close updates the header with the final sizes. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.updateHeader()
+}
+
+func (w *Writer) updateHeader() error {
+	var size [4]byte
+
+	binary.LittleEndian.PutUint32(size[:], uint32(36+w.size))
+	if _, err := w.w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(size[:]); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(size[:], uint32(w.size))
+	if _, err := w.w.Seek(40, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(size[:]); err != nil {
+		return err
+	}
+
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,76 @@
+package kv4pht
+
+import (
+	"context"
+	"sync"
+)
+
+// window implements the credit based flow control used to send commands to the device.
+//
+// The device reports the size of its receive buffer in RES_VERSION and returns credits
+// with RES_WINDOW_UPDATE as it consumes the data. Senders block in acquire until enough
+// credits are available.
+type window struct {
+	mu      sync.Mutex
+	size    int           // total window size, as reported by the device
+	credits int           // bytes that can be sent without waiting
+	changed chan struct{} // closed (and replaced) every time credits are added
+}
+
+func newWindow(size int) *window {
+	return &window{size: size, credits: size, changed: make(chan struct{})}
+}
+
+// set resets the window to the specified size (all credits available)
+func (w *window) set(size int) {
+	w.mu.Lock()
+	w.size = size
+	w.credits = size
+	w.notify()
+	w.mu.Unlock()
+}
+
+// add returns n credits to the window
+func (w *window) add(n int) {
+	w.mu.Lock()
+	w.credits += n
+	w.notify()
+	w.mu.Unlock()
+}
+
+// available returns the current credits and the window size
+func (w *window) available() (int, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.credits, w.size
+}
+
+// must be called with the lock held
+func (w *window) notify() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// acquire waits until n credits are available and takes them.
+//
+// A request larger than the whole window is granted when the window is completely free,
+// otherwise it would never be satisfied. All the n credits are taken anyway, leaving the window negative:
+// the device returns them all as it consumes the data, and the next senders wait until it does.
+func (w *window) acquire(ctx context.Context, n int) error {
+	for {
+		w.mu.Lock()
+		if need := min(n, w.size); w.credits >= need {
+			w.credits -= n
+			w.mu.Unlock()
+			return nil
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}